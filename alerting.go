@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Number of recent extractions the failure rate is computed over
+	// (EXTRACTION_FAILURE_WINDOW); 0 turns the alert off
+	failureRateWindow = 50
+
+	// Failure rate above which the alert fires (EXTRACTION_FAILURE_THRESHOLD), from 0 to 1
+	failureRateThreshold = 0.5
+)
+
+// While the rate stays high the alert repeats, waiting twice as long each time
+const (
+	failureAlertInitialInterval = time.Minute
+	failureAlertMaxInterval     = time.Hour
+)
+
+var (
+	failureRateAlerts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "extraction_failure_rate_alerts_total",
+		Help: "Times the rolling extraction failure rate was logged as above the threshold.",
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "extraction_failure_rate",
+		Help: "Share of failed extractions among the most recent ones.",
+	}, func() float64 { return extractionOutcomes.rate() })
+)
+
+var extractionOutcomes = &outcomeWindow{}
+
+// outcomeWindow keeps the results of the last failureRateWindow extractions
+// and raises the alert when too many of them failed
+type outcomeWindow struct {
+	mu       sync.Mutex
+	failed   []bool // ring buffer, oldest entry at next once full
+	next     int
+	failures int
+
+	alerting  bool
+	nextAlert time.Time
+	interval  time.Duration
+}
+
+// record adds one outcome and logs the alert if the window now fails too often
+func (w *outcomeWindow) record(failed bool) {
+	if failureRateWindow <= 0 {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.failed) < failureRateWindow {
+		w.failed = append(w.failed, failed)
+	} else {
+		if w.failed[w.next] {
+			w.failures--
+		}
+		w.failed[w.next] = failed
+		w.next = (w.next + 1) % failureRateWindow
+	}
+	if failed {
+		w.failures++
+	}
+
+	// A handful of early failures after a restart isn't a trend
+	if len(w.failed) < failureRateWindow {
+		return
+	}
+
+	rate := float64(w.failures) / float64(len(w.failed))
+	now := time.Now()
+	switch {
+	case rate > failureRateThreshold && (!w.alerting || !now.Before(w.nextAlert)):
+		if !w.alerting {
+			w.interval = failureAlertInitialInterval
+		} else {
+			w.interval = min(w.interval*2, failureAlertMaxInterval)
+		}
+		w.alerting = true
+		w.nextAlert = now.Add(w.interval)
+		failureRateAlerts.Inc()
+		slog.Error("Extraction failure rate above threshold",
+			"alert", "extraction_failure_rate",
+			"failure_rate", rate,
+			"threshold", failureRateThreshold,
+			"failures", w.failures,
+			"window", len(w.failed),
+			"next_alert_in", w.interval.String())
+
+	case rate <= failureRateThreshold && w.alerting:
+		w.alerting = false
+		slog.Info("Extraction failure rate back below threshold",
+			"alert", "extraction_failure_rate",
+			"failure_rate", rate,
+			"threshold", failureRateThreshold)
+	}
+}
+
+// rate returns the failure rate over the outcomes recorded so far
+func (w *outcomeWindow) rate() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.failed) == 0 {
+		return 0
+	}
+	return float64(w.failures) / float64(len(w.failed))
+}
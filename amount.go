@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Currency symbols the model tends to copy from receipts. "$" and "¥" are
+// ambiguous, so they map to the most common reading and an explicit code wins.
+var currencySymbols = map[string]string{
+	"₩":   "KRW",
+	"€":   "EUR",
+	"£":   "GBP",
+	"¥":   "JPY",
+	"₽":   "RUB",
+	"₴":   "UAH",
+	"₫":   "VND",
+	"₹":   "INR",
+	"US$": "USD",
+	"$":   "USD",
+}
+
+// Currencies without minor units (ISO 4217 exponent 0); everything else uses 2
+var zeroDecimalCurrencies = map[string]bool{
+	"JPY": true,
+	"KRW": true,
+	"VND": true,
+}
+
+// normalizeAmount parses an amount such as "₩1,234,500", "1.234,50 EUR" or
+// "$1,234.50" into minor units of its currency (cents, or whole won/yen) and
+// the ISO currency code. The currency is empty if the text doesn't name one,
+// in which case the value is in hundredths.
+func normalizeAmount(raw string) (value int64, currency string, err error) {
+	return normalizeAmountIn(raw, "")
+}
+
+// normalizeAmountIn is normalizeAmount for an amount that belongs to a known
+// currency, such as a correction to an invoice's total. A bare "12500" is then
+// read in defaultCurrency's minor units rather than as hundredths.
+func normalizeAmountIn(raw, defaultCurrency string) (value int64, currency string, err error) {
+	var number strings.Builder
+	var letters strings.Builder
+	rest := raw
+
+	// Symbols first, longest match wins ("US$" before "$")
+	for _, symbol := range []string{"US$", "₩", "€", "£", "¥", "₽", "₴", "₫", "₹", "$"} {
+		if strings.Contains(rest, symbol) {
+			currency = currencySymbols[symbol]
+			rest = strings.ReplaceAll(rest, symbol, " ")
+			break
+		}
+	}
+
+	for _, r := range rest {
+		switch {
+		case unicode.IsDigit(r), r == '.', r == ',', r == '-':
+			number.WriteRune(r)
+		case unicode.IsLetter(r):
+			letters.WriteRune(unicode.ToUpper(r))
+		case r == ' ', r == '\u00a0', r == '\u202f', r == '\'', r == '\u2019':
+			// Thousands separators in some locales, or just spacing
+		default:
+			return 0, "", fmt.Errorf("unexpected character %q in amount %q", r, raw)
+		}
+	}
+
+	// An explicit ISO code overrides a symbol
+	if code := letters.String(); len(code) == 3 {
+		currency = code
+	} else if code != "" {
+		return 0, "", fmt.Errorf("unrecognised currency %q in amount %q", code, raw)
+	}
+	if currency == "" {
+		currency = defaultCurrency
+	}
+
+	negative := strings.HasPrefix(number.String(), "-")
+	whole, fraction, err := splitAmount(strings.TrimPrefix(number.String(), "-"))
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid amount %q: %v", raw, err)
+	}
+
+	exponent := 2
+	if zeroDecimalCurrencies[currency] {
+		exponent = 0
+	}
+
+	value, err = toMinorUnits(whole, fraction, exponent)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid amount %q: %v", raw, err)
+	}
+	if negative {
+		value = -value
+	}
+	return value, currency, nil
+}
+
+// splitAmount separates the whole and fractional digits, working out which of
+// "." and "," is the decimal separator:
+//   - both present: whichever comes last ("1.234,50", "1,234.50")
+//   - one present several times: thousands ("1,234,500")
+//   - one present once between digit groups: thousands ("1,234")
+//   - otherwise: decimal ("12,5", "12.50", "1234,567")
+//
+// Thousands separators must sit between groups of three digits, or the lakh
+// groups of two used in India ("1,23,456"), so a misread like "12.5.6" is
+// rejected instead of becoming 1256.
+func splitAmount(number string) (whole, fraction string, err error) {
+	if number == "" {
+		return "", "", fmt.Errorf("no digits")
+	}
+
+	lastDot, lastComma := strings.LastIndex(number, "."), strings.LastIndex(number, ",")
+	decimal := -1
+	thousands := ""
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		decimal, thousands = lastDot, ","
+		if lastComma > lastDot {
+			decimal, thousands = lastComma, "."
+		}
+	case lastDot >= 0 || lastComma >= 0:
+		separator := max(lastDot, lastComma)
+		thousands = number[separator : separator+1]
+		if strings.Count(number, thousands) == 1 && !isDigitGrouping(number, thousands) {
+			decimal, thousands = separator, ""
+		}
+	}
+
+	if decimal >= 0 {
+		whole, fraction = number[:decimal], number[decimal+1:]
+	} else {
+		whole = number
+	}
+	if strings.ContainsAny(fraction, ".,") {
+		return "", "", fmt.Errorf("misplaced separator")
+	}
+
+	if thousands != "" {
+		if !isDigitGrouping(whole, thousands) {
+			return "", "", fmt.Errorf("misplaced separator")
+		}
+		whole = strings.ReplaceAll(whole, thousands, "")
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	return whole, fraction, nil
+}
+
+// isDigitGrouping reports whether separator splits number into thousands
+// ("1,234,567") or lakh ("12,34,567") groups
+func isDigitGrouping(number, separator string) bool {
+	groups := strings.Split(number, separator)
+	if len(groups) < 2 {
+		return false
+	}
+	for _, group := range groups {
+		if group == "" || strings.TrimFunc(group, unicode.IsDigit) != "" {
+			return false
+		}
+	}
+
+	first, rest, last := groups[0], groups[1:len(groups)-1], groups[len(groups)-1]
+	if len(last) != 3 {
+		return false
+	}
+	thousandsGroups, lakhGroups := len(first) <= 3, len(first) <= 2
+	for _, group := range rest {
+		thousandsGroups = thousandsGroups && len(group) == 3
+		lakhGroups = lakhGroups && len(group) == 2
+	}
+	return thousandsGroups || lakhGroups
+}
+
+// toMinorUnits combines whole and fractional digits into an integer with the
+// given number of decimal places, rounding half up any extra digits
+func toMinorUnits(whole, fraction string, exponent int) (int64, error) {
+	padded := fraction + strings.Repeat("0", max(0, exponent-len(fraction)))
+	digits := whole + padded[:exponent]
+
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if len(padded) > exponent && padded[exponent] >= '5' {
+		value++
+	}
+	return value, nil
+}
+
+// minorUnitsToDecimal converts a normalized amount back to a Decimal
+func minorUnitsToDecimal(value int64, currency string) *Decimal {
+	amount := &Decimal{}
+	if zeroDecimalCurrencies[currency] {
+		amount.SetInt64(value)
+	} else {
+		amount.SetFrac64(value, 100)
+	}
+	return amount
+}
+
+// formatMinorUnits renders a normalized amount like formatMoney does for invoice fields
+func formatMinorUnits(value int64, currency string) string {
+	return formatMoney(minorUnitsToDecimal(value, currency), currency)
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestNormalizeAmount(t *testing.T) {
+	tests := []struct {
+		raw      string
+		value    int64
+		currency string
+	}{
+		{"₩1,234,000", 1234000, "KRW"},
+		{"1.234,56 €", 123456, "EUR"},
+		{"$1,234.56", 123456, "USD"},
+		{"1 234 000 KRW", 1234000, "KRW"},
+		{"EUR 12,5", 1250, "EUR"},
+		{"US$ 0.99", 99, "USD"},
+		{"-$15.00", -1500, "USD"},
+		{"1,234", 123400, ""},
+		{"1.234.567,89 EUR", 123456789, "EUR"},
+		{"₹1,23,456.50", 12345650, "INR"},
+		{"12,34,567 INR", 1234567 * 100, "INR"},
+		// One separator not between thousands groups is a decimal point
+		{"1234,567", 123457, ""},
+		{".5", 50, ""},
+	}
+
+	for _, tt := range tests {
+		value, currency, err := normalizeAmount(tt.raw)
+		if err != nil {
+			t.Errorf("normalizeAmount(%q): %v", tt.raw, err)
+			continue
+		}
+		if value != tt.value || currency != tt.currency {
+			t.Errorf("normalizeAmount(%q) = %d %q, want %d %q", tt.raw, value, currency, tt.value, tt.currency)
+		}
+	}
+}
+
+func TestNormalizeAmountIn(t *testing.T) {
+	tests := []struct {
+		raw             string
+		defaultCurrency string
+		value           int64
+		currency        string
+	}{
+		{"12500", "KRW", 12500, "KRW"},
+		{"12,500", "KRW", 12500, "KRW"},
+		{"1500", "JPY", 1500, "JPY"},
+		{"12500", "USD", 1250000, "USD"},
+		{"12.50", "USD", 1250, "USD"},
+		{"12.50", "", 1250, ""},
+		// A typed currency wins over the default
+		{"12.50 USD", "KRW", 1250, "USD"},
+		{"₩12,500", "USD", 12500, "KRW"},
+	}
+
+	for _, tt := range tests {
+		value, currency, err := normalizeAmountIn(tt.raw, tt.defaultCurrency)
+		if err != nil {
+			t.Errorf("normalizeAmountIn(%q, %q): %v", tt.raw, tt.defaultCurrency, err)
+			continue
+		}
+		if value != tt.value || currency != tt.currency {
+			t.Errorf("normalizeAmountIn(%q, %q) = %d %q, want %d %q", tt.raw, tt.defaultCurrency, value, currency, tt.value, tt.currency)
+		}
+	}
+}
+
+func TestNormalizeAmountRejectsGarbage(t *testing.T) {
+	for _, raw := range []string{"", "about ten dollars", "12 pieces", "12.5.6", "1,2,3", "1,23,4", "12,34.5,6", "1.234.5,67", "1,2345.67"} {
+		if value, currency, err := normalizeAmount(raw); err == nil {
+			t.Errorf("normalizeAmount(%q) = %d %q, want an error", raw, value, currency)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a key that hit its quota or was rejected is skipped (OPENAI_KEY_COOLDOWN_SECONDS)
+var openAIKeyCooldown = 5 * time.Minute
+
+// openAIKeys spreads requests over the configured API keys (OPENAI_API_KEYS, or OPENAI_API_KEY)
+var openAIKeys = &keyPool{}
+
+// keyPool hands out API keys round-robin, skipping keys that are cooling down
+type keyPool struct {
+	mu             sync.Mutex
+	keys           []string
+	unhealthyUntil []time.Time
+	next           int
+}
+
+func newKeyPool(keys []string) *keyPool {
+	return &keyPool{keys: keys, unhealthyUntil: make([]time.Time, len(keys))}
+}
+
+// parseAPIKeys splits a comma-separated key list, dropping blanks and repeats
+func parseAPIKeys(value string) []string {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, key := range strings.Split(value, ",") {
+		key = strings.TrimSpace(key)
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// all returns every configured key, e.g. for redacting them from logs
+func (p *keyPool) all() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.keys
+}
+
+// pick returns the next healthy key and its index. If every key is cooling
+// down, the one that recovers first is used rather than failing outright.
+func (p *keyPool) pick() (int, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	fallback := 0
+	for i := range p.keys {
+		index := (p.next + i) % len(p.keys)
+		if now.After(p.unhealthyUntil[index]) {
+			p.next = index + 1
+			return index, p.keys[index]
+		}
+		if p.unhealthyUntil[index].Before(p.unhealthyUntil[fallback]) {
+			fallback = index
+		}
+	}
+	return fallback, p.keys[fallback]
+}
+
+// markUnhealthy takes a key out of rotation for the cooldown
+func (p *keyPool) markUnhealthy(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unhealthyUntil[index] = time.Now().Add(openAIKeyCooldown)
+}
+
+// healthyCount returns how many keys aren't cooling down
+func (p *keyPool) healthyCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for _, until := range p.unhealthyUntil {
+		if now.After(until) {
+			count++
+		}
+	}
+	return count
+}
+
+// isKeyError reports whether a response means the key itself is unusable:
+// rejected (401) or out of quota (429 insufficient_quota). Plain rate limits
+// aren't key errors. The body is restored so callers can still read it.
+func isKeyError(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return true
+	case http.StatusTooManyRequests:
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		return bytes.Contains(body, []byte("insufficient_quota"))
+	}
+	return false
+}
+
+// Check the API keys against OpenAI at startup (OPENAI_STARTUP_CHECK)
+var openAIStartupCheck = true
+
+// checkOpenAIKeys lists models with each key, which costs no tokens, so a
+// revoked key shows up at deploy time instead of as failed extractions.
+// Rejected keys are cooled down; it only fails if OpenAI rejects every key.
+// Keys that couldn't be checked, e.g. because OpenAI is unreachable, are
+// logged and assumed to be fine.
+func checkOpenAIKeys(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	keys := openAIKeys.all()
+	rejected := 0
+	for i, key := range keys {
+		status, err := checkOpenAIKey(ctx, key)
+		switch {
+		case err != nil:
+			slog.Warn("Couldn't verify OpenAI API key", "key_index", i, "error", err)
+		case status == http.StatusUnauthorized:
+			slog.Error("OpenAI rejected API key: it is invalid or revoked", "key_index", i)
+			openAIKeys.markUnhealthy(i)
+			rejected++
+		case status != http.StatusOK:
+			slog.Warn("Couldn't verify OpenAI API key", "key_index", i, "status", status)
+		default:
+			slog.Info("OpenAI API key verified", "key_index", i)
+		}
+	}
+
+	if rejected == len(keys) {
+		return fmt.Errorf("OpenAI rejected all %d configured API keys", len(keys))
+	}
+	return nil
+}
+
+func checkOpenAIKey(ctx context.Context, key string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", openAIAPIBase+"/v1/models", nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	return resp.StatusCode, nil
+}
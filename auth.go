@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Chats and users allowed to use the bot (ALLOWED_CHAT_IDS, ALLOWED_USER_IDS).
+// With both empty anyone can use it.
+var (
+	allowedChatIDs map[int64]bool
+	allowedUserIDs map[int64]bool
+)
+
+// parseIDList parses a comma-separated list of Telegram IDs
+func parseIDList(value string) (map[int64]bool, error) {
+	ids := make(map[int64]bool)
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q", field)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+// isAuthorized reports whether the chat or the user is on an allowlist.
+// Matching either list is enough, so a listed user can use the bot in any chat
+// and anyone can use it in a listed group.
+func isAuthorized(chatID, userID int64) bool {
+	if len(allowedChatIDs) == 0 && len(allowedUserIDs) == 0 {
+		return true
+	}
+	return allowedChatIDs[chatID] || allowedUserIDs[userID]
+}
+
+// rejectUnauthorized returns true if the update comes from a chat and user
+// that aren't allowed. Those are logged and told so, but only when they
+// addressed the bot (a command, photo, file or button press) so group chatter
+// doesn't get a reply to every message.
+func rejectUnauthorized(ctx context.Context, update TelegramUpdate) bool {
+	if query := update.CallbackQuery; query != nil {
+		var chatID int64
+		if query.Message != nil {
+			chatID = query.Message.Chat.ID
+		}
+		if isAuthorized(chatID, query.From.ID) {
+			return false
+		}
+		loggerFrom(ctx).Warn("Unauthorized callback query", "chat_id", chatID, "user_id", query.From.ID)
+		answerCallbackQuery(ctx, query.ID, t("unauthorized", userLanguage(query.From.LanguageCode)))
+		return true
+	}
+
+	// Inline queries have no chat, so only the user allowlist can match
+	if query := update.InlineQuery; query != nil {
+		if isAuthorized(0, query.From.ID) {
+			return false
+		}
+		loggerFrom(ctx).Warn("Unauthorized inline query", "user_id", query.From.ID)
+		answerInlineQuery(ctx, query.ID, []map[string]interface{}{}, userLanguage(query.From.LanguageCode))
+		return true
+	}
+
+	message := update.Message
+	if update.EditedMessage != nil {
+		message = *update.EditedMessage
+	}
+	if isAuthorized(message.Chat.ID, message.From.ID) {
+		return false
+	}
+
+	loggerFrom(ctx).Warn("Unauthorized update", "chat_id", message.Chat.ID, "chat_type", message.Chat.Type, "user_id", message.From.ID, "username", message.From.Username)
+	command, _ := parseCommand(message.Text)
+	if update.EditedMessage == nil && (command != "" || len(message.Photo) > 0 || message.Document != nil) {
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("unauthorized", userLanguage(message.From.LanguageCode)))
+	}
+	return true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long an extraction result is reused for the same file (EXTRACTION_CACHE_TTL_SECONDS, 0 disables)
+var extractionCacheTTL = time.Hour
+
+// ExtractionCache stores extraction results by key. Values are strings so a
+// shared store like Redis can back it as easily as memory. Implementations must
+// be safe for concurrent use.
+type ExtractionCache interface {
+	Get(ctx context.Context, key string) (string, bool)
+	Set(ctx context.Context, key, value string, ttl time.Duration)
+}
+
+var extractionCache ExtractionCache = newMemoryCache()
+
+// contentHash identifies a downloaded file by its bytes
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// extractionCacheKey covers everything that changes the result: the file,
+// what was asked of it and which model answered
+func extractionCacheKey(fileHash, kind, model, prompt string) string {
+	promptHash := sha256.Sum256([]byte(prompt))
+	return fileHash + ":" + kind + ":" + model + ":" + hex.EncodeToString(promptHash[:8])
+}
+
+// A "/nocache" caption option, e.g. "/nocache total"
+var noCacheOption = regexp.MustCompile(`(?i)(?:^|\s)/nocache\b`)
+
+// stripNoCacheOption removes a /nocache option from a caption, reporting
+// whether it was there, so the rest of the caption works as usual
+func stripNoCacheOption(caption string) (string, bool) {
+	if !noCacheOption.MatchString(caption) {
+		return caption, false
+	}
+	return strings.TrimSpace(noCacheOption.ReplaceAllString(caption, " ")), true
+}
+
+type noCacheKey struct{}
+
+// withoutCachedResults makes extractions under ctx ignore cached results. The
+// fresh results are still stored, replacing the stale ones.
+func withoutCachedResults(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// cachedResultsSkipped reports whether ctx came from withoutCachedResults
+func cachedResultsSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(noCacheKey{}).(bool)
+	return skip
+}
+
+// cachedExtraction returns the cached result for the same file, kind, model and
+// prompt, or runs extract and caches what it returns. An empty fileHash skips the
+// cache. Cached results report zero usage since nothing was billed.
+func cachedExtraction(ctx context.Context, fileHash, kind, model, prompt string, extract func() (string, Usage, error)) (string, Usage, error) {
+	if fileHash == "" || extractionCacheTTL <= 0 {
+		return extract()
+	}
+
+	key := extractionCacheKey(fileHash, kind, model, prompt)
+	if cachedResultsSkipped(ctx) {
+		extractionCacheRequests.WithLabelValues(kind, "bypass").Inc()
+		loggerFrom(ctx).Info("Skipping cached extraction on request", "kind", kind, "file_hash", fileHash)
+	} else if result, ok := extractionCache.Get(ctx, key); ok {
+		extractionCacheRequests.WithLabelValues(kind, "hit").Inc()
+		loggerFrom(ctx).Info("Using cached extraction", "kind", kind, "file_hash", fileHash)
+		return result, Usage{}, nil
+	} else {
+		extractionCacheRequests.WithLabelValues(kind, "miss").Inc()
+	}
+
+	result, usage, err := extract()
+	if err != nil {
+		return "", usage, err
+	}
+	extractionCache.Set(ctx, key, result, extractionCacheTTL)
+	return result, usage, nil
+}
+
+// cachedInvoiceExtraction is cachedExtraction for structured invoices, stored as JSON
+func cachedInvoiceExtraction(ctx context.Context, fileHash, imageURL, prompt, model string) (*Invoice, Usage, error) {
+	return cachedInvoice(ctx, fileHash, prompt, model, func() (*Invoice, Usage, error) {
+		return extractInvoiceFields(ctx, imageURL, prompt, model)
+	})
+}
+
+// cachedInvoice caches any invoice extraction, e.g. from an image or a document's text
+func cachedInvoice(ctx context.Context, fileHash, prompt, model string, extract func() (*Invoice, Usage, error)) (*Invoice, Usage, error) {
+	var invoice *Invoice
+	result, usage, err := cachedExtraction(ctx, fileHash, "invoice", model, prompt, func() (string, Usage, error) {
+		extracted, usage, err := extract()
+		if err != nil {
+			return "", usage, err
+		}
+		invoice = extracted
+
+		data, err := json.Marshal(extracted)
+		if err != nil {
+			return "", usage, err
+		}
+		return string(data), usage, nil
+	})
+	if err != nil {
+		return nil, usage, err
+	}
+	if invoice != nil {
+		return invoice, usage, nil
+	}
+
+	invoice = &Invoice{}
+	if err := json.Unmarshal([]byte(result), invoice); err != nil {
+		loggerFrom(ctx).Warn("Ignoring unreadable cached invoice", "error", err)
+		return extract()
+	}
+	return invoice, usage, nil
+}
+
+// memoryCache is an in-process ExtractionCache
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+	sweep   sweepSchedule
+}
+
+type memoryCacheEntry struct {
+	value   string
+	expires time.Time
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{entries: make(map[string]memoryCacheEntry)}
+}
+
+func (c *memoryCache) Get(ctx context.Context, key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return "", false
+	}
+	return entry.value, true
+}
+
+func (c *memoryCache) Set(ctx context.Context, key, value string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.sweep.due(now) {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = memoryCacheEntry{value: value, expires: now.Add(ttl)}
+}
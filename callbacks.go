@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Callback data prefixes for the buttons under an extracted invoice
+const (
+	callbackConfirm  = "confirm"
+	callbackFixTotal = "fixtotal"
+	callbackRescan   = "rescan"
+)
+
+// invoiceKeyboard returns the buttons shown under an extracted invoice
+func invoiceKeyboard(invoiceID int64, lang string) *InlineKeyboardMarkup {
+	data := func(action string) string {
+		return fmt.Sprintf("%s:%d", action, invoiceID)
+	}
+
+	return &InlineKeyboardMarkup{
+		InlineKeyboard: [][]InlineKeyboardButton{{
+			{Text: t("button.confirm", lang), CallbackData: data(callbackConfirm)},
+			{Text: t("button.fix_total", lang), CallbackData: data(callbackFixTotal)},
+			{Text: t("button.rescan", lang), CallbackData: data(callbackRescan)},
+		}},
+	}
+}
+
+// Users who pressed "Fix total" and whose next text message is the corrected total
+type pendingFixKey struct {
+	chatID int64
+	userID int64
+}
+
+type pendingFix struct {
+	invoiceID   int64
+	requestedAt time.Time
+}
+
+// How long after pressing "Fix total" a text message is still read as the corrected total
+const pendingFixTTL = 5 * time.Minute
+
+var (
+	pendingTotalFixesMu sync.Mutex
+	pendingTotalFixes   = make(map[pendingFixKey]pendingFix)
+)
+
+// cancelPendingTotalFix forgets the user's pending correction, reporting whether there was one
+func cancelPendingTotalFix(chatID, userID int64) bool {
+	pendingTotalFixesMu.Lock()
+	defer pendingTotalFixesMu.Unlock()
+
+	key := pendingFixKey{chatID, userID}
+	fix, ok := pendingTotalFixes[key]
+	delete(pendingTotalFixes, key)
+	return ok && time.Since(fix.requestedAt) < pendingFixTTL
+}
+
+// handleCallbackQuery handles a press on one of the invoice buttons
+func handleCallbackQuery(ctx context.Context, query *TelegramCallbackQuery) {
+	if query.Message == nil {
+		answerCallbackQuery(ctx, query.ID, t("callback.too_old", userLanguage(query.From.LanguageCode)))
+		return
+	}
+
+	chatID := query.Message.Chat.ID
+	logger := loggerFrom(ctx).With("chat_id", chatID, "user_id", query.From.ID, "callback_data", query.Data)
+	ctx = withLogger(ctx, logger)
+	lang := replyLanguage(ctx, chatID, query.From.LanguageCode)
+	ctx = withLanguage(ctx, lang)
+	logger.Info("Received callback query")
+
+	action, idText, _ := strings.Cut(query.Data, ":")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil {
+		answerCallbackQuery(ctx, query.ID, "")
+		return
+	}
+
+	stored, ok := getStoredInvoice(id)
+	if !ok || stored.ChatID != chatID {
+		answerCallbackQuery(ctx, query.ID, t("callback.unavailable", lang))
+		return
+	}
+
+	switch action {
+	case callbackConfirm:
+		updateStoredInvoice(id, func(s *StoredInvoice) { s.Verified = true })
+		answerCallbackQuery(ctx, query.ID, t("callback.verified", lang))
+		if err := removeInlineKeyboard(ctx, chatID, query.Message.MessageID); err != nil {
+			logger.Error("Error removing inline keyboard", "error", err)
+		}
+
+	case callbackFixTotal:
+		pendingTotalFixesMu.Lock()
+		// Drop corrections nobody sent, so the map doesn't grow with every unused press
+		for key, fix := range pendingTotalFixes {
+			if time.Since(fix.requestedAt) >= pendingFixTTL {
+				delete(pendingTotalFixes, key)
+			}
+		}
+		pendingTotalFixes[pendingFixKey{chatID, query.From.ID}] = pendingFix{invoiceID: id, requestedAt: time.Now()}
+		pendingTotalFixesMu.Unlock()
+
+		answerCallbackQuery(ctx, query.ID, "")
+		sendTelegramMessage(ctx, chatID, query.Message.MessageID, t("callback.send_total", lang, int(pendingFixTTL.Minutes())))
+
+	case callbackRescan:
+		image, ok := lastImage(chatID)
+		if !ok || image.message.MessageID != stored.MessageID {
+			answerCallbackQuery(ctx, query.ID, t("callback.image_expired", lang))
+			return
+		}
+
+		settings := getChatSettings(ctx, chatID)
+		if settings.safeModeEnabled() {
+			answerCallbackQuery(ctx, query.ID, t("callback.ocr_disabled", lang))
+			return
+		}
+
+		// No file hash, so the re-scan isn't answered from the cache
+		answerCallbackQuery(ctx, query.ID, t("callback.rescanning", lang, retryModel))
+		extractAndReply(ctx, image.message, image.imageURL, "", false, settings, retryModel)
+
+	default:
+		answerCallbackQuery(ctx, query.ID, "")
+	}
+}
+
+// handleTotalCorrection applies a corrected total sent after pressing "Fix total".
+// Returns false if the user has no correction pending, or pressed the button more
+// than pendingFixTTL ago.
+func handleTotalCorrection(ctx context.Context, message TelegramMessage) bool {
+	key := pendingFixKey{message.Chat.ID, message.From.ID}
+
+	pendingTotalFixesMu.Lock()
+	fix, ok := pendingTotalFixes[key]
+	if ok && time.Since(fix.requestedAt) >= pendingFixTTL {
+		delete(pendingTotalFixes, key)
+		ok = false
+	}
+	pendingTotalFixesMu.Unlock()
+	if !ok {
+		return false
+	}
+	id := fix.invoiceID
+
+	// Any command, known or not, gives up on the correction
+	if command, _ := parseCommand(message.Text); command != "" {
+		cancelPendingTotalFix(message.Chat.ID, message.From.ID)
+		return false
+	}
+
+	// An amount typed without a currency is in the invoice's own currency
+	lang := languageFrom(ctx)
+	var invoiceCurrency string
+	if stored, ok := getStoredInvoice(id); ok {
+		invoiceCurrency = stored.Invoice.Currency
+	}
+	value, currency, err := normalizeAmountIn(message.Text, invoiceCurrency)
+	if err != nil {
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("correction.invalid_amount", lang))
+		return true
+	}
+
+	pendingTotalFixesMu.Lock()
+	delete(pendingTotalFixes, key)
+	pendingTotalFixesMu.Unlock()
+
+	stored, ok := updateStoredInvoice(id, func(s *StoredInvoice) {
+		if currency != "" {
+			s.Invoice.Currency = currency
+		}
+		s.Invoice.Total = minorUnitsToDecimal(value, currency)
+	})
+	if !ok {
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("correction.unavailable", lang))
+		return true
+	}
+
+	loggerFrom(ctx).Info("Invoice total corrected", "invoice_id", id, "total", stored.Invoice.Total.String())
+	sendTelegramMessageWithKeyboard(ctx, message.Chat.ID, message.MessageID, t("correction.updated", lang)+"\n\n"+formatInvoice(&stored.Invoice, getChatSettings(ctx, message.Chat.ID), lang), invoiceKeyboard(id, lang))
+	return true
+}
+
+// answerCallbackQuery stops the loading spinner on the pressed button, optionally showing a short notice
+func answerCallbackQuery(ctx context.Context, queryID, text string) error {
+	payload := map[string]interface{}{
+		"callback_query_id": queryID,
+	}
+	if text != "" {
+		payload["text"] = text
+	}
+	return callTelegramMethod(ctx, "answerCallbackQuery", payload)
+}
+
+// removeInlineKeyboard takes the buttons off a message once they've been used
+func removeInlineKeyboard(ctx context.Context, chatID, messageID int64) error {
+	return callTelegramMethod(ctx, "editMessageReplyMarkup", map[string]interface{}{
+		"chat_id":      chatID,
+		"message_id":   messageID,
+		"reply_markup": InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{}},
+	})
+}
+
+// callTelegramMethod posts a JSON payload to a Bot API method and checks the status
+func callTelegramMethod(ctx context.Context, method string, payload map[string]interface{}) error {
+	url := fmt.Sprintf("%s/bot%s/%s", telegramAPIBase, telegramBotToken, method)
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("telegram API error: %d - %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
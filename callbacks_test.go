@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// pressFixTotal sets up a pending correction for user 1 in chatID, made age ago
+func pressFixTotal(chatID int64, age time.Duration) {
+	id := saveInvoice(chatID, 1, &Invoice{Vendor: "Pending Garage", Currency: "USD", Total: decimal("10.00")}, "")
+	pendingTotalFixesMu.Lock()
+	pendingTotalFixes[pendingFixKey{chatID, 1}] = pendingFix{invoiceID: id, requestedAt: time.Now().Add(-age)}
+	pendingTotalFixesMu.Unlock()
+}
+
+func textMessage(chatID int64, text string) TelegramMessage {
+	var message TelegramMessage
+	message.MessageID = 2
+	message.Chat = TelegramChat{ID: chatID, Type: "private"}
+	message.From = TelegramUser{ID: 1, LanguageCode: "en"}
+	message.Text = text
+	return message
+}
+
+func TestPendingTotalFix(t *testing.T) {
+	useFakeAPIs(t, &fakeTelegram{}, http.NotFoundHandler())
+	ctx := withLanguage(context.Background(), "en")
+
+	const chatID = 9960
+	pressFixTotal(chatID, time.Minute)
+	if !handleTotalCorrection(ctx, textMessage(chatID, "12.00 USD")) {
+		t.Error("correction within the TTL wasn't applied")
+	}
+	if handleTotalCorrection(ctx, textMessage(chatID, "13.00 USD")) {
+		t.Error("a second message was read as a correction too")
+	}
+
+	pressFixTotal(chatID, pendingFixTTL+time.Second)
+	if handleTotalCorrection(ctx, textMessage(chatID, "12.00 USD")) {
+		t.Error("correction after the TTL was applied")
+	}
+}
+
+func TestCommandsCancelPendingTotalFix(t *testing.T) {
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, http.NotFoundHandler())
+	ctx := withLanguage(context.Background(), "en")
+
+	const chatID = 9961
+	pressFixTotal(chatID, 0)
+	if !handleCommand(ctx, textMessage(chatID, "/cancel")) {
+		t.Fatal("/cancel isn't a command")
+	}
+	if handleTotalCorrection(ctx, textMessage(chatID, "12.00 USD")) {
+		t.Error("correction applied after /cancel")
+	}
+	handleCommand(ctx, textMessage(chatID, "/cancel"))
+	if sent := telegram.sent(); len(sent) != 2 || sent[0] != englishText("cancel.done") || sent[1] != englishText("cancel.nothing") {
+		t.Errorf("sent %q", sent)
+	}
+
+	pressFixTotal(chatID, 0)
+	handleCommand(ctx, textMessage(chatID, "/help"))
+	if handleTotalCorrection(ctx, textMessage(chatID, "12.00 USD")) {
+		t.Error("correction applied after another command")
+	}
+
+	pressFixTotal(chatID, 0)
+	if handleTotalCorrection(ctx, textMessage(chatID, "/unknown")) {
+		t.Error("unknown command read as a correction")
+	}
+	if handleTotalCorrection(ctx, textMessage(chatID, "12.00 USD")) {
+		t.Error("correction applied after an unknown command")
+	}
+}
+
+func TestTotalCorrectionUsesInvoiceCurrency(t *testing.T) {
+	useFakeAPIs(t, &fakeTelegram{}, http.NotFoundHandler())
+	ctx := withLanguage(context.Background(), "en")
+
+	tests := []struct {
+		currency string
+		typed    string
+		want     string
+	}{
+		{"KRW", "12500", "12500"},
+		{"JPY", "1,500", "1500"},
+		{"USD", "12500", "12500"},
+		{"USD", "12.5", "12.5"},
+	}
+
+	for i, tt := range tests {
+		chatID := 9970 + int64(i)
+		id := saveInvoice(chatID, 1, &Invoice{Vendor: "Currency Garage", Currency: tt.currency, Total: decimal("1")}, "")
+		pendingTotalFixesMu.Lock()
+		pendingTotalFixes[pendingFixKey{chatID, 1}] = pendingFix{invoiceID: id, requestedAt: time.Now()}
+		pendingTotalFixesMu.Unlock()
+
+		if !handleTotalCorrection(ctx, textMessage(chatID, tt.typed)) {
+			t.Fatalf("%s: correction not applied", tt.currency)
+		}
+		stored, _ := getStoredInvoice(id)
+		if stored.Invoice.Currency != tt.currency || stored.Invoice.Total.Cmp(&decimal(tt.want).Rat) != 0 {
+			t.Errorf("%s %q: stored %s %s, want %s", tt.currency, tt.typed, stored.Invoice.Total, stored.Invoice.Currency, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Caption phrases naming invoice fields, e.g. "invoice number and date".
+// Longer phrases come first so "subtotal" isn't also read as "total".
+var captionFieldPhrases = []struct {
+	field   string
+	pattern *regexp.Regexp
+}{
+	{"vins", regexp.MustCompile(`(?i)\bvins?\b|\bvehicle\s+(?:identification\s+)?numbers?\b`)},
+	{"subtotal", regexp.MustCompile(`(?i)\bsub[ -]?totals?\b`)},
+	{"invoice_number", regexp.MustCompile(`(?i)\b(?:invoice|receipt|inv)\s*(?:numbers?\b|no\b\.?|#)|\bnumbers?\b`)},
+	{"line_items", regexp.MustCompile(`(?i)\b(?:line\s+)?items\b|\bproducts\b`)},
+	{"total", regexp.MustCompile(`(?i)\b(?:grand\s+)?totals?\b|\bamount\s+due\b`)},
+	{"date", regexp.MustCompile(`(?i)\bdates?\b`)},
+	{"vendor", regexp.MustCompile(`(?i)\b(?:vendors?|sellers?|suppliers?|merchants?|store|shop)\b`)},
+	{"tax", regexp.MustCompile(`(?i)\b(?:tax|vat|gst)\b`)},
+	{"currency", regexp.MustCompile(`(?i)\bcurrency\b`)},
+}
+
+// Words allowed around field names in a field request
+var captionFieldFiller = regexp.MustCompile(`(?i)\b(?:just|only|the|and|plus|please|pls|give|me|show|get|what's|whats|what|is|are|of|a|an|with|it's|its)\b|[,&+/?!.:;]`)
+
+// requestedFields returns the invoice fields a caption asks for, like
+// "just the total" or "invoice number and date". Captions that say anything
+// besides field names, like "pay by Friday", are notes and return nil.
+func requestedFields(caption string) []string {
+	rest := caption
+	var fields []string
+	for _, phrase := range captionFieldPhrases {
+		if phrase.pattern.MatchString(rest) {
+			fields = append(fields, phrase.field)
+			rest = phrase.pattern.ReplaceAllString(rest, " ")
+		}
+	}
+	if len(fields) == 0 || strings.TrimSpace(captionFieldFiller.ReplaceAllString(rest, " ")) != "" {
+		return nil
+	}
+	return fields
+}
+
+// withRequestedFields narrows the invoice prompt to the fields the user asked for
+func withRequestedFields(prompt string, fields []string) string {
+	return prompt + fmt.Sprintf("\n\nThe user only wants these fields: %s. Set every other key to null, or an empty array for line_items and vins.", strings.Join(fields, ", "))
+}
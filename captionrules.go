@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CaptionRule maps a caption pattern to a prompt override and/or a field selection
+type CaptionRule struct {
+	Name    string   `json:"name"`
+	Pattern string   `json:"pattern"`
+	Prompt  string   `json:"prompt"`
+	Fields  []string `json:"fields"`
+
+	regex *regexp.Regexp
+}
+
+var (
+	captionRulesMu      sync.RWMutex
+	captionRules        []CaptionRule
+	captionRulesPath    string
+	captionRulesModTime time.Time
+)
+
+// loadCaptionRules reads and validates a JSON array of caption rules
+func loadCaptionRules(path string) ([]CaptionRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read caption rules: %v", err)
+	}
+
+	var rules []CaptionRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse caption rules: %v", err)
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("%s: pattern is required", rule.Name)
+		}
+		if rule.Prompt == "" && len(rule.Fields) == 0 {
+			return nil, fmt.Errorf("%s: prompt or fields is required", rule.Name)
+		}
+
+		rule.regex, err = regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern: %v", rule.Name, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// initCaptionRules loads the rules file at startup
+func initCaptionRules(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat caption rules: %v", err)
+	}
+
+	rules, err := loadCaptionRules(path)
+	if err != nil {
+		return err
+	}
+
+	captionRulesMu.Lock()
+	defer captionRulesMu.Unlock()
+
+	captionRulesPath = path
+	captionRules = rules
+	captionRulesModTime = info.ModTime()
+	return nil
+}
+
+// reloadCaptionRulesIfChanged picks up edits to the rules file. An invalid
+// file is logged and the previously loaded rules are kept.
+func reloadCaptionRulesIfChanged() {
+	captionRulesMu.RLock()
+	path, modTime := captionRulesPath, captionRulesModTime
+	captionRulesMu.RUnlock()
+
+	if path == "" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.ModTime().Equal(modTime) {
+		return
+	}
+
+	rules, err := loadCaptionRules(path)
+
+	captionRulesMu.Lock()
+	defer captionRulesMu.Unlock()
+
+	// Remember the mtime either way so a broken file is only reported once
+	captionRulesModTime = info.ModTime()
+	if err != nil {
+		slog.Error("Error reloading caption rules, keeping previous rules", "path", path, "error", err)
+		return
+	}
+
+	captionRules = rules
+	slog.Info("Reloaded caption rules", "count", len(rules), "path", path)
+}
+
+// matchCaptionRule returns the first rule matching the caption, or nil
+func matchCaptionRule(caption string) *CaptionRule {
+	if caption == "" {
+		return nil
+	}
+
+	reloadCaptionRulesIfChanged()
+
+	captionRulesMu.RLock()
+	defer captionRulesMu.RUnlock()
+
+	for i := range captionRules {
+		if captionRules[i].regex.MatchString(caption) {
+			rule := captionRules[i]
+			return &rule
+		}
+	}
+	return nil
+}
+
+// buildPrompt applies the rule's prompt override and field selection
+func (r *CaptionRule) buildPrompt(defaultPrompt string) string {
+	prompt := defaultPrompt
+	if r.Prompt != "" {
+		prompt = r.Prompt
+	}
+	if len(r.Fields) > 0 {
+		prompt += " Only extract the following fields: " + strings.Join(r.Fields, ", ") + "."
+	}
+	return prompt
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Ask the model what kind of image it is before extracting (CLASSIFY_IMAGES),
+// so photos of people, screenshots and memes get a short reply instead of a
+// full extraction
+var classifyImages bool
+
+// NON_INVOICE_MESSAGE; empty uses the built-in reply in the user's language
+var nonInvoiceMessage string
+
+// Image kinds the classifier can answer with. Anything but "other" is extracted.
+const (
+	imageKindInvoice  = "invoice"
+	imageKindReceipt  = "receipt"
+	imageKindDocument = "document"
+	imageKindOther    = "other"
+)
+
+const classificationPrompt = `Classify this image. Answer with exactly one word:
+- invoice: an invoice, bill or quote
+- receipt: a till or card receipt
+- document: any other document with printed or handwritten text (letter, form, statement, contract, ID, registration papers)
+- other: anything else (people, places, vehicles, screenshots of chats, memes, blank or unreadable images)`
+
+var imageClassifications = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "image_classifications_total",
+	Help: "Images classified before extraction, by kind (invoice, receipt, document, other, unknown).",
+}, []string{"kind"})
+
+// classifyImage asks the model whether the image is an invoice, receipt,
+// document or something else, using the low-detail image to keep it cheap.
+// The answer is cached with the extraction results for the same file.
+func classifyImage(ctx context.Context, imageURL, fileHash, model string) (string, Usage, error) {
+	answer, usage, err := cachedExtraction(ctx, fileHash, "classify", model, classificationPrompt, func() (string, Usage, error) {
+		request := OpenAIRequest{
+			Model:     model,
+			MaxTokens: 10,
+			Messages: []Message{
+				{
+					Role: "user",
+					Content: []Content{
+						{
+							Type: "text",
+							Text: classificationPrompt,
+						},
+						{
+							Type: "image_url",
+							ImageURL: &ImageURL{
+								URL:    imageURL,
+								Detail: "low",
+							},
+						},
+					},
+				},
+			},
+		}
+		return callOpenAI(ctx, request)
+	})
+	if err != nil {
+		return "", usage, err
+	}
+
+	kind := parseImageKind(answer)
+	imageClassifications.WithLabelValues(kind).Inc()
+	return kind, usage, nil
+}
+
+// parseImageKind reads the classifier's one-word answer, tolerating
+// punctuation, capitals and a short sentence around it. An answer that isn't
+// one of the kinds is "unknown", which is treated like a document.
+func parseImageKind(answer string) string {
+	words := strings.FieldsFunc(strings.ToLower(answer), func(r rune) bool {
+		return !('a' <= r && r <= 'z')
+	})
+	for _, word := range words {
+		switch word {
+		case imageKindInvoice, imageKindReceipt, imageKindDocument, imageKindOther:
+			return word
+		}
+	}
+	return "unknown"
+}
+
+// nonInvoiceReply is the reply for an image classified as something other than
+// a document: the operator's message if set, otherwise the built-in one
+func nonInvoiceReply(lang string) string {
+	if nonInvoiceMessage != "" {
+		return nonInvoiceMessage
+	}
+	return t("classify.not_invoice", lang)
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClassificationDoesNotCountAsExtraction(t *testing.T) {
+	const chatID = 9900
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, "other"))
+	defer restore(&classifyImages, true)()
+
+	processTestUpdate(photoUpdate(chatID))
+
+	if sent := telegram.sent(); len(sent) != 1 || sent[0] != englishText("classify.not_invoice") {
+		t.Fatalf("sent %q, want the not-an-invoice reply", sent)
+	}
+	today, _, _ := usageTotals(chatID)
+	if today.extractions != 0 || today.tokens != 15 {
+		t.Errorf("recorded %d extractions and %d tokens, want 0 and the classification's 15", today.extractions, today.tokens)
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Text commands handled by handleCommand. /total is handled by the photo flow.
+var commandHandlers = map[string]func(ctx context.Context, message TelegramMessage, args string){
+	"/start":      handleHelpCommand,
+	"/help":       handleHelpCommand,
+	"/safemode":   handleSafeModeCommand,
+	"/lang":       handleLangCommand,
+	"/retry":      handleRetryCommand,
+	"/export":     handleExportCommand,
+	"/history":    handleHistoryCommand,
+	"/vendors":    handleVendorsCommand,
+	"/stats":      handleStatsCommand,
+	"/pdf":        handlePDFCommand,
+	"/format":     handleFormatCommand,
+	"/setwebhook": handleSetWebhookCommand,
+	"/cancel":     handleCancelCommand,
+}
+
+// handleCommand dispatches a text command. Returns false if the text isn't a known command.
+func handleCommand(ctx context.Context, message TelegramMessage) bool {
+	command, args := parseCommand(message.Text)
+	handler, ok := commandHandlers[command]
+	if !ok {
+		return false
+	}
+
+	loggerFrom(ctx).Info("Handling command", "command", command)
+	handler(ctx, message, args)
+
+	// The next text after a command isn't a corrected total any more
+	cancelPendingTotalFix(message.Chat.ID, message.From.ID)
+	return true
+}
+
+// replyToText answers text that isn't a known command so users know what to send.
+// Group chats are left alone to avoid replying to every conversation.
+func replyToText(ctx context.Context, message TelegramMessage) {
+	if message.Chat.Type != "private" {
+		return
+	}
+
+	if command, _ := parseCommand(message.Text); command != "" {
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("text.unknown_command", messageLanguage(ctx, message)))
+		return
+	}
+
+	sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("text.hint", messageLanguage(ctx, message)))
+}
+
+// unsupportedMediaKind names the audio or video a message carries, or "" if none
+func unsupportedMediaKind(message TelegramMessage) string {
+	switch {
+	case message.Voice != nil:
+		return "voice"
+	case message.Audio != nil:
+		return "audio"
+	case message.Video != nil:
+		return "video"
+	case message.VideoNote != nil:
+		return "video_note"
+	}
+	return ""
+}
+
+// replyToUnsupportedMedia tells users in private chats which files the bot can read.
+// Like replyToText, it stays quiet in groups where voice notes are usually meant for people.
+func replyToUnsupportedMedia(ctx context.Context, message TelegramMessage) {
+	if message.Chat.Type != "private" {
+		return
+	}
+	sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("media.unsupported", messageLanguage(ctx, message)))
+}
+
+// Handle /start and /help
+func handleHelpCommand(ctx context.Context, message TelegramMessage, args string) {
+	sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("help", languageFrom(ctx), replyLanguageCodes()))
+}
+
+// Handle /cancel, which stops waiting for a corrected total after "Fix total"
+func handleCancelCommand(ctx context.Context, message TelegramMessage, args string) {
+	key := "cancel.nothing"
+	if cancelPendingTotalFix(message.Chat.ID, message.From.ID) {
+		key = "cancel.done"
+	}
+	sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t(key, languageFrom(ctx)))
+}
+
+// parseCommand splits a bot command like "/safemode@my_bot on" into "/safemode" and "on".
+// Returns an empty command if the text isn't a command.
+func parseCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+
+	command, args, _ := strings.Cut(text, " ")
+
+	// Strip the bot username used in group chats
+	if i := strings.Index(command, "@"); i != -1 {
+		command = command[:i]
+	}
+
+	return strings.ToLower(command), strings.TrimSpace(args)
+}
+
+// isTotalRequest reports whether a photo caption asks for the grand total only
+func isTotalRequest(caption string) bool {
+	command, _ := parseCommand(caption)
+	return command == "/total" || strings.EqualFold(strings.TrimSpace(caption), "total")
+}
+
+// Handle /lang [code|auto] - sets the language hint used in extraction prompts.
+// /lang reply [code|auto] sets the language the bot replies in instead.
+func handleLangCommand(ctx context.Context, message TelegramMessage, args string) {
+	chatID := message.Chat.ID
+	lang := languageFrom(ctx)
+	code := strings.ToLower(args)
+
+	// "/lang reply" on its own shows the status like "/lang"
+	if rest, ok := strings.CutPrefix(code, "reply"); ok && (rest == "" || rest[0] == ' ') {
+		if rest = strings.TrimSpace(rest); rest != "" {
+			handleReplyLanguage(ctx, message, rest)
+			return
+		}
+		code = ""
+	}
+
+	switch code {
+	case "":
+		settings := getChatSettings(ctx, chatID)
+		document := t("lang.auto", lang)
+		if name, ok := supportedLanguages[settings.Language]; ok {
+			document = name
+		}
+		reply := t("lang.reply_auto", lang)
+		if settings.ReplyLanguage != "" {
+			reply = t("language.name", settings.ReplyLanguage)
+		}
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("lang.status", lang, document, reply, supportedLanguageCodes(), replyLanguageCodes()))
+		return
+	case "auto":
+		if err := updateChatSettings(ctx, chatID, func(s *ChatSettings) { s.Language = "" }); err != nil {
+			replyError(ctx, chatID, message.MessageID, t("settings.failed", lang), fmt.Errorf("clearing language: %v", err))
+			return
+		}
+		loggerFrom(ctx).Info("Language hint cleared")
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("lang.document_auto", lang))
+		return
+	}
+
+	name, ok := supportedLanguages[code]
+	if !ok {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("lang.unknown", lang, escapeMarkdown(args), supportedLanguageCodes()))
+		return
+	}
+
+	if err := updateChatSettings(ctx, chatID, func(s *ChatSettings) { s.Language = code }); err != nil {
+		replyError(ctx, chatID, message.MessageID, t("settings.failed", lang), fmt.Errorf("setting language: %v", err))
+		return
+	}
+	loggerFrom(ctx).Info("Language hint set", "language", code)
+	sendTelegramMessage(ctx, chatID, message.MessageID, t("lang.document_set", lang, name))
+}
+
+// handleReplyLanguage handles /lang reply [code|auto]. The confirmation is
+// sent in the newly chosen language.
+func handleReplyLanguage(ctx context.Context, message TelegramMessage, code string) {
+	chatID := message.Chat.ID
+	lang := languageFrom(ctx)
+
+	if code == "auto" {
+		if err := updateChatSettings(ctx, chatID, func(s *ChatSettings) { s.ReplyLanguage = "" }); err != nil {
+			replyError(ctx, chatID, message.MessageID, t("settings.failed", lang), fmt.Errorf("clearing reply language: %v", err))
+			return
+		}
+		loggerFrom(ctx).Info("Reply language cleared")
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("lang.reply_follow", userLanguage(message.From.LanguageCode)))
+		return
+	}
+
+	if _, ok := messageCatalogs[code]; !ok {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("lang.unknown", lang, escapeMarkdown(code), replyLanguageCodes()))
+		return
+	}
+
+	if err := updateChatSettings(ctx, chatID, func(s *ChatSettings) { s.ReplyLanguage = code }); err != nil {
+		replyError(ctx, chatID, message.MessageID, t("settings.failed", lang), fmt.Errorf("setting reply language: %v", err))
+		return
+	}
+	loggerFrom(ctx).Info("Reply language set", "language", code)
+	sendTelegramMessage(ctx, chatID, message.MessageID, t("lang.reply_set", code))
+}
+
+// Handle /safemode [on|off] - only chat admins can change it
+func handleSafeModeCommand(ctx context.Context, message TelegramMessage, args string) {
+	chatID := message.Chat.ID
+	lang := languageFrom(ctx)
+
+	switch strings.ToLower(args) {
+	case "":
+		if getChatSettings(ctx, chatID).safeModeEnabled() {
+			sendTelegramMessage(ctx, chatID, message.MessageID, t("safemode.on", lang))
+		} else {
+			sendTelegramMessage(ctx, chatID, message.MessageID, t("safemode.off", lang))
+		}
+		return
+	case "on", "off":
+	default:
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("safemode.usage", lang))
+		return
+	}
+
+	isAdmin, err := isChatAdmin(ctx, chatID, message.From.ID, message.Chat.Type)
+	if err != nil {
+		replyError(ctx, chatID, message.MessageID, t("safemode.check_error", lang), fmt.Errorf("checking admin status of user %d: %v", message.From.ID, err))
+		return
+	}
+	if !isAdmin {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("safemode.admins_only", lang))
+		return
+	}
+
+	enabled := strings.ToLower(args) == "on"
+	if err := updateChatSettings(ctx, chatID, func(s *ChatSettings) { s.SafeMode = &enabled }); err != nil {
+		replyError(ctx, chatID, message.MessageID, t("settings.failed", lang), fmt.Errorf("setting safe mode: %v", err))
+		return
+	}
+
+	loggerFrom(ctx).Info("Safe mode changed", "enabled", enabled, "user_id", message.From.ID)
+	if enabled {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("safemode.enabled", lang))
+	} else {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("safemode.disabled", lang))
+	}
+}
+
+// Handle /format [fixed|default] - the layout invoice replies use in this chat
+func handleFormatCommand(ctx context.Context, message TelegramMessage, args string) {
+	chatID := message.Chat.ID
+	lang := languageFrom(ctx)
+
+	var format, reply string
+	switch strings.ToLower(args) {
+	case "":
+		if getChatSettings(ctx, chatID).Format == invoiceFormatFixed {
+			sendTelegramMessage(ctx, chatID, message.MessageID, t("format.status_fixed", lang))
+		} else {
+			sendTelegramMessage(ctx, chatID, message.MessageID, t("format.status_default", lang))
+		}
+		return
+	case invoiceFormatFixed:
+		format, reply = invoiceFormatFixed, t("format.fixed", lang)
+	case "default":
+		format, reply = "", t("format.default", lang)
+	default:
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("format.usage", lang))
+		return
+	}
+
+	if err := updateChatSettings(ctx, chatID, func(s *ChatSettings) { s.Format = format }); err != nil {
+		replyError(ctx, chatID, message.MessageID, t("settings.failed", lang), fmt.Errorf("setting invoice format: %v", err))
+		return
+	}
+	loggerFrom(ctx).Info("Invoice format changed", "format", format)
+	sendTelegramMessage(ctx, chatID, message.MessageID, reply)
+}
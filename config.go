@@ -0,0 +1,487 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Config holds every setting read from the environment at startup.
+// Unset variables keep the defaults declared next to the globals they configure.
+// The webhook settings and the extract API key have no globals: the router and
+// workers are handed the Config, and commands read it with configFrom.
+type Config struct {
+	TelegramBotToken string
+	OpenAIAPIKeys    []string
+	WebhookSecret    string
+	ExtractAPIKey    string
+	WebhookURL       string
+	TelegramAPIBase  string
+	OpenAIAPIBase    string
+	BotMode          string
+	Port             string
+
+	OpenAIModel          string
+	OpenAIStartupCheck   bool
+	OpenAIKeyCooldown    time.Duration
+	OpenAIMaxRetries     int
+	OpenAIRetryBaseDelay time.Duration
+	MaxConcurrentOpenAI  int
+	OpenAIMaxTokens      int
+	OpenAITemperature    *float64
+	OpenAISeed           *int64
+	SystemPrompt         string
+	ExtractionPrompt     string
+	CaptionRulesFile     string
+	RetryModel           string
+	RetryCacheTTL        time.Duration
+	ExtractionCacheTTL   time.Duration
+
+	SafeMode                 bool
+	Moderation               bool
+	ModerationRefusalMessage string
+	ClassifyImages           bool
+	NonInvoiceMessage        string
+	PreprocessImages         bool
+	MaxImageDimension        int
+	ShowUsage                bool
+	DryRun                   bool
+	DebugDumpRequests        bool
+	DebugDumpFile            string
+	DebugDumpPerMinute       int
+	GroupRequireMention      bool
+
+	MaxFileSizeBytes int64
+	HTTPTimeout      time.Duration
+	IdempotencyTTL   time.Duration
+	UpdateDedupSize  int
+	UpdateDedupTTL   time.Duration
+	ShutdownTimeout  time.Duration
+	WorkerCount      int
+	WorkerQueueSize  int
+	UpdateTimeout    time.Duration
+
+	FailureRateWindow    int
+	FailureRateThreshold float64
+
+	DuplicateMatchKeys []string
+	PDFFont            []byte
+	TokenPricePer1K    float64
+	AdminUserIDs       map[int64]bool
+	AllowedChatIDs     map[int64]bool
+	AllowedUserIDs     map[int64]bool
+
+	StateBackend string
+	RedisURL     string
+
+	LogRedactPatterns []*regexp.Regexp
+
+	ImageReplyTemplate   *template.Template
+	InvoiceReplyTemplate *template.Template
+}
+
+// loadConfig reads the environment through getenv and validates it.
+// Every problem is collected so a misconfigured deploy shows them all at once.
+func loadConfig(getenv func(string) string) (*Config, error) {
+	cfg := &Config{
+		TelegramAPIBase: telegramAPIBase,
+		OpenAIAPIBase:   openAIAPIBase,
+		BotMode:         "webhook",
+		Port:            "8080",
+
+		OpenAIModel:          "gpt-4o-mini",
+		OpenAIStartupCheck:   openAIStartupCheck,
+		OpenAIKeyCooldown:    openAIKeyCooldown,
+		OpenAIMaxRetries:     openAIMaxRetries,
+		OpenAIRetryBaseDelay: openAIRetryBaseDelay,
+		MaxConcurrentOpenAI:  maxConcurrentOpenAI,
+		OpenAIMaxTokens:      openAIMaxTokens,
+		OpenAITemperature:    openAITemperature,
+		SystemPrompt:         systemPrompt,
+		RetryModel:           retryModel,
+		RetryCacheTTL:        retryCacheTTL,
+		ExtractionCacheTTL:   extractionCacheTTL,
+
+		ModerationRefusalMessage: moderationRefusalMessage,
+		MaxImageDimension:        maxImageDimension,
+		GroupRequireMention:      groupRequireMention,
+		DebugDumpPerMinute:       debugDumpPerMinute,
+
+		MaxFileSizeBytes: maxFileSizeBytes,
+		HTTPTimeout:      httpTimeout,
+		IdempotencyTTL:   idempotencyTTL,
+		UpdateDedupSize:  updateDedupSize,
+		UpdateDedupTTL:   updateDedupTTL,
+		ShutdownTimeout:  25 * time.Second,
+		WorkerCount:      workerCount,
+		WorkerQueueSize:  workerQueueSize,
+		UpdateTimeout:    updateTimeout,
+
+		FailureRateWindow:    failureRateWindow,
+		FailureRateThreshold: failureRateThreshold,
+
+		DuplicateMatchKeys: duplicateMatchKeys,
+		TokenPricePer1K:    tokenPricePer1K,
+		StateBackend:       "memory",
+		LogRedactPatterns:  piiPatterns,
+	}
+	p := &configParser{getenv: getenv}
+
+	cfg.TelegramBotToken = getenv("TELEGRAM_BOT_TOKEN")
+	if cfg.TelegramBotToken == "" {
+		p.errs = append(p.errs, errors.New("TELEGRAM_BOT_TOKEN is required"))
+	}
+	// OPENAI_API_KEYS spreads requests over several keys; OPENAI_API_KEY is the single-key fallback
+	cfg.OpenAIAPIKeys = parseAPIKeys(getenv("OPENAI_API_KEYS"))
+	if len(cfg.OpenAIAPIKeys) == 0 {
+		cfg.OpenAIAPIKeys = parseAPIKeys(getenv("OPENAI_API_KEY"))
+	}
+	if len(cfg.OpenAIAPIKeys) == 0 {
+		p.errs = append(p.errs, errors.New("OPENAI_API_KEY (or OPENAI_API_KEYS) is required"))
+	}
+
+	cfg.WebhookSecret = getenv("TELEGRAM_WEBHOOK_SECRET")
+	cfg.ExtractAPIKey = getenv("EXTRACT_API_KEY")
+	p.baseURL("WEBHOOK_URL", &cfg.WebhookURL)
+	if cfg.WebhookURL != "" && !strings.HasPrefix(cfg.WebhookURL, "https://") {
+		p.invalid("WEBHOOK_URL", cfg.WebhookURL, "Telegram only delivers webhooks to https URLs")
+	}
+	p.baseURL("TELEGRAM_API_BASE", &cfg.TelegramAPIBase)
+	p.baseURL("OPENAI_API_BASE", &cfg.OpenAIAPIBase)
+	p.oneOf("BOT_MODE", []string{"webhook", "polling"}, &cfg.BotMode)
+	p.string("PORT", &cfg.Port)
+
+	p.string("OPENAI_MODEL", &cfg.OpenAIModel)
+	p.bool("OPENAI_STARTUP_CHECK", &cfg.OpenAIStartupCheck)
+	p.duration("OPENAI_KEY_COOLDOWN_SECONDS", time.Second, 1, &cfg.OpenAIKeyCooldown)
+	p.int("OPENAI_MAX_RETRIES", 0, 0, &cfg.OpenAIMaxRetries)
+	p.duration("OPENAI_RETRY_BASE_DELAY_MS", time.Millisecond, 1, &cfg.OpenAIRetryBaseDelay)
+	p.int("MAX_CONCURRENT_OPENAI", 0, 0, &cfg.MaxConcurrentOpenAI)
+	p.int("OPENAI_MAX_TOKENS", 1, openAIMaxTokensCap, &cfg.OpenAIMaxTokens)
+	// Low temperature and a fixed seed make repeated extractions more consistent
+	if value := getenv("OPENAI_TEMPERATURE"); value == "off" {
+		cfg.OpenAITemperature = nil
+	} else if value != "" {
+		temperature, err := strconv.ParseFloat(value, 64)
+		if err != nil || temperature < 0 || temperature > 2 {
+			p.invalid("OPENAI_TEMPERATURE", value, "must be a number from 0 to 2, or off")
+		} else {
+			cfg.OpenAITemperature = &temperature
+		}
+	}
+	if value := getenv("OPENAI_SEED"); value != "" {
+		seed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			p.invalid("OPENAI_SEED", value, "must be a whole number")
+		} else {
+			cfg.OpenAISeed = &seed
+		}
+	}
+	if value := getenv("OPENAI_SYSTEM_PROMPT"); value == "off" {
+		cfg.SystemPrompt = ""
+	} else if value != "" {
+		cfg.SystemPrompt = value
+	}
+	// EXTRACTION_PROMPT, then EXTRACTION_PROMPT_FILE, then a named EXTRACTION_PRESET
+	prompt, err := loadExtractionPrompt(getenv("EXTRACTION_PROMPT"), getenv("EXTRACTION_PROMPT_FILE"), getenv("EXTRACTION_PRESET"))
+	if err != nil {
+		p.errs = append(p.errs, fmt.Errorf("invalid extraction prompt configuration: %v", err))
+	}
+	cfg.ExtractionPrompt = prompt
+	cfg.CaptionRulesFile = getenv("CAPTION_RULES_FILE")
+	p.string("RETRY_MODEL", &cfg.RetryModel)
+	p.duration("RETRY_CACHE_TTL_SECONDS", time.Second, 1, &cfg.RetryCacheTTL)
+	p.duration("EXTRACTION_CACHE_TTL_SECONDS", time.Second, 0, &cfg.ExtractionCacheTTL)
+
+	p.bool("SAFE_MODE", &cfg.SafeMode)
+	p.bool("MODERATION", &cfg.Moderation)
+	p.string("MODERATION_REFUSAL_MESSAGE", &cfg.ModerationRefusalMessage)
+	p.bool("CLASSIFY_IMAGES", &cfg.ClassifyImages)
+	p.string("NON_INVOICE_MESSAGE", &cfg.NonInvoiceMessage)
+	p.bool("PREPROCESS_IMAGES", &cfg.PreprocessImages)
+	// PREPROCESS_MAX_DIMENSION is the older name from when only preprocessing downscaled
+	p.int("PREPROCESS_MAX_DIMENSION", 1, 0, &cfg.MaxImageDimension)
+	p.int("MAX_IMAGE_DIMENSION", 0, 0, &cfg.MaxImageDimension)
+	p.bool("SHOW_USAGE", &cfg.ShowUsage)
+	p.bool("DRY_RUN", &cfg.DryRun)
+	p.bool("DEBUG_DUMP_REQUESTS", &cfg.DebugDumpRequests)
+	p.string("DEBUG_DUMP_FILE", &cfg.DebugDumpFile)
+	p.int("DEBUG_DUMP_PER_MINUTE", 1, 0, &cfg.DebugDumpPerMinute)
+	p.bool("GROUP_REQUIRE_MENTION", &cfg.GroupRequireMention)
+
+	if value := getenv("MAX_FILE_SIZE_BYTES"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size <= 0 {
+			p.invalid("MAX_FILE_SIZE_BYTES", value, "must be a whole number of at least 1")
+		} else {
+			cfg.MaxFileSizeBytes = size
+		}
+	}
+	p.duration("HTTP_TIMEOUT_SECONDS", time.Second, 1, &cfg.HTTPTimeout)
+	p.duration("IDEMPOTENCY_TTL_SECONDS", time.Second, 1, &cfg.IdempotencyTTL)
+	p.int("UPDATE_DEDUP_SIZE", 1, 0, &cfg.UpdateDedupSize)
+	p.duration("UPDATE_DEDUP_TTL_SECONDS", time.Second, 1, &cfg.UpdateDedupTTL)
+	p.duration("SHUTDOWN_TIMEOUT_SECONDS", time.Second, 1, &cfg.ShutdownTimeout)
+	p.int("WORKER_COUNT", 1, 0, &cfg.WorkerCount)
+	p.int("WORKER_QUEUE_SIZE", 1, 0, &cfg.WorkerQueueSize)
+	p.duration("UPDATE_TIMEOUT_SECONDS", time.Second, 0, &cfg.UpdateTimeout)
+
+	p.int("EXTRACTION_FAILURE_WINDOW", 0, 0, &cfg.FailureRateWindow)
+	if value := getenv("EXTRACTION_FAILURE_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil || threshold < 0 || threshold >= 1 {
+			p.invalid("EXTRACTION_FAILURE_THRESHOLD", value, "must be a number from 0 up to but not including 1")
+		} else {
+			cfg.FailureRateThreshold = threshold
+		}
+	}
+
+	if value := getenv("DUPLICATE_MATCH_KEYS"); value != "" {
+		keys, err := parseDuplicateMatchKeys(value)
+		if err != nil {
+			p.invalid("DUPLICATE_MATCH_KEYS", value, err.Error())
+		} else {
+			cfg.DuplicateMatchKeys = keys
+		}
+	}
+	// Font for /pdf; the built-in one can't render Korean
+	if path := getenv("PDF_FONT_PATH"); path != "" {
+		font, err := loadPDFFont(path)
+		if err != nil {
+			p.invalid("PDF_FONT_PATH", path, err.Error())
+		}
+		cfg.PDFFont = font
+	}
+	p.float("TOKEN_PRICE_PER_1K", &cfg.TokenPricePer1K)
+	p.idList("ADMIN_USER_IDS", &cfg.AdminUserIDs)
+	p.idList("ALLOWED_CHAT_IDS", &cfg.AllowedChatIDs)
+	p.idList("ALLOWED_USER_IDS", &cfg.AllowedUserIDs)
+
+	p.oneOf("STATE_BACKEND", []string{"memory", "redis"}, &cfg.StateBackend)
+	cfg.RedisURL = getenv("REDIS_URL")
+	if cfg.StateBackend == "redis" && cfg.RedisURL == "" {
+		p.errs = append(p.errs, errors.New("REDIS_URL is required when STATE_BACKEND is redis"))
+	}
+
+	// Patterns are separated by whitespace (use \s inside one); "off" logs extracted text unmasked
+	if value := getenv("LOG_REDACT_PATTERNS"); value == "off" {
+		cfg.LogRedactPatterns = nil
+	} else if value != "" {
+		patterns, err := parseRedactPatterns(strings.Fields(value))
+		if err != nil {
+			p.invalid("LOG_REDACT_PATTERNS", value, err.Error())
+		} else {
+			cfg.LogRedactPatterns = patterns
+		}
+	}
+
+	p.replyTemplate("IMAGE_REPLY_TEMPLATE", imageReplyData{}, &cfg.ImageReplyTemplate)
+	p.replyTemplate("INVOICE_REPLY_TEMPLATE", invoiceReplyData{LineItems: []lineItemReplyData{{}}}, &cfg.InvoiceReplyTemplate)
+
+	if len(p.errs) > 0 {
+		return nil, errors.Join(p.errs...)
+	}
+	return cfg, nil
+}
+
+// withConfig returns a context carrying cfg, for handlers that need the webhook
+// settings and keys
+func withConfig(ctx context.Context, cfg *Config) context.Context {
+	return context.WithValue(ctx, configKey, cfg)
+}
+
+// configFrom returns the context's configuration, or an empty one
+func configFrom(ctx context.Context) *Config {
+	if cfg, ok := ctx.Value(configKey).(*Config); ok {
+		return cfg
+	}
+	return &Config{}
+}
+
+// apply copies the configuration into the package settings used by the handlers
+func (cfg *Config) apply() {
+	telegramBotToken = cfg.TelegramBotToken
+	openAIKeys = newKeyPool(cfg.OpenAIAPIKeys)
+	telegramAPIBase = cfg.TelegramAPIBase
+	openAIAPIBase = cfg.OpenAIAPIBase
+
+	openAIModel = cfg.OpenAIModel
+	openAIStartupCheck = cfg.OpenAIStartupCheck
+	openAIKeyCooldown = cfg.OpenAIKeyCooldown
+	openAIMaxRetries = cfg.OpenAIMaxRetries
+	openAIRetryBaseDelay = cfg.OpenAIRetryBaseDelay
+	maxConcurrentOpenAI = cfg.MaxConcurrentOpenAI
+	openAISlots = newOpenAISlots(cfg.MaxConcurrentOpenAI)
+	openAIMaxTokens = cfg.OpenAIMaxTokens
+	openAITemperature = cfg.OpenAITemperature
+	openAISeed = cfg.OpenAISeed
+	systemPrompt = cfg.SystemPrompt
+	extractionPrompt = cfg.ExtractionPrompt
+	retryModel = cfg.RetryModel
+	retryCacheTTL = cfg.RetryCacheTTL
+	extractionCacheTTL = cfg.ExtractionCacheTTL
+
+	safeMode = cfg.SafeMode
+	moderationEnabled = cfg.Moderation
+	moderationRefusalMessage = cfg.ModerationRefusalMessage
+	classifyImages = cfg.ClassifyImages
+	nonInvoiceMessage = cfg.NonInvoiceMessage
+	preprocessImages = cfg.PreprocessImages
+	maxImageDimension = cfg.MaxImageDimension
+	showUsage = cfg.ShowUsage
+	dryRun = cfg.DryRun
+	debugDumpRequests = cfg.DebugDumpRequests
+	debugDumpFile = cfg.DebugDumpFile
+	debugDumpPerMinute = cfg.DebugDumpPerMinute
+	groupRequireMention = cfg.GroupRequireMention
+
+	maxFileSizeBytes = cfg.MaxFileSizeBytes
+	httpTimeout = cfg.HTTPTimeout
+	httpClient = newHTTPClient(cfg.HTTPTimeout)
+	urlClient = newURLClient(cfg.HTTPTimeout)
+	idempotencyTTL = cfg.IdempotencyTTL
+	updateDedupSize = cfg.UpdateDedupSize
+	updateDedupTTL = cfg.UpdateDedupTTL
+	workerCount = cfg.WorkerCount
+	workerQueueSize = cfg.WorkerQueueSize
+	updateTimeout = cfg.UpdateTimeout
+	failureRateWindow = cfg.FailureRateWindow
+	failureRateThreshold = cfg.FailureRateThreshold
+
+	duplicateMatchKeys = cfg.DuplicateMatchKeys
+	pdfFont = cfg.PDFFont
+	tokenPricePer1K = cfg.TokenPricePer1K
+	adminUserIDs = cfg.AdminUserIDs
+	allowedChatIDs = cfg.AllowedChatIDs
+	allowedUserIDs = cfg.AllowedUserIDs
+	piiPatterns = cfg.LogRedactPatterns
+	imageReplyTemplate = cfg.ImageReplyTemplate
+	invoiceReplyTemplate = cfg.InvoiceReplyTemplate
+}
+
+// configParser reads optional variables, leaving the default in place when one is unset
+// and recording an error instead of stopping when one is invalid
+type configParser struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (p *configParser) invalid(name, value, reason string) {
+	p.errs = append(p.errs, fmt.Errorf("invalid %s %q: %s", name, value, reason))
+}
+
+func (p *configParser) string(name string, dst *string) {
+	if value := strings.TrimSpace(p.getenv(name)); value != "" {
+		*dst = value
+	}
+}
+
+func (p *configParser) bool(name string, dst *bool) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		p.invalid(name, value, "must be true or false")
+		return
+	}
+	*dst = parsed
+}
+
+// int accepts whole numbers of at least min and, when max is non-zero, at most max
+func (p *configParser) int(name string, min, max int, dst *int) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	n, err := strconv.Atoi(value)
+	switch {
+	case err != nil || n < min:
+		p.invalid(name, value, fmt.Sprintf("must be a whole number of at least %d", min))
+	case max > 0 && n > max:
+		p.invalid(name, value, fmt.Sprintf("must be at most %d", max))
+	default:
+		*dst = n
+	}
+}
+
+// duration reads a whole number of units, e.g. seconds for *_SECONDS variables
+func (p *configParser) duration(name string, unit time.Duration, min int, dst *time.Duration) {
+	n := -1
+	p.int(name, min, 0, &n)
+	if n >= 0 {
+		*dst = time.Duration(n) * unit
+	}
+}
+
+func (p *configParser) float(name string, dst *float64) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < 0 {
+		p.invalid(name, value, "must be a number of at least 0")
+		return
+	}
+	*dst = f
+}
+
+func (p *configParser) oneOf(name string, allowed []string, dst *string) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	for _, option := range allowed {
+		if value == option {
+			*dst = value
+			return
+		}
+	}
+	p.invalid(name, value, "expected one of "+strings.Join(allowed, ", "))
+}
+
+func (p *configParser) baseURL(name string, dst *string) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	base, err := parseBaseURL(value)
+	if err != nil {
+		p.invalid(name, value, err.Error())
+		return
+	}
+	*dst = base
+}
+
+func (p *configParser) idList(name string, dst *map[int64]bool) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	ids, err := parseIDList(value)
+	if err != nil {
+		p.invalid(name, value, err.Error())
+		return
+	}
+	*dst = ids
+}
+
+func (p *configParser) replyTemplate(name string, sample any, dst **template.Template) {
+	value := p.getenv(name)
+	if value == "" {
+		return
+	}
+	tmpl, err := parseReplyTemplate(name, value, sample)
+	if err != nil {
+		p.invalid(name, value, err.Error())
+		return
+	}
+	*dst = tmpl
+}
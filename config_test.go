@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testEnv is a getenv reading from vars, starting from the required settings
+func testEnv(vars map[string]string) func(string) string {
+	env := map[string]string{
+		"TELEGRAM_BOT_TOKEN": testBotToken,
+		"OPENAI_API_KEY":     "sk-test",
+	}
+	for name, value := range vars {
+		env[name] = value
+	}
+	return func(name string) string { return env[name] }
+}
+
+func TestLoadConfigRejectsMissingAndInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want []string // each must appear in the error
+	}{
+		{"no bot token", map[string]string{"TELEGRAM_BOT_TOKEN": ""}, []string{"TELEGRAM_BOT_TOKEN is required"}},
+		{"no OpenAI key", map[string]string{"OPENAI_API_KEY": ""}, []string{"OPENAI_API_KEY (or OPENAI_API_KEYS) is required"}},
+		{"redis without a URL", map[string]string{"STATE_BACKEND": "redis"}, []string{"REDIS_URL is required"}},
+		{"bool", map[string]string{"SAFE_MODE": "sometimes"}, []string{`invalid SAFE_MODE "sometimes": must be true or false`}},
+		{"int below min", map[string]string{"WORKER_COUNT": "0"}, []string{`invalid WORKER_COUNT "0": must be a whole number of at least 1`}},
+		{"int above max", map[string]string{"OPENAI_MAX_TOKENS": "999999"}, []string{`invalid OPENAI_MAX_TOKENS "999999": must be at most`}},
+		{"duration", map[string]string{"HTTP_TIMEOUT_SECONDS": "1.5"}, []string{`invalid HTTP_TIMEOUT_SECONDS "1.5"`}},
+		{"one of", map[string]string{"BOT_MODE": "push"}, []string{`invalid BOT_MODE "push": expected one of webhook, polling`}},
+		{"webhook over http", map[string]string{"WEBHOOK_URL": "http://example.com/webhook"}, []string{"invalid WEBHOOK_URL", "https"}},
+		{"temperature", map[string]string{"OPENAI_TEMPERATURE": "3"}, []string{`invalid OPENAI_TEMPERATURE "3"`}},
+		{"failure threshold", map[string]string{"EXTRACTION_FAILURE_THRESHOLD": "1"}, []string{`invalid EXTRACTION_FAILURE_THRESHOLD "1"`}},
+		{"ID list", map[string]string{"ADMIN_USER_IDS": "1,two"}, []string{`invalid ADMIN_USER_IDS "1,two"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := loadConfig(testEnv(tt.vars))
+			if err == nil {
+				t.Fatalf("loaded %+v, want an error", cfg)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigReportsEveryProblem(t *testing.T) {
+	_, err := loadConfig(testEnv(map[string]string{
+		"TELEGRAM_BOT_TOKEN": "",
+		"OPENAI_API_KEY":     "",
+		"PORT":               "8080",
+		"WORKER_COUNT":       "none",
+		"BOT_MODE":           "push",
+	}))
+	if err == nil {
+		t.Fatal("no error for an invalid configuration")
+	}
+	// errors.Join puts each problem on its own line
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) != 4 {
+		t.Errorf("%d problems reported, want 4:\n%s", len(lines), err)
+	}
+	for _, want := range []string{"TELEGRAM_BOT_TOKEN", "OPENAI_API_KEY", "WORKER_COUNT", "BOT_MODE"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %s:\n%s", want, err)
+		}
+	}
+}
+
+func TestLoadConfigReadsValidSettings(t *testing.T) {
+	cfg, err := loadConfig(testEnv(map[string]string{
+		"OPENAI_API_KEY":         "",
+		"OPENAI_API_KEYS":        "sk-one, sk-two",
+		"BOT_MODE":               "polling",
+		"WORKER_COUNT":           "8",
+		"UPDATE_TIMEOUT_SECONDS": "0",
+		"OPENAI_TEMPERATURE":     "off",
+		"ADMIN_USER_IDS":         "1, 2",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.OpenAIAPIKeys) != 2 {
+		t.Errorf("OpenAI keys %q, want both from OPENAI_API_KEYS", cfg.OpenAIAPIKeys)
+	}
+	if cfg.BotMode != "polling" || cfg.WorkerCount != 8 || cfg.UpdateTimeout != 0 {
+		t.Errorf("got mode %q, %d workers, timeout %v", cfg.BotMode, cfg.WorkerCount, cfg.UpdateTimeout)
+	}
+	if cfg.OpenAITemperature != nil {
+		t.Errorf("temperature %v, want none for off", *cfg.OpenAITemperature)
+	}
+	if !cfg.AdminUserIDs[1] || !cfg.AdminUserIDs[2] {
+		t.Errorf("admin IDs %v, want 1 and 2", cfg.AdminUserIDs)
+	}
+	// Unset settings keep their defaults
+	if cfg.Port != "8080" || cfg.ShutdownTimeout != 25*time.Second || cfg.StateBackend != "memory" {
+		t.Errorf("defaults changed: port %q, shutdown %v, state %q", cfg.Port, cfg.ShutdownTimeout, cfg.StateBackend)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+)
+
+// Every update gets a short correlation ID. It's carried in a context along
+// with a logger that includes it, so the download, moderation and OpenAI logs
+// for one update can be found together, and it's shown to users in error
+// replies so they can quote it.
+type contextKey int
+
+const (
+	correlationIDKey contextKey = iota
+	loggerKey
+	configKey
+)
+
+func newCorrelationID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withCorrelationID returns a context carrying the ID and a logger that includes it
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, correlationIDKey, id)
+	return withLogger(ctx, loggerFrom(ctx).With("correlation_id", id))
+}
+
+// correlationID returns the context's correlation ID, or "" if it has none
+func correlationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey).(string)
+	return id
+}
+
+// withLogger returns a context whose helpers log through logger
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// loggerFrom returns the context's logger, or the default logger
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
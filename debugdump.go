@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// Dump every chat completion request and response for prompt debugging (DEBUG_DUMP_REQUESTS)
+	debugDumpRequests bool
+
+	// File the dumps are appended to as JSON lines (DEBUG_DUMP_FILE); empty logs them instead
+	debugDumpFile string
+
+	// At most this many dumps are written per minute (DEBUG_DUMP_PER_MINUTE); the rest are dropped
+	debugDumpPerMinute = 10
+)
+
+// debugDumpKey counts the dumps of one calendar minute in the state store, so
+// instances sharing it share the limit; KEY:dropped counts the ones dropped
+func debugDumpKey(minute time.Time) string {
+	return fmt.Sprintf("debugdump:%d", minute.Unix())
+}
+
+// allowDebugDump reports whether another dump may be written this minute, and
+// for the minute's first dump how many were dropped in the previous minute so
+// the gap shows up in the dump file. Store errors drop the dump.
+func allowDebugDump(ctx context.Context) (bool, int) {
+	minute := time.Now().Truncate(time.Minute)
+	key := debugDumpKey(minute)
+	n, err := stateStore.Incr(ctx, key, 2*time.Minute)
+	if err != nil {
+		loggerFrom(ctx).Warn("Error counting debug dumps", "error", err)
+		return false, 0
+	}
+	if n > int64(debugDumpPerMinute) {
+		if _, err := stateStore.Incr(ctx, key+":dropped", 2*time.Minute); err != nil {
+			loggerFrom(ctx).Warn("Error counting dropped debug dumps", "error", err)
+		}
+		return false, 0
+	}
+	if n > 1 {
+		return true, 0
+	}
+	value, _, err := stateStore.Get(ctx, debugDumpKey(minute.Add(-time.Minute))+":dropped")
+	if err != nil {
+		loggerFrom(ctx).Warn("Error reading dropped debug dumps", "error", err)
+	}
+	dropped, _ := strconv.Atoi(value)
+	return true, dropped
+}
+
+// openAIDump is one dumped request/response pair
+type openAIDump struct {
+	Time          time.Time       `json:"time"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Status        int             `json:"status"`
+	DurationMS    int64           `json:"duration_ms"`
+	Request       OpenAIRequest   `json:"request"`
+	Response      json.RawMessage `json:"response"`
+	DroppedBefore int             `json:"dropped_before,omitempty"`
+}
+
+// dumpOpenAIExchange records what was sent to OpenAI and what came back. Images
+// are replaced by their hash and size, and the body is the only part of the
+// request dumped, so the API key in the headers never is.
+func dumpOpenAIExchange(ctx context.Context, request OpenAIRequest, status int, response []byte, duration time.Duration) {
+	if !debugDumpRequests {
+		return
+	}
+	ok, dropped := allowDebugDump(ctx)
+	if !ok {
+		return
+	}
+
+	dump := openAIDump{
+		Time:          time.Now().UTC(),
+		CorrelationID: correlationID(ctx),
+		Status:        status,
+		DurationMS:    duration.Milliseconds(),
+		Request:       withoutImageData(request),
+		DroppedBefore: dropped,
+	}
+	if json.Valid(response) {
+		dump.Response = response
+	} else {
+		dump.Response, _ = json.Marshal(string(response))
+	}
+
+	// No HTML escaping, so the image placeholders and prompts stay readable
+	var buf strings.Builder
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(dump); err != nil {
+		loggerFrom(ctx).Error("Error encoding OpenAI request dump", "error", err)
+		return
+	}
+	line := redactSecrets(strings.TrimSuffix(buf.String(), "\n"))
+
+	if debugDumpFile == "" {
+		loggerFrom(ctx).Info("OpenAI request dump", "dump", line)
+		return
+	}
+	if err := appendDump(line); err != nil {
+		loggerFrom(ctx).Error("Error writing OpenAI request dump", "path", debugDumpFile, "error", err)
+	}
+}
+
+var debugDumpFileMu sync.Mutex
+
+func appendDump(line string) error {
+	debugDumpFileMu.Lock()
+	defer debugDumpFileMu.Unlock()
+
+	file, err := os.OpenFile(debugDumpFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// withoutImageData returns a copy of the request with each base64 image
+// replaced by a short description like "data:image/jpeg;base64,<sha256:3f9a0c… 48213 bytes>"
+func withoutImageData(request OpenAIRequest) OpenAIRequest {
+	messages := make([]Message, len(request.Messages))
+	for i, message := range request.Messages {
+		content := make([]Content, len(message.Content))
+		for j, part := range message.Content {
+			if part.ImageURL != nil && strings.HasPrefix(part.ImageURL.URL, "data:") {
+				contentType, data := decodeDataURL(part.ImageURL.URL)
+				image := *part.ImageURL
+				image.URL = fmt.Sprintf("data:%s;base64,<sha256:%s %d bytes>", contentType, contentHash(data)[:16], len(data))
+				part.ImageURL = &image
+			}
+			content[j] = part
+		}
+		messages[i] = Message{Role: message.Role, Content: content}
+	}
+	request.Messages = messages
+	return request
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Recently seen update IDs, so Telegram's webhook retries aren't processed twice
+// (UPDATE_DEDUP_SIZE, UPDATE_DEDUP_TTL_SECONDS)
+var (
+	updateDedupSize               = 1000
+	updateDedupTTL                = time.Hour
+	seenUpdates     updateDeduper = newUpdateSet()
+)
+
+// updateDeduper tracks which update IDs have already been processed. The
+// webhook handler and the polling loop call it concurrently.
+type updateDeduper interface {
+	markSeen(ctx context.Context, id int64) bool
+	forget(ctx context.Context, id int64)
+}
+
+// updateSet is a bounded LRU set of update IDs with per-entry expiry
+type updateSet struct {
+	mu      sync.Mutex
+	order   *list.List // front is most recently seen
+	entries map[int64]*list.Element
+}
+
+type seenUpdate struct {
+	id      int64
+	expires time.Time
+}
+
+func newUpdateSet() *updateSet {
+	return &updateSet{
+		order:   list.New(),
+		entries: make(map[int64]*list.Element),
+	}
+}
+
+// markSeen records the update ID and reports whether it was already seen
+// within the TTL. The oldest IDs are evicted once the set is full.
+func (s *updateSet) markSeen(ctx context.Context, id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if element, ok := s.entries[id]; ok {
+		entry := element.Value.(*seenUpdate)
+		if now.Before(entry.expires) {
+			s.order.MoveToFront(element)
+			return true
+		}
+		entry.expires = now.Add(updateDedupTTL)
+		s.order.MoveToFront(element)
+		return false
+	}
+
+	s.entries[id] = s.order.PushFront(&seenUpdate{id: id, expires: now.Add(updateDedupTTL)})
+	for s.order.Len() > updateDedupSize {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*seenUpdate).id)
+	}
+	return false
+}
+
+// forget removes an update ID so a redelivery of it is processed
+func (s *updateSet) forget(ctx context.Context, id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[id]; ok {
+		s.order.Remove(element)
+		delete(s.entries, id)
+	}
+}
+
+// storeUpdateSet keeps seen update IDs in the state store, so instances
+// sharing a Redis backend don't process the same update twice
+type storeUpdateSet struct {
+	store StateStore
+}
+
+func updateKey(id int64) string {
+	return fmt.Sprintf("update:%d", id)
+}
+
+// markSeen uses an atomic increment so only the first instance to see the
+// update processes it. Store errors let the update through.
+func (s storeUpdateSet) markSeen(ctx context.Context, id int64) bool {
+	n, err := s.store.Incr(ctx, updateKey(id), updateDedupTTL)
+	if err != nil {
+		loggerFrom(ctx).Error("Error checking update ID", "update_id", id, "error", err)
+		return false
+	}
+	return n > 1
+}
+
+func (s storeUpdateSet) forget(ctx context.Context, id int64) {
+	if err := s.store.Delete(ctx, updateKey(id)); err != nil {
+		loggerFrom(ctx).Error("Error forgetting update ID", "update_id", id, "error", err)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebhookDropsRedeliveredUpdate(t *testing.T) {
+	captureLogs(t)
+	defer restore(&seenUpdates, updateDeduper(newUpdateSet()))()
+	defer restore(&updateQueue, make(chan TelegramUpdate, 10))()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/webhook", handleWebhook)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/webhook", strings.NewReader(`{"update_id":5001,"message":{"message_id":1,"chat":{"id":1,"type":"private"},"text":"hi"}}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("delivery %d: status %d", i+1, w.Code)
+		}
+	}
+
+	if n := len(updateQueue); n != 1 {
+		t.Fatalf("%d updates queued, want the redelivery dropped", n)
+	}
+}
+
+func TestUpdateSetEvictsLeastRecentlySeen(t *testing.T) {
+	defer restore(&updateDedupSize, 3)()
+	set := newUpdateSet()
+
+	for _, id := range []int64{1, 2, 3} {
+		if set.markSeen(context.Background(), id) {
+			t.Fatalf("update %d reported as seen on first delivery", id)
+		}
+	}
+	// Seeing 1 again makes 2 the least recently seen
+	if !set.markSeen(context.Background(), 1) {
+		t.Fatal("update 1 not reported as seen")
+	}
+	set.markSeen(context.Background(), 4)
+
+	if set.markSeen(context.Background(), 2) {
+		t.Error("update 2 should have been evicted")
+	}
+	// Re-adding 2 evicted 3 in turn; 1 and 4 are still remembered
+	for _, id := range []int64{1, 4} {
+		if !set.markSeen(context.Background(), id) {
+			t.Errorf("update %d was evicted", id)
+		}
+	}
+	if set.order.Len() != 3 || len(set.entries) != 3 {
+		t.Errorf("set holds %d/%d entries, want 3", set.order.Len(), len(set.entries))
+	}
+}
+
+func TestUpdateSetExpiresEntries(t *testing.T) {
+	defer restore(&updateDedupTTL, 20*time.Millisecond)()
+	set := newUpdateSet()
+
+	set.markSeen(context.Background(), 1)
+	if !set.markSeen(context.Background(), 1) {
+		t.Fatal("update 1 not reported as seen within the TTL")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if set.markSeen(context.Background(), 1) {
+		t.Error("update 1 still reported as seen after the TTL")
+	}
+}
+
+func TestUpdateSetForget(t *testing.T) {
+	set := newUpdateSet()
+	set.markSeen(context.Background(), 1)
+	set.forget(context.Background(), 1)
+	if set.markSeen(context.Background(), 1) {
+		t.Error("forgotten update reported as seen")
+	}
+}
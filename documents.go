@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gen2brain/heic"
+	"golang.org/x/image/webp"
+)
+
+// Image types accepted as documents. OpenAI reads JPEG and PNG directly;
+// the rest are decoded and re-encoded as JPEG first.
+var documentImageDecoders = map[string]func([]byte) (image.Image, error){
+	"image/jpeg": nil,
+	"image/png":  nil,
+	"image/heic": decodeHEIC,
+	"image/heif": decodeHEIC,
+	"image/webp": decodeWebP,
+}
+
+var errUnreadableImage = errors.New("image could not be decoded")
+
+// Telegram sometimes sends iPhone files as application/octet-stream, so fall back to the extension
+var documentExtensionTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".heic": "image/heic",
+	".heif": "image/heif",
+	".webp": "image/webp",
+}
+
+// processDocument runs an image sent as a file through the same extraction as photos
+func processDocument(ctx context.Context, message TelegramMessage, totalOnly bool) {
+	document := message.Document
+
+	if isZipArchive(document.MimeType, document.FileName) {
+		processZipArchive(ctx, message)
+		return
+	}
+
+	// Word and Excel files are read as text rather than images
+	if officeType := officeDocumentType(document.MimeType, document.FileName); officeType != "" {
+		processOfficeDocument(ctx, message, officeType)
+		return
+	}
+
+	logger := loggerFrom(ctx).With("file_id", document.FileID)
+	ctx = withLogger(ctx, logger)
+	lang := languageFrom(ctx)
+
+	mimeType := documentMimeType(document.MimeType, document.FileName)
+	if _, supported := documentImageDecoders[mimeType]; !supported {
+		logger.Info("Unsupported document type", "mime_type", document.MimeType, "file_name", document.FileName)
+		if isLegacyOfficeDocument(document.MimeType, document.FileName) {
+			sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("document.legacy_office", lang))
+			return
+		}
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("document.unsupported", lang))
+		return
+	}
+
+	// Safe mode forbids sending images to OpenAI
+	settings := getChatSettings(ctx, message.Chat.ID)
+	if settings.safeModeEnabled() {
+		logger.Info("Safe mode enabled, skipping image OCR")
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("ocr_disabled", lang))
+		return
+	}
+
+	// Don't download files we won't process anyway
+	if reply := telegramFileSizeError(int64(document.FileSize), lang); reply != "" {
+		logger.Warn("Rejected document: file too large", "file_size", document.FileSize, "max_file_size", maxFileSizeBytes)
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, reply)
+		return
+	}
+
+	start := time.Now()
+	content, err := downloadTelegramFile(ctx, document.FileID)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, downloadErrorMessage(err, t("download.file_failed", lang), lang), fmt.Errorf("downloading document %s: %v", document.FileID, err))
+		return
+	}
+	fileHash := contentHash(content)
+
+	content, err = imageForExtraction(content, mimeType)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, t("document.unreadable_image", lang), fmt.Errorf("converting %s document: %v", mimeType, err))
+		return
+	}
+
+	logger.Info("Document downloaded", "mime_type", mimeType, "duration_ms", time.Since(start).Milliseconds(), "file_hash", fileHash)
+	rememberImage(message, imageDataURL(content), totalOnly)
+	extractAndReply(ctx, message, prepareForExtraction(ctx, content), fileHash, totalOnly, settings, openAIModel)
+}
+
+// documentMimeType returns a file's image type, using the file extension when the mime type is generic
+func documentMimeType(mimeType, fileName string) string {
+	mimeType = strings.ToLower(mimeType)
+	if _, ok := documentImageDecoders[mimeType]; ok {
+		return mimeType
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		return documentExtensionTypes[strings.ToLower(filepath.Ext(fileName))]
+	}
+	return mimeType
+}
+
+// imageForExtraction returns the image in a format OpenAI reads, converting it if needed.
+// mimeType must be one of documentImageDecoders.
+func imageForExtraction(content []byte, mimeType string) ([]byte, error) {
+	decode := documentImageDecoders[mimeType]
+	if decode == nil {
+		return content, nil
+	}
+
+	converted, err := convertToJPEG(content, decode)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUnreadableImage, err)
+	}
+	return converted, nil
+}
+
+// convertToJPEG decodes an image OpenAI doesn't accept and re-encodes it as JPEG
+func convertToJPEG(content []byte, decode func([]byte) (image.Image, error)) ([]byte, error) {
+	img, err := decode(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeHEIC(content []byte) (image.Image, error) {
+	return heic.Decode(bytes.NewReader(content))
+}
+
+func decodeWebP(content []byte) (image.Image, error) {
+	return webp.Decode(bytes.NewReader(content))
+}
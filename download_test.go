@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTelegramFileSizeError(t *testing.T) {
+	defer restore(&maxFileSizeBytes, 5*1024*1024)()
+
+	tests := []struct {
+		name string
+		size int64
+		want string
+	}{
+		{"small", 100 * 1024, ""},
+		{"at our limit", 5 * 1024 * 1024, ""},
+		{"over our limit", 6 * 1024 * 1024, fileTooLargeMessage(6*1024*1024, "en")},
+		{"at Telegram's limit", telegramDownloadLimit, fileTooLargeMessage(telegramDownloadLimit, "en")},
+		{"over Telegram's limit", telegramDownloadLimit + 1, englishText("file.telegram_too_large")},
+	}
+	for _, tt := range tests {
+		if got := telegramFileSizeError(tt.size, "en"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOversizedPhotoIsNotDownloaded(t *testing.T) {
+	var getFileCalls atomic.Int32
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/getFile") {
+			getFileCalls.Add(1)
+		}
+		telegram.ServeHTTP(w, r)
+	}), http.NotFoundHandler())
+
+	update := photoUpdate(9300)
+	update.Message.Photo[0].FileSize = 25 * 1024 * 1024
+	processTestUpdate(update)
+
+	if sent := telegram.sent(); len(sent) != 1 || sent[0] != englishText("file.telegram_too_large") {
+		t.Errorf("sent %q", sent)
+	}
+	if n := getFileCalls.Load(); n != 0 {
+		t.Errorf("getFile called %d times for a file over Telegram's limit", n)
+	}
+}
+
+func TestDownloadTelegramFileTooBig(t *testing.T) {
+	useFakeAPIs(t, &fakeTelegram{getFileBody: `{"ok":false,"error_code":400,"description":"Bad Request: file is too big"}`}, http.NotFoundHandler())
+
+	_, err := downloadTelegramFile(context.Background(), "photo")
+	if !errors.Is(err, errTelegramFileTooBig) {
+		t.Fatalf("got %v, want errTelegramFileTooBig", err)
+	}
+	if got := downloadErrorMessage(err, "fallback", "en"); got != englishText("file.telegram_too_large") {
+		t.Errorf("reply %q", got)
+	}
+}
+
+func TestDownloadTelegramFileRefreshesExpiredLink(t *testing.T) {
+	var getFileCalls atomic.Int32
+	useFakeAPIs(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bot" + testBotToken + "/getFile":
+			// The first path handed out has already expired by the time it's fetched
+			if getFileCalls.Add(1) == 1 {
+				w.Write([]byte(`{"ok":true,"result":{"file_path":"photos/old.png"}}`))
+				return
+			}
+			w.Write([]byte(`{"ok":true,"result":{"file_path":"photos/new.png"}}`))
+		case "/file/bot" + testBotToken + "/photos/new.png":
+			w.Write([]byte("image bytes"))
+		default:
+			http.NotFound(w, r)
+		}
+	}), http.NotFoundHandler())
+
+	content, err := downloadTelegramFile(context.Background(), "photo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "image bytes" {
+		t.Errorf("got %q", content)
+	}
+	if n := getFileCalls.Load(); n != 2 {
+		t.Errorf("getFile called %d times, want 2", n)
+	}
+}
+
+func TestDownloadTelegramFileExpiredTwice(t *testing.T) {
+	useFakeAPIs(t, &fakeTelegram{downloadStatus: http.StatusNotFound}, http.NotFoundHandler())
+
+	_, err := downloadTelegramFile(context.Background(), "photo")
+	if !errors.Is(err, errFileLinkExpired) {
+		t.Fatalf("got %v, want errFileLinkExpired", err)
+	}
+	if got := downloadErrorMessage(err, "fallback", "en"); got != englishText("download.unavailable") {
+		t.Errorf("reply %q", got)
+	}
+}
+
+func TestDownloadFileCapsUnreportedSize(t *testing.T) {
+	defer restore(&maxFileSizeBytes, 10)()
+	useFakeAPIs(t, &fakeTelegram{}, http.NotFoundHandler())
+
+	// testPNG is well over 10 bytes, and the photo's reported size isn't checked here
+	if _, err := downloadTelegramFile(context.Background(), "photo"); err == nil {
+		t.Fatal("downloaded a file over maxFileSizeBytes")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// DRY_RUN skips moderation and extraction so the Telegram side can be tested without OpenAI
+var dryRun bool
+
+// dryRunReply describes the image that would have been sent to OpenAI
+func dryRunReply(imageURL, kind, model, lang string) string {
+	contentType, content := decodeDataURL(imageURL)
+
+	var b strings.Builder
+	b.WriteString(t("dry_run.header", lang) + "\n\n")
+	b.WriteString(t("dry_run.placeholder", lang) + "\n\n")
+	fmt.Fprintf(&b, "Type: %s\n", contentType)
+	fmt.Fprintf(&b, "Size: %d bytes\n", len(content))
+	if config, _, err := image.DecodeConfig(bytes.NewReader(content)); err == nil {
+		fmt.Fprintf(&b, "Dimensions: %dx%d\n", config.Width, config.Height)
+	}
+	fmt.Fprintf(&b, "Extraction: %s\n", kind)
+	fmt.Fprintf(&b, "Model: %s", escapeMarkdown(model))
+	return b.String()
+}
+
+// decodeDataURL splits a base64 data URL into its content type and bytes
+func decodeDataURL(dataURL string) (string, []byte) {
+	header, data, ok := strings.Cut(dataURL, ",")
+	if !ok {
+		return "unknown", nil
+	}
+	contentType := strings.TrimSuffix(strings.TrimPrefix(header, "data:"), ";base64")
+	content, _ := base64.StdEncoding.DecodeString(data)
+	return contentType, content
+}
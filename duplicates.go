@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Fields compared to decide whether an invoice was already sent to the chat (DUPLICATE_MATCH_KEYS)
+const (
+	matchInvoiceNumber = "invoice_number"
+	matchVendor        = "vendor"
+	matchTotal         = "total"
+	matchFileHash      = "file_hash"
+)
+
+var duplicateMatchKeys = []string{matchInvoiceNumber, matchVendor, matchTotal}
+
+// parseDuplicateMatchKeys parses a comma-separated list of match keys.
+// "off" disables duplicate detection.
+func parseDuplicateMatchKeys(value string) ([]string, error) {
+	if strings.EqualFold(strings.TrimSpace(value), "off") {
+		return nil, nil
+	}
+
+	var keys []string
+	for _, key := range strings.Split(value, ",") {
+		key = strings.ToLower(strings.TrimSpace(key))
+		switch key {
+		case "":
+			continue
+		case matchInvoiceNumber, matchVendor, matchTotal, matchFileHash:
+			keys = append(keys, key)
+		default:
+			return nil, fmt.Errorf("unknown match key %q", key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no match keys given")
+	}
+	return keys, nil
+}
+
+// isDuplicateOf reports whether candidate matches an earlier stored invoice on
+// every configured key. Keys that are empty on the new invoice never match, so
+// two receipts without invoice numbers aren't duplicates of each other.
+func isDuplicateOf(invoice *Invoice, fileHash string, earlier *StoredInvoice) bool {
+	if len(duplicateMatchKeys) == 0 {
+		return false
+	}
+
+	for _, key := range duplicateMatchKeys {
+		switch key {
+		case matchInvoiceNumber:
+			if invoice.InvoiceNumber == "" || !strings.EqualFold(strings.TrimSpace(invoice.InvoiceNumber), strings.TrimSpace(earlier.Invoice.InvoiceNumber)) {
+				return false
+			}
+		case matchVendor:
+			if invoice.Vendor == "" || !strings.EqualFold(strings.TrimSpace(invoice.Vendor), strings.TrimSpace(earlier.Invoice.Vendor)) {
+				return false
+			}
+		case matchTotal:
+			if invoice.Total == nil || earlier.Invoice.Total == nil || invoice.Total.Cmp(&earlier.Invoice.Total.Rat) != 0 {
+				return false
+			}
+		case matchFileHash:
+			if fileHash == "" || fileHash != earlier.FileHash {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// duplicateWarning returns the note appended to a reply when the invoice
+// was already sent to the chat, or "" if it wasn't
+func duplicateWarning(chatID, messageID int64, invoice *Invoice, fileHash, lang string) string {
+	earlier, ok := findDuplicateInvoice(chatID, messageID, invoice, fileHash)
+	if !ok {
+		return ""
+	}
+	return "\n\n" + t("duplicate.warning", lang, earlier.CreatedAt.Format("2006-01-02"))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// decimal parses s into a Decimal for test invoices
+func decimal(s string) *Decimal {
+	d := &Decimal{}
+	if _, ok := d.SetString(s); !ok {
+		panic("bad decimal " + s)
+	}
+	return d
+}
+
+func TestIsDuplicateOf(t *testing.T) {
+	earlier := &StoredInvoice{
+		Invoice:  Invoice{Vendor: "ACME Motors", InvoiceNumber: "INV-7", Total: decimal("12.50")},
+		FileHash: "abc",
+	}
+
+	tests := []struct {
+		name     string
+		keys     []string
+		invoice  Invoice
+		fileHash string
+		want     bool
+	}{
+		{"same invoice", duplicateMatchKeys, Invoice{Vendor: "ACME Motors", InvoiceNumber: "INV-7", Total: decimal("12.50")}, "", true},
+		{"case, spacing and trailing zeros", duplicateMatchKeys, Invoice{Vendor: "acme motors ", InvoiceNumber: " inv-7", Total: decimal("12.5")}, "", true},
+		{"different total", duplicateMatchKeys, Invoice{Vendor: "ACME Motors", InvoiceNumber: "INV-7", Total: decimal("13.50")}, "", false},
+		{"different number", duplicateMatchKeys, Invoice{Vendor: "ACME Motors", InvoiceNumber: "INV-8", Total: decimal("12.50")}, "", false},
+		{"no invoice number", duplicateMatchKeys, Invoice{Vendor: "ACME Motors", Total: decimal("12.50")}, "", false},
+		{"no total", duplicateMatchKeys, Invoice{Vendor: "ACME Motors", InvoiceNumber: "INV-7"}, "", false},
+		{"same file", []string{matchFileHash}, Invoice{}, "abc", true},
+		{"other file", []string{matchFileHash}, Invoice{}, "def", false},
+		{"detection off", nil, Invoice{Vendor: "ACME Motors", InvoiceNumber: "INV-7", Total: decimal("12.50")}, "abc", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer restore(&duplicateMatchKeys, tt.keys)()
+			if got := isDuplicateOf(&tt.invoice, tt.fileHash, earlier); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDuplicateWarning(t *testing.T) {
+	const chatID, otherChatID = 9400, 9401
+	invoice := &Invoice{Vendor: "Duplicate Test Garage", InvoiceNumber: "DT-1", Total: decimal("99.00")}
+	saveInvoice(chatID, 1, invoice, "")
+
+	if warning := duplicateWarning(chatID, 2, invoice, "", "en"); warning == "" {
+		t.Error("the same invoice sent again got no warning")
+	}
+	if warning := duplicateWarning(chatID, 1, invoice, "", "en"); warning != "" {
+		t.Errorf("re-running the original message got a warning: %q", warning)
+	}
+	if warning := duplicateWarning(otherChatID, 2, invoice, "", "en"); warning != "" {
+		t.Errorf("the invoice sent to another chat got a warning: %q", warning)
+	}
+	other := &Invoice{Vendor: "Duplicate Test Garage", InvoiceNumber: "DT-2", Total: decimal("99.00")}
+	if warning := duplicateWarning(chatID, 3, other, "", "en"); warning != "" {
+		t.Errorf("a different invoice got a warning: %q", warning)
+	}
+}
+
+func TestParseDuplicateMatchKeys(t *testing.T) {
+	keys, err := parseDuplicateMatchKeys(" Vendor, file_hash ,")
+	if err != nil || !reflect.DeepEqual(keys, []string{matchVendor, matchFileHash}) {
+		t.Errorf("got %q, %v", keys, err)
+	}
+	if keys, err := parseDuplicateMatchKeys("off"); err != nil || keys != nil {
+		t.Errorf("off: got %q, %v", keys, err)
+	}
+	for _, value := range []string{"vendor,colour", " , "} {
+		if _, err := parseDuplicateMatchKeys(value); err == nil {
+			t.Errorf("%q: want an error", value)
+		}
+	}
+}
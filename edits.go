@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// How long we remember which file a message carried, so an edit that swaps it can be detected
+const messageMediaTTL = 48 * time.Hour
+
+func messageMediaKey(chatID, messageID int64) string {
+	return fmt.Sprintf("media:%d:%d", chatID, messageID)
+}
+
+// messageMediaID returns the unique ID of the photo or document in a message, or ""
+func messageMediaID(message TelegramMessage) string {
+	if len(message.Photo) > 0 {
+		return message.Photo[len(message.Photo)-1].FileUniqueID
+	}
+	if message.Document != nil {
+		return message.Document.FileUniqueID
+	}
+	return ""
+}
+
+// rememberMessageMedia records which file a message carried when it was processed
+func rememberMessageMedia(ctx context.Context, message TelegramMessage) {
+	mediaID := messageMediaID(message)
+	if mediaID == "" {
+		return
+	}
+	if err := stateStore.Set(ctx, messageMediaKey(message.Chat.ID, message.MessageID), mediaID, messageMediaTTL); err != nil {
+		loggerFrom(ctx).Error("Error remembering message media", "message_id", message.MessageID, "error", err)
+	}
+}
+
+// handleEditedMessage decides what to do with an edited_message update.
+//
+// Only edits that replace the photo or document are processed, as if the
+// message had just been sent. Caption and text edits are ignored: re-running
+// extraction (and billing OpenAI) every time someone fixes a typo would
+// surprise users, and edited commands would run twice. Edits to messages we
+// didn't process or no longer remember are ignored too.
+func handleEditedMessage(ctx context.Context, updateID int64, message TelegramMessage) {
+	logger := loggerFrom(ctx).With("chat_id", message.Chat.ID, "message_id", message.MessageID)
+
+	mediaID := messageMediaID(message)
+	if mediaID == "" {
+		logger.Debug("Ignoring edited message without media")
+		return
+	}
+
+	previous, ok, err := stateStore.Get(ctx, messageMediaKey(message.Chat.ID, message.MessageID))
+	if err != nil {
+		logger.Error("Error loading message media", "error", err)
+		return
+	}
+	if !ok || previous == mediaID {
+		logger.Debug("Ignoring edited message, media unchanged or unknown")
+		return
+	}
+
+	logger.Info("Edited message has new media, processing it again")
+
+	// Answer the replaced album item on its own rather than waiting for the rest of an album
+	message.MediaGroupID = ""
+	processMessage(ctx, TelegramUpdate{UpdateID: updateID, Message: message})
+}
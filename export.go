@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"time"
+)
+
+// Handle /export
+func handleExportCommand(ctx context.Context, message TelegramMessage, args string) {
+	lang := languageFrom(ctx)
+	invoices := chatInvoices(message.Chat.ID)
+	if len(invoices) == 0 {
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("export.empty", lang))
+		return
+	}
+
+	data, err := invoicesCSV(invoices)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, t("export.failed", lang), err)
+		return
+	}
+
+	filename := fmt.Sprintf("invoices-%s.csv", time.Now().Format("2006-01-02"))
+	caption := t("export.caption", lang, len(invoices))
+	if err := sendDocumentToTelegram(ctx, message.Chat.ID, data, filename, caption); err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, t("export.send_failed", lang), err)
+		return
+	}
+
+	loggerFrom(ctx).Info("Exported invoices", "chat_id", message.Chat.ID, "count", len(invoices))
+}
+
+// invoicesCSV writes one row per invoice. It starts with a UTF-8 BOM so Excel
+// doesn't mangle non-Latin vendor names.
+func invoicesCSV(invoices []StoredInvoice) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\uFEFF")
+
+	writer := csv.NewWriter(&buf)
+	writer.Write([]string{"date", "vendor", "invoice_number", "total", "currency"})
+	for _, stored := range invoices {
+		invoice := stored.Invoice
+		date := ""
+		if !invoice.Date.IsZero() {
+			date = invoice.Date.Format("2006-01-02")
+		}
+		writer.Write([]string{date, invoice.Vendor, invoice.InvoiceNumber, invoice.Total.String(), invoice.Currency})
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write CSV: %v", err)
+	}
+	return buf.Bytes(), nil
+}
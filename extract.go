@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errImageFlagged = errors.New("image flagged by moderation")
+
+// ExtractionResult is what extractDocument found in a file
+type ExtractionResult struct {
+	Invoice *Invoice
+	Text    string
+	Usage   Usage
+}
+
+// extractDocument runs the same extraction as Telegram photos on a file's bytes.
+// mimeType must be one of documentImageDecoders; mode is "invoice" for
+// structured fields or "text" for the plain extraction prompt.
+func extractDocument(ctx context.Context, content []byte, mimeType, mode string, settings ChatSettings) (*ExtractionResult, error) {
+	logger := loggerFrom(ctx)
+	fileHash := contentHash(content)
+
+	content, err := imageForExtraction(content, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	imageURL := prepareForExtraction(ctx, content)
+
+	// Refuse flagged content before extraction
+	if moderationEnabled {
+		flagged, categories, err := moderateImage(ctx, imageURL)
+		if err != nil {
+			return nil, fmt.Errorf("running moderation check: %w", err)
+		}
+		if flagged {
+			logger.Warn("Image flagged by moderation", "categories", categories)
+			return nil, errImageFlagged
+		}
+	}
+
+	start := time.Now()
+	if mode == "text" {
+		prompt := buildPrompt(extractionPrompt, settings)
+		text, usage, err := cachedExtraction(ctx, fileHash, "text", openAIModel, prompt, func() (string, Usage, error) {
+			return extractTextFromImage(ctx, imageURL, prompt, openAIModel)
+		})
+		recordExtraction("text", err)
+		if err != nil {
+			return nil, fmt.Errorf("extracting text: %w", err)
+		}
+		logger.Info("Text extracted", "duration_ms", time.Since(start).Milliseconds())
+		// API callers get an empty string rather than the model's "no text" sentence
+		if isNoTextAnswer(text) {
+			text = ""
+		}
+		return &ExtractionResult{Text: text, Usage: usage}, nil
+	}
+
+	invoice, usage, err := cachedInvoiceExtraction(ctx, fileHash, imageURL, buildPrompt(invoiceExtractionPrompt, settings), openAIModel)
+	recordExtraction("invoice", err)
+	if err != nil {
+		return nil, fmt.Errorf("extracting invoice fields: %w", err)
+	}
+	logger.Info("Invoice extracted", "vendor", invoice.Vendor, "duration_ms", time.Since(start).Milliseconds())
+	return &ExtractionResult{Invoice: invoice, Usage: usage}, nil
+}
+
+// requireExtractAPIKey rejects /extract requests without the right X-API-Key.
+// It runs before idempotencyMiddleware so a replayed Idempotency-Key can't
+// fetch someone else's cached result without the key.
+func requireExtractAPIKey(cfg *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-API-Key")), []byte(cfg.ExtractAPIKey)) != 1 {
+			slog.Warn("Rejected extract request: invalid API key", "client_ip", c.ClientIP())
+			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid or missing X-API-Key"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleExtract extracts an uploaded file for callers outside Telegram.
+// Form fields: file (required), mode (invoice or text), lang (document language code).
+func handleExtract(c *gin.Context) {
+	ctx := withCorrelationID(c.Request.Context(), newCorrelationID())
+	logger := loggerFrom(ctx)
+
+	// Safe mode forbids sending images to OpenAI
+	if safeMode {
+		c.JSON(403, gin.H{"error": "Image OCR is disabled by policy"})
+		return
+	}
+
+	mode := c.DefaultPostForm("mode", "invoice")
+	if mode != "invoice" && mode != "text" {
+		c.JSON(400, gin.H{"error": "mode must be invoice or text"})
+		return
+	}
+	var settings ChatSettings
+	if lang := c.PostForm("lang"); lang != "" {
+		if _, ok := supportedLanguages[lang]; !ok {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Unknown lang %q, expected one of %s", lang, supportedLanguageCodes())})
+			return
+		}
+		settings.Language = lang
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "No file uploaded"})
+		return
+	}
+	if file.Size > maxFileSizeBytes {
+		logger.Warn("Rejected upload: file too large", "filename", file.Filename, "file_size", file.Size, "max_file_size", maxFileSizeBytes)
+		c.JSON(413, gin.H{"error": fileTooLargeMessage(file.Size, defaultLanguage)})
+		return
+	}
+
+	mimeType := documentMimeType(file.Header.Get("Content-Type"), file.Filename)
+	if _, supported := documentImageDecoders[mimeType]; !supported {
+		logger.Info("Unsupported upload type", "content_type", file.Header.Get("Content-Type"), "filename", file.Filename)
+		c.JSON(415, gin.H{"error": "Unsupported file type. Send a JPEG, PNG, HEIC or WebP image."})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		logger.Error("Error opening uploaded file", "error", err)
+		c.JSON(500, gin.H{"error": "Failed to open uploaded file"})
+		return
+	}
+	defer src.Close()
+
+	content, err := io.ReadAll(src)
+	if err != nil {
+		logger.Error("Error reading uploaded file", "error", err)
+		c.JSON(500, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+
+	if dryRun {
+		c.JSON(200, gin.H{
+			"success":   true,
+			"dry_run":   true,
+			"mode":      mode,
+			"mime_type": mimeType,
+			"size":      len(content),
+		})
+		return
+	}
+
+	result, err := extractDocument(ctx, content, mimeType, mode, settings)
+	switch {
+	case errors.Is(err, errUnreadableImage):
+		logger.Info("Could not decode upload", "mime_type", mimeType, "error", err)
+		c.JSON(400, gin.H{"error": "Could not read the image", "correlation_id": correlationID(ctx)})
+		return
+	case errors.Is(err, errImageFlagged):
+		c.JSON(400, gin.H{"error": moderationRefusal(defaultLanguage)})
+		return
+	case err != nil:
+		logger.Error("Error extracting uploaded file", "error", err)
+		c.JSON(500, gin.H{"error": openAIErrorMessage(err, "Extraction failed", defaultLanguage), "correlation_id": correlationID(ctx)})
+		return
+	}
+
+	response := gin.H{
+		"success":        true,
+		"mode":           mode,
+		"usage":          result.Usage,
+		"correlation_id": correlationID(ctx),
+	}
+	if result.Invoice != nil {
+		response["invoice"] = result.Invoice
+	} else {
+		response["text"] = result.Text
+	}
+	c.JSON(200, response)
+}
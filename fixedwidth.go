@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/width"
+)
+
+// ChatSettings.Format value for /format fixed; empty is the default reply
+const invoiceFormatFixed = "fixed"
+
+// Description column of the /format fixed layout, in monospace cells. Longer
+// descriptions wrap onto at most fixedDescriptionLines lines and are cut with
+// "…" after that.
+const (
+	fixedDescriptionWidth = 20
+	fixedDescriptionLines = 2
+)
+
+const fixedColumnGap = "  "
+
+// fixedWidthInvoice renders the invoice as aligned plain-text columns
+// (description, quantity, unit price, amount) in a monospace block, with the
+// totals lined up under the amounts, for pasting into systems that don't
+// take Markdown
+func fixedWidthInvoice(inv *Invoice, lang string) string {
+	if inv.isEmpty() {
+		return t("invoice.empty", lang)
+	}
+
+	// Numeric columns are as wide as their header or widest value
+	headers := [3]string{t("invoice.column_qty", lang), t("invoice.column_price", lang), t("invoice.column_amount", lang)}
+	var widths [3]int
+	for i, header := range headers {
+		widths[i] = cellWidth(header)
+	}
+	rows := make([][3]string, len(inv.LineItems))
+	for i, item := range inv.LineItems {
+		rows[i] = [3]string{item.Quantity.String(), item.UnitPrice.String(), item.Amount.String()}
+		for j, value := range rows[i] {
+			widths[j] = max(widths[j], cellWidth(value))
+		}
+	}
+
+	type totalLine struct{ label, value string }
+	var totals []totalLine
+	for _, total := range []struct {
+		key    string
+		amount *Decimal
+	}{{"invoice.subtotal", inv.Subtotal}, {"invoice.tax", inv.Tax}, {"invoice.total", inv.Total}} {
+		if total.amount != nil {
+			totals = append(totals, totalLine{t(total.key, lang), total.amount.String()})
+			widths[2] = max(widths[2], cellWidth(total.amount.String()))
+		}
+	}
+	if len(totals) > 0 && inv.Currency != "" {
+		totals[len(totals)-1].label += " " + inv.Currency
+	}
+
+	// Totals are labelled across the columns left of the amounts
+	labelWidth := fixedDescriptionWidth + len(fixedColumnGap) + widths[0] + len(fixedColumnGap) + widths[1]
+	lineWidth := labelWidth + len(fixedColumnGap) + widths[2]
+	rule := strings.Repeat("-", lineWidth)
+
+	var b strings.Builder
+	b.WriteString("```\n")
+	if inv.Vendor != "" {
+		fmt.Fprintf(&b, "%s: %s\n", t("invoice.vendor", lang), plainCell(inv.Vendor))
+	}
+	if inv.InvoiceNumber != "" {
+		fmt.Fprintf(&b, "%s: %s\n", t("invoice.number", lang), plainCell(inv.InvoiceNumber))
+	}
+	if !inv.Date.IsZero() {
+		fmt.Fprintf(&b, "%s: %s\n", t("invoice.date", lang), inv.Date.Format("2006-01-02"))
+	}
+	for _, vin := range inv.VINs {
+		if vin = normalizeVIN(vin); vin != "" {
+			fmt.Fprintf(&b, "%s: %s\n", t("invoice.vin", lang), vin)
+		}
+	}
+
+	if len(rows) > 0 {
+		b.WriteString(rule + "\n")
+		b.WriteString(padRight(t("invoice.column_description", lang), fixedDescriptionWidth))
+		for i, header := range headers {
+			b.WriteString(fixedColumnGap + padLeft(header, widths[i]))
+		}
+		b.WriteString("\n" + rule + "\n")
+
+		for i, item := range inv.LineItems {
+			lines := wrapCells(plainCell(item.Description), fixedDescriptionWidth, fixedDescriptionLines)
+			b.WriteString(padRight(lines[0], fixedDescriptionWidth))
+			for j, value := range rows[i] {
+				b.WriteString(fixedColumnGap + padLeft(value, widths[j]))
+			}
+			b.WriteString("\n")
+			for _, line := range lines[1:] {
+				b.WriteString(strings.TrimRight(line, " ") + "\n")
+			}
+		}
+	}
+
+	if len(totals) > 0 {
+		b.WriteString(rule + "\n")
+		for _, total := range totals {
+			b.WriteString(padLeft(total.label, labelWidth) + fixedColumnGap + padLeft(total.value, widths[2]) + "\n")
+		}
+	}
+	b.WriteString("```")
+
+	if text := strings.TrimSpace(inv.OtherText); text != "" {
+		fmt.Fprintf(&b, "\n\n📝 **%s:**\n%s", t("invoice.other_text", lang), escapeMarkdown(text))
+	}
+	return b.String()
+}
+
+// plainCell makes text safe inside a code block, which a backtick would end,
+// and keeps it on one line
+func plainCell(text string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(text, "`", "'")), " ")
+}
+
+// cellWidth is how many monospace cells text takes up; CJK and fullwidth
+// characters take two
+func cellWidth(text string) int {
+	n := 0
+	for _, r := range text {
+		n += runeWidth(r)
+	}
+	return n
+}
+
+func runeWidth(r rune) int {
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+func padRight(text string, cells int) string {
+	return text + strings.Repeat(" ", max(cells-cellWidth(text), 0))
+}
+
+func padLeft(text string, cells int) string {
+	return strings.Repeat(" ", max(cells-cellWidth(text), 0)) + text
+}
+
+// wrapCells breaks text into lines of at most cells wide, between words where
+// it can. Past maxLines the last line is cut short and ends with "…". There's
+// always at least one line.
+func wrapCells(text string, cells, maxLines int) []string {
+	var lines []string
+	var line strings.Builder
+	lineWidth := 0
+	flush := func() {
+		lines = append(lines, line.String())
+		line.Reset()
+		lineWidth = 0
+	}
+
+	for _, word := range strings.Fields(text) {
+		wordWidth := cellWidth(word)
+		if lineWidth > 0 && lineWidth+1+wordWidth > cells {
+			flush()
+		}
+		if lineWidth > 0 {
+			line.WriteByte(' ')
+			lineWidth++
+		}
+		// Words wider than the column are split wherever they reach its edge
+		for _, r := range word {
+			if lineWidth+runeWidth(r) > cells {
+				flush()
+			}
+			line.WriteRune(r)
+			lineWidth += runeWidth(r)
+		}
+	}
+	if lineWidth > 0 || len(lines) == 0 {
+		flush()
+	}
+
+	if len(lines) <= maxLines {
+		return lines
+	}
+	lines = lines[:maxLines]
+	last := []rune(lines[maxLines-1])
+	for len(last) > 0 && cellWidth(string(last))+1 > cells {
+		last = last[:len(last)-1]
+	}
+	lines[maxLines-1] = strings.TrimRight(string(last), " ") + "…"
+	return lines
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// cellPrefix returns the start of line that fills the first cells monospace cells
+func cellPrefix(line string, cells int) string {
+	n := 0
+	for i, r := range line {
+		if n >= cells {
+			return line[:i]
+		}
+		n += runeWidth(r)
+	}
+	return line
+}
+
+// lastField is the last word of line's first cells cells, or "" if they end in a space
+func lastField(line string, cells int) string {
+	prefix := cellPrefix(line, cells)
+	if strings.HasSuffix(prefix, " ") {
+		return ""
+	}
+	fields := strings.Fields(prefix)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
+
+// fixedBlock returns the lines between the code fences
+func fixedBlock(t *testing.T, reply string) []string {
+	t.Helper()
+	start := strings.Index(reply, "```\n")
+	end := strings.LastIndex(reply, "```")
+	if start < 0 || end <= start {
+		t.Fatalf("no code block in %q", reply)
+	}
+	return strings.Split(strings.TrimSuffix(reply[start+4:end], "\n"), "\n")
+}
+
+func TestFixedWidthInvoiceAlignsColumns(t *testing.T) {
+	inv := &Invoice{
+		Vendor:        "ACME `Motors`",
+		InvoiceNumber: "INV-7",
+		Currency:      "KRW",
+		LineItems: []LineItem{
+			{Description: "Oil", Quantity: decimal("2"), UnitPrice: decimal("12.5"), Amount: decimal("25")},
+			{Description: "Front brake pads with wear sensors and fitting kit", Quantity: decimal("1"), UnitPrice: decimal("1250000"), Amount: decimal("1250000")},
+			{Description: "엔진오일 교환 및 필터", Quantity: decimal("10"), UnitPrice: decimal("3.75"), Amount: decimal("37.5")},
+			{Description: "Labour", Amount: decimal("80")},
+		},
+		Subtotal: decimal("1250142.5"),
+		Tax:      decimal("125014.25"),
+		Total:    decimal("1375156.75"),
+	}
+	lines := fixedBlock(t, fixedWidthInvoice(inv, "en"))
+
+	// The header row sets where each numeric column ends
+	var header, rule int
+	for i, line := range lines {
+		if strings.HasPrefix(line, "Description") {
+			header = i
+			break
+		}
+	}
+	if header == 0 {
+		t.Fatalf("no header row in %q", lines)
+	}
+	rule = header + 1
+	width := cellWidth(lines[rule])
+	columnEnds := map[string]int{}
+	for _, name := range []string{"Qty", "Price", "Amount"} {
+		columnEnds[name] = strings.Index(lines[header], name) + len(name)
+	}
+	if columnEnds["Amount"] != width {
+		t.Errorf("Amount header ends at %d, want the line width %d", columnEnds["Amount"], width)
+	}
+
+	// Each item's values end at their column's edge, whatever its description's width
+	rows := map[string][3]string{
+		"Oil":              {"2", "12.50", "25"},
+		"Front brake pads": {"1", "1250000", "1250000"},
+		"엔진오일":             {"10", "3.75", "37.50"},
+		"Labour":           {"", "", "80"},
+	}
+	for description, want := range rows {
+		var row string
+		for _, line := range lines[rule+1:] {
+			if strings.HasPrefix(line, description) {
+				row = line
+				break
+			}
+		}
+		if row == "" {
+			t.Errorf("no row for %q in %q", description, lines)
+			continue
+		}
+		if cellWidth(row) != width {
+			t.Errorf("row %q is %d cells wide, want %d", row, cellWidth(row), width)
+		}
+		for i, name := range []string{"Qty", "Price", "Amount"} {
+			if got := lastField(row, columnEnds[name]); got != want[i] {
+				t.Errorf("row %q: %s column has %q, want %q", description, name, got, want[i])
+			}
+		}
+	}
+
+	// Totals line up under the amounts, the last one labelled with the currency
+	totals := map[string]string{"Subtotal": "1250142.50", "Tax": "125014.25", "Total KRW": "1375156.75"}
+	for label, want := range totals {
+		var row string
+		for _, line := range lines {
+			if strings.HasSuffix(strings.TrimSpace(cellPrefix(line, columnEnds["Price"])), label) {
+				row = line
+			}
+		}
+		if row == "" {
+			t.Errorf("no %q line in %q", label, lines)
+			continue
+		}
+		if got := lastField(row, columnEnds["Amount"]); got != want || cellWidth(row) != width {
+			t.Errorf("%s line %q: amount %q at width %d, want %q at %d", label, row, got, cellWidth(row), want, width)
+		}
+	}
+
+	for _, line := range lines {
+		if cellWidth(line) > width {
+			t.Errorf("line %q is wider than the table (%d cells)", line, width)
+		}
+		if strings.Contains(line, "`") {
+			t.Errorf("line %q would end the code block", line)
+		}
+	}
+}
+
+func TestFixedWidthInvoiceWrapsLongDescriptions(t *testing.T) {
+	inv := &Invoice{LineItems: []LineItem{
+		{Description: "Front brake pads with wear sensors and fitting kit", Amount: decimal("90")},
+	}}
+	lines := fixedBlock(t, fixedWidthInvoice(inv, "en"))
+
+	var first int
+	for i, line := range lines {
+		if strings.HasPrefix(line, "Front") {
+			first = i
+		}
+	}
+	if first == 0 || first+1 >= len(lines) {
+		t.Fatalf("no wrapped row in %q", lines)
+	}
+	if got := strings.TrimSpace(cellPrefix(lines[first], fixedDescriptionWidth)); got != "Front brake pads" {
+		t.Errorf("first line %q, want the words that fit", got)
+	}
+	if got := lines[first+1]; got != "with wear sensors…" {
+		t.Errorf("continuation %q, want the second line cut short with an ellipsis", got)
+	}
+}
+
+func TestWrapCells(t *testing.T) {
+	tests := []struct {
+		text  string
+		cells int
+		lines int
+		want  []string
+	}{
+		{"", 10, 2, []string{""}},
+		{"short", 10, 2, []string{"short"}},
+		{"one two three", 7, 3, []string{"one two", "three"}},
+		{"abcdefghijkl", 5, 3, []string{"abcde", "fghij", "kl"}},
+		{"one two three four", 7, 2, []string{"one two", "three…"}},
+		// Hangul takes two cells a character
+		{"엔진오일 교환", 6, 3, []string{"엔진오", "일", "교환"}},
+		{"엔진오일 교환", 6, 2, []string{"엔진오", "일…"}},
+	}
+	for _, tt := range tests {
+		got := wrapCells(tt.text, tt.cells, tt.lines)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("wrapCells(%q, %d, %d) = %q, want %q", tt.text, tt.cells, tt.lines, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCommandSwitchesInvoiceLayout(t *testing.T) {
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(200, "{}"))
+	const chatID = 9750
+	ctx := withLanguage(context.Background(), "en")
+	inv := &Invoice{Vendor: "ACME", Total: decimal("5")}
+
+	handleCommand(ctx, textMessage(chatID, "/format fixed"))
+	if reply := formatInvoice(inv, getChatSettings(ctx, chatID), "en"); !strings.HasPrefix(reply, "```\n") {
+		t.Errorf("reply after /format fixed is %q, want a code block", reply)
+	}
+	handleCommand(ctx, textMessage(chatID, "/format default"))
+	if reply := formatInvoice(inv, getChatSettings(ctx, chatID), "en"); strings.Contains(reply, "```") {
+		t.Errorf("reply after /format default is %q, want the default layout", reply)
+	}
+
+	want := []string{englishText("format.fixed"), englishText("format.default")}
+	if sent := telegram.sent(); strings.Join(sent, "|") != strings.Join(want, "|") {
+		t.Errorf("sent %q, want %q", sent, want)
+	}
+}
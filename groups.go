@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// In groups, only read images when the bot is mentioned or replied to (GROUP_REQUIRE_MENTION).
+// Off by default, so existing group deployments keep reading every image.
+var groupRequireMention = false
+
+// The bot's own account, fetched with getMe at startup
+var (
+	botID       int64
+	botUsername string
+	botMention  *regexp.Regexp
+)
+
+// fetchBotInfo looks up the bot's ID and username with getMe
+func fetchBotInfo(ctx context.Context) error {
+	url := fmt.Sprintf("%s/bot%s/getMe", telegramAPIBase, telegramBotToken)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call getMe: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var response struct {
+		OK          bool         `json:"ok"`
+		Description string       `json:"description"`
+		Result      TelegramUser `json:"result"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to parse getMe response: %v", err)
+	}
+	if !response.OK {
+		return fmt.Errorf("telegram API error: %s", response.Description)
+	}
+
+	botID = response.Result.ID
+	botUsername = response.Result.Username
+	// Match @username as a whole word, ignoring case like Telegram does
+	botMention = regexp.MustCompile(`(?i)@` + regexp.QuoteMeta(botUsername) + `\b`)
+	return nil
+}
+
+func isGroupChat(chat TelegramChat) bool {
+	return chat.Type == "group" || chat.Type == "supergroup"
+}
+
+// isAddressedToBot reports whether a message should be read: always in private
+// chats, and in groups only if it mentions the bot or replies to one of its messages
+func isAddressedToBot(message TelegramMessage) bool {
+	if !groupRequireMention || !isGroupChat(message.Chat) {
+		return true
+	}
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From.ID == botID {
+		return true
+	}
+	return botMention.MatchString(message.Caption) || botMention.MatchString(message.Text)
+}
+
+// removeBotMention drops the @mention so captions like "@invoice_bot total" still match
+func removeBotMention(caption string) string {
+	if botMention == nil {
+		return caption
+	}
+	return strings.TrimSpace(botMention.ReplaceAllString(caption, ""))
+}
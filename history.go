@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// How many invoices /history lists, newest first
+const historyLimit = 10
+
+// Handle /history [vendor <name>] - the chat's latest stored invoices, optionally from one vendor
+func handleHistoryCommand(ctx context.Context, message TelegramMessage, args string) {
+	chatID := message.Chat.ID
+	lang := languageFrom(ctx)
+
+	if args == "" {
+		invoices := chatInvoices(chatID)
+		if len(invoices) == 0 {
+			sendTelegramMessage(ctx, chatID, message.MessageID, t("history.empty", lang))
+			return
+		}
+		title := t("history.title", lang, min(len(invoices), historyLimit), len(invoices))
+		sendTelegramMessage(ctx, chatID, message.MessageID, title+historyList(invoices, lang))
+		return
+	}
+
+	keyword, vendor, _ := strings.Cut(args, " ")
+	vendor = strings.TrimSpace(vendor)
+	if strings.ToLower(keyword) != "vendor" || vendor == "" {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("history.usage", lang))
+		return
+	}
+
+	invoices := vendorInvoices(chatID, vendor)
+	if len(invoices) == 0 {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("history.vendor_empty", lang, escapeMarkdown(vendor)))
+		return
+	}
+	// Named as on the latest invoice rather than as typed
+	name := escapeMarkdown(invoices[len(invoices)-1].Invoice.Vendor)
+	title := t("history.vendor_title", lang, name, min(len(invoices), historyLimit), len(invoices))
+	sendTelegramMessage(ctx, chatID, message.MessageID, title+historyList(invoices, lang))
+}
+
+// historyList renders the newest historyLimit invoices, one per line
+func historyList(invoices []StoredInvoice, lang string) string {
+	var b strings.Builder
+	for i := len(invoices) - 1; i >= 0 && i >= len(invoices)-historyLimit; i-- {
+		invoice := invoices[i].Invoice
+		fmt.Fprintf(&b, "\n• #%d", invoices[i].ID)
+		if !invoice.Date.IsZero() {
+			b.WriteString(" " + invoice.Date.Format("2006-01-02"))
+		}
+		vendor := t("history.no_vendor", lang)
+		if invoice.Vendor != "" {
+			vendor = escapeMarkdown(invoice.Vendor)
+		}
+		b.WriteString(" " + vendor)
+		if invoice.InvoiceNumber != "" {
+			b.WriteString(" (" + escapeMarkdown(invoice.InvoiceNumber) + ")")
+		}
+		if invoice.Total != nil {
+			b.WriteString(" — " + formatMoney(invoice.Total, invoice.Currency))
+		}
+	}
+	return b.String()
+}
+
+// Handle /vendors - the chat's vendors with how many invoices each sent and their totals
+func handleVendorsCommand(ctx context.Context, message TelegramMessage, args string) {
+	chatID := message.Chat.ID
+	lang := languageFrom(ctx)
+
+	vendors := chatVendors(chatID)
+	if len(vendors) == 0 {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("history.empty", lang))
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(t("vendors.title", lang, len(vendors)))
+	for _, vendor := range vendors {
+		total := "—"
+		if len(vendor.Currencies) > 0 {
+			var amounts []string
+			for _, currency := range vendor.Currencies {
+				amounts = append(amounts, formatMoney(vendor.Totals[currency], currency))
+			}
+			total = strings.Join(amounts, ", ")
+		}
+		b.WriteString("\n" + t("vendors.line", lang, escapeMarkdown(vendor.Name), vendor.Invoices, total))
+	}
+	sendTelegramMessage(ctx, chatID, message.MessageID, b.String())
+}
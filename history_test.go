@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChatVendorsGroupsNormalizedNames(t *testing.T) {
+	const chatID = 9760
+	saveInvoice(chatID, 1, &Invoice{Vendor: "ACME Motors", Currency: "USD", Total: decimal("10.50")}, "")
+	saveInvoice(chatID, 2, &Invoice{Vendor: "  acme   motors ", Currency: "USD", Total: decimal("4.50")}, "")
+	saveInvoice(chatID, 3, &Invoice{Vendor: "Acme Motors", Currency: "KRW", Total: decimal("15000")}, "")
+	saveInvoice(chatID, 4, &Invoice{Vendor: "Beta Parts", Currency: "USD"}, "")
+	saveInvoice(chatID, 5, &Invoice{InvoiceNumber: "NO-VENDOR", Total: decimal("1")}, "")
+
+	vendors := chatVendors(chatID)
+	if len(vendors) != 2 {
+		t.Fatalf("got %d vendors, want 2: %+v", len(vendors), vendors)
+	}
+	acme, beta := vendors[0], vendors[1]
+	if acme.Name != "Acme Motors" || acme.Invoices != 3 {
+		t.Errorf("first vendor %q with %d invoices, want Acme Motors, as last written, with 3", acme.Name, acme.Invoices)
+	}
+	if got := strings.Join(acme.Currencies, ","); got != "USD,KRW" {
+		t.Errorf("currencies %s, want USD,KRW", got)
+	}
+	if acme.Totals["USD"].String() != "15" || acme.Totals["KRW"].String() != "15000" {
+		t.Errorf("totals USD %s, KRW %s, want 15 and 15000", acme.Totals["USD"], acme.Totals["KRW"])
+	}
+	if beta.Name != "Beta Parts" || beta.Invoices != 1 || len(beta.Currencies) != 0 {
+		t.Errorf("second vendor %+v, want Beta Parts with one invoice and no total", beta)
+	}
+
+	if n := len(vendorInvoices(chatID, "ACME MOTORS")); n != 3 {
+		t.Errorf("%d invoices for ACME MOTORS, want 3", n)
+	}
+}
+
+func TestHistoryVendorAndVendorsCommands(t *testing.T) {
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(200, "{}"))
+	const chatID = 9761
+	ctx := withLanguage(context.Background(), "en")
+	saveInvoice(chatID, 1, &Invoice{Vendor: "Gamma Garage", InvoiceNumber: "G-1", Currency: "USD", Total: decimal("20")}, "")
+	saveInvoice(chatID, 2, &Invoice{Vendor: "Delta Tyres", InvoiceNumber: "D-1", Currency: "USD", Total: decimal("99")}, "")
+	saveInvoice(chatID, 3, &Invoice{Vendor: "gamma  garage", InvoiceNumber: "G-2", Currency: "USD", Total: decimal("30")}, "")
+
+	handleCommand(ctx, textMessage(chatID, "/history vendor GAMMA garage"))
+	handleCommand(ctx, textMessage(chatID, "/vendors"))
+	handleCommand(ctx, textMessage(chatID, "/history vendor Nobody"))
+	handleCommand(ctx, textMessage(chatID, "/history Gamma"))
+
+	sent := telegram.sent()
+	if len(sent) != 4 {
+		t.Fatalf("sent %d messages, want 4: %q", len(sent), sent)
+	}
+
+	history := sent[0]
+	if !strings.Contains(history, "G-1") || !strings.Contains(history, "G-2") || strings.Contains(history, "D-1") {
+		t.Errorf("/history vendor reply %q, want only Gamma Garage's invoices", history)
+	}
+	if strings.Index(history, "G-2") > strings.Index(history, "G-1") {
+		t.Errorf("/history vendor reply %q, want the newest first", history)
+	}
+
+	for _, want := range []string{"gamma garage — invoices: 2, total: 50 USD", "Delta Tyres — invoices: 1, total: 99 USD"} {
+		if !strings.Contains(sent[1], want) {
+			t.Errorf("/vendors reply %q doesn't contain %q", sent[1], want)
+		}
+	}
+	if strings.Index(sent[1], "gamma") > strings.Index(sent[1], "Delta") {
+		t.Errorf("/vendors reply %q, want the vendor with most invoices first", sent[1])
+	}
+
+	if want := fmt.Sprintf(englishText("history.vendor_empty"), "Nobody"); sent[2] != want {
+		t.Errorf("unknown vendor answered %q, want %q", sent[2], want)
+	}
+	if want := englishText("history.usage"); sent[3] != want {
+		t.Errorf("/history Gamma answered %q, want the usage", sent[3])
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Timeout for all outbound HTTP calls (HTTP_TIMEOUT_SECONDS)
+var httpTimeout = 30 * time.Second
+
+// Shared client for all outbound Telegram and OpenAI calls
+var httpClient = newHTTPClient(httpTimeout)
+
+// newHTTPClient returns a client whose requests, dials and TLS handshakes can't hang forever
+func newHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+		},
+	}
+}
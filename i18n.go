@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// Languages the bot can reply in, by ISO 639-1 code. English is complete;
+// keys missing from another catalog fall back to it.
+var messageCatalogs = map[string]map[string]string{
+	"en": englishMessages,
+	"ko": koreanMessages,
+}
+
+const defaultLanguage = "en"
+
+// t returns the message for key in lang, formatted with args if any
+func t(key, lang string, args ...any) string {
+	text, ok := messageCatalogs[lang][key]
+	if !ok {
+		text, ok = englishMessages[key]
+	}
+	if !ok {
+		slog.Error("Missing message", "key", key, "language", lang)
+		return key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// userLanguage maps a Telegram language_code like "ko" or "pt-br" to a catalog, or English
+func userLanguage(languageCode string) string {
+	base, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+	if _, ok := messageCatalogs[base]; ok {
+		return base
+	}
+	return defaultLanguage
+}
+
+// replyLanguage picks the language for replies in a chat: the chat's
+// /lang reply setting, otherwise the language of the user's Telegram app
+func replyLanguage(ctx context.Context, chatID int64, languageCode string) string {
+	if lang := getChatSettings(ctx, chatID).ReplyLanguage; lang != "" {
+		return lang
+	}
+	return userLanguage(languageCode)
+}
+
+// messageLanguage is the reply language for a message
+func messageLanguage(ctx context.Context, message TelegramMessage) string {
+	return replyLanguage(ctx, message.Chat.ID, message.From.LanguageCode)
+}
+
+// replyLanguageCodes lists the /lang reply codes in a stable order
+func replyLanguageCodes() string {
+	codes := make([]string, 0, len(messageCatalogs))
+	for code := range messageCatalogs {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return strings.Join(codes, ", ")
+}
+
+type languageKey struct{}
+
+// withLanguage returns a context carrying the reply language
+func withLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey{}, lang)
+}
+
+// languageFrom returns the reply language stored in ctx, or English
+func languageFrom(ctx context.Context) string {
+	if lang, ok := ctx.Value(languageKey{}).(string); ok {
+		return lang
+	}
+	return defaultLanguage
+}
+
+var englishMessages = map[string]string{
+	"language.name": "English",
+
+	"help": `👋 I read invoices and receipts.
+
+Send me a photo of an invoice or receipt and I'll reply with the vendor, date, line items and totals. Several photos sent as an album get one combined reply. Word (.docx) and Excel (.xlsx) invoices work too, and so do ZIP archives of invoices and links to invoice images.
+
+Commands:
+/total - caption a photo with /total (or reply /total to a photo) to get only the grand total
+Caption a photo with e.g. "invoice number and date" to get only those fields
+/nocache - add to a caption to read the file again instead of reusing an earlier result
+/lang <code> - tell me the document language (e.g. /lang ko), /lang auto to reset
+/lang reply <code> - choose the language I reply in (%s), /lang reply auto to follow your Telegram app
+/retry - run the last image again with a stronger model
+/export - get this chat's extracted invoices as a CSV file
+/history - list this chat's latest invoices, /history vendor <name> for one vendor's
+/vendors - list this chat's vendors with their invoice counts and totals
+/pdf - get the last extracted invoice as a PDF summary
+/format fixed|default - show invoices as aligned plain-text columns for copying, or in the default layout
+/stats - show how many images this chat processed and the estimated cost
+/cancel - stop waiting for a corrected total after pressing "Fix total"
+/safemode on|off - chat admins can stop images from being sent to OpenAI
+/help - show this message`,
+	"text.unknown_command": "Sorry, I don't know that command. Send /help to see what I can do.",
+	"text.hint":            "Send me a photo of an invoice or receipt and I'll extract its details. Send /help for more.",
+	"media.unsupported":    "Sorry, I can only read images and documents right now: photos, JPEG, PNG, HEIC and WebP files, and Word (.docx) or Excel (.xlsx) invoices. I can't process voice, audio or video messages.",
+
+	"unauthorized":     "Sorry, you're not authorized to use this bot.",
+	"overloaded":       "I'm overloaded right now, please try again shortly.",
+	"unexpected_error": "Sorry, something went wrong while processing your message. Please try again.",
+	"timeout":          "Sorry, this took too long to process. Please try again.",
+	"reference":        "Reference: `%s`",
+	"settings.failed":  "Sorry, I couldn't save that setting. Please try again.",
+	"ocr_disabled":     "🔒 Image OCR is disabled by policy in this chat.",
+
+	"lang.status":          "🌐 Document language: %s.\nReply language: %s.\nSet them with /lang <code> (%s) or /lang reply <code> (%s), or reset with /lang auto and /lang reply auto.",
+	"lang.auto":            "auto-detect",
+	"lang.reply_auto":      "your Telegram app's language",
+	"lang.document_auto":   "🌐 Document language set to auto-detect.",
+	"lang.document_set":    "🌐 Document language set to %s.",
+	"lang.unknown":         "Sorry, I don't know the language code \"%s\". Supported codes: %s.",
+	"lang.reply_follow":    "🌐 I'll reply in the language of your Telegram app.",
+	"lang.reply_set":       "🌐 I'll reply in English.",
+	"safemode.on":          "🔒 Safe mode is on for this chat. Admins can change it with /safemode on or /safemode off.",
+	"safemode.off":         "🔒 Safe mode is off for this chat. Admins can change it with /safemode on or /safemode off.",
+	"safemode.usage":       "Usage: /safemode on or /safemode off",
+	"safemode.check_error": "Sorry, I couldn't verify your permissions. Please try again.",
+	"safemode.admins_only": "Only chat admins can change safe mode.",
+	"safemode.enabled":     "🔒 Safe mode enabled. Images will no longer be sent to OpenAI.",
+	"safemode.disabled":    "🔓 Safe mode disabled. Images will be processed with OpenAI again.",
+
+	"format.status_default": "📄 Invoices are shown in the default layout. Send /format fixed for aligned plain-text columns.",
+	"format.status_fixed":   "📄 Invoices are shown as aligned plain-text columns. Send /format default to go back.",
+	"format.fixed":          "📄 Invoices will be shown as aligned plain-text columns you can copy into other systems.",
+	"format.default":        "📄 Invoices will be shown in the default layout.",
+	"format.usage":          "Usage: /format fixed or /format default",
+
+	"button.confirm":            "✅ Looks good",
+	"button.fix_total":          "✏️ Fix total",
+	"button.rescan":             "🔁 Re-scan",
+	"callback.too_old":          "This message is too old.",
+	"callback.unavailable":      "This invoice is no longer available.",
+	"callback.verified":         "✅ Marked as verified",
+	"callback.send_total":       "✏️ Send the correct total within %d minutes, for example 1,234.50 USD, or /cancel.",
+	"callback.image_expired":    "That image is no longer cached. Please send it again.",
+	"callback.ocr_disabled":     "Image OCR is disabled by policy in this chat.",
+	"callback.rescanning":       "🔁 Re-scanning with %s",
+	"correction.invalid_amount": "Sorry, I couldn't read that amount. Please send it like 1,234.50 USD.",
+	"correction.unavailable":    "Sorry, that invoice is no longer available.",
+	"correction.updated":        "✏️ **Total updated.**",
+	"cancel.done":               "OK, I won't change the total.",
+	"cancel.nothing":            "There's nothing to cancel.",
+
+	"total.usage":                  "Send a photo with /total as its caption, or reply to a photo with /total.",
+	"total.reply":                  "💰 **Total:** %s",
+	"total.failed":                 "Sorry, I couldn't read the total from this image. Please try with a clearer image.",
+	"image.reply":                  "🔍 **Extracted text from image%s:**\n\n%s",
+	"image.failed":                 "Sorry, I couldn't extract any text from this image. Please try with a clearer image.",
+	"image.no_text":                "I couldn't find any readable text in this image. Please try a clearer photo.",
+	"truncated":                    "⚠️ This document was too long and the result above is cut off.",
+	"image.too_long":               "Sorry, this invoice is too long for me to read in one go. Please send it in parts, e.g. one photo per page.",
+	"moderation.failed":            "Sorry, I couldn't process this image right now. Please try again.",
+	"moderation.refused":           "Sorry, I can't process this image.",
+	"classify.not_invoice":         "This doesn't look like an invoice. Send me a receipt or invoice.",
+	"usage.tokens":                 "_(used %d tokens)_",
+	"duplicate.warning":            "⚠️ Looks like a duplicate of an invoice you sent on %s.",
+	"line_items.subtotal_mismatch": "⚠️ The line items add up to %s, but the subtotal reads %s. Please check the amounts against the original.",
+	"line_items.total_mismatch":    "⚠️ The line items add up to %s, but the total reads %s. Please check the amounts against the original.",
+	"dry_run.header":               "🧪 **Dry run** - OpenAI was not called.",
+	"dry_run.placeholder":          "Extracted text would appear here.",
+	"dry_run.document":             "Read %d characters of text from the file.",
+
+	"invoice.empty":      "I couldn't find any invoice details or readable text in this image. Please try with a clearer image.",
+	"invoice.title":      "🧾 **Invoice details:**",
+	"invoice.vendor":     "Vendor",
+	"invoice.number":     "Invoice #",
+	"invoice.date":       "Date",
+	"invoice.line_items": "Line items",
+	"invoice.subtotal":   "Subtotal",
+	"invoice.tax":        "Tax",
+	"invoice.total":      "Total",
+	"invoice.other_text": "Other text",
+	"invoice.vin":        "VIN",
+
+	"invoice.column_description": "Description",
+	"invoice.column_qty":         "Qty",
+	"invoice.column_price":       "Price",
+	"invoice.column_amount":      "Amount",
+	"vin.valid":                  "✅ valid checksum",
+	"vin.invalid":                "⚠️ checksum doesn't match, may be misread",
+
+	"album.title":           "📚 **Extracted from %d images:**",
+	"album.image":           "**Image %d:**",
+	"album.download_failed": "Sorry, I couldn't download this image.",
+	"album.process_failed":  "Sorry, I couldn't process this image right now.",
+	"album.extract_failed":  "Sorry, I couldn't extract any text from this image.",
+
+	"download.image_failed":      "Sorry, I couldn't download the image. Please try again.",
+	"download.file_failed":       "Sorry, I couldn't download the file. Please try again.",
+	"download.unavailable":       "Sorry, Telegram no longer has this file. Please send it again.",
+	"file.too_large":             "Sorry, this file is too large (%.1f MB). The maximum size is %.1f MB.",
+	"file.telegram_too_large":    "This file is too large for me to download from Telegram (max 20MB).",
+	"document.legacy_office":     "Sorry, I can't read old .doc or .xls files. Please save it as .docx or .xlsx, or send a photo of the invoice.",
+	"document.unsupported":       "Sorry, I can't read this file type. Please send a JPEG, PNG, HEIC or WebP image, a Word (.docx) or Excel (.xlsx) file, or a ZIP of them.",
+	"document.unreadable_image":  "Sorry, I couldn't read this image. Please send it as a JPEG or PNG.",
+	"document.extraction_off":    "🔒 Document extraction is disabled by policy in this chat.",
+	"document.no_text":           "I couldn't find any text in this file. If the invoice is a picture inside the document, please send it as a photo.",
+	"document.unreadable":        "Sorry, I couldn't read this file. Please check it opens correctly, or send a photo of the invoice.",
+	"document.extraction_failed": "Sorry, I couldn't extract the invoice from this file. Please try again.",
+
+	"zip.title":            "📦 **%d file(s) from the archive:**",
+	"zip.file":             "**%d. %s**",
+	"zip.totals":           "**Totals:** %s",
+	"zip.skipped":          "**Skipped:**",
+	"zip.skip_unsupported": "%s - not an image, Word or Excel file",
+	"zip.skip_unreadable":  "%s - couldn't be unpacked (damaged or password-protected)",
+	"zip.skip_limit":       "%d more file(s) - I only read the first %d files of an archive",
+	"zip.pdf":              "Sorry, I can't read PDFs yet. Please send the invoice as an image.",
+	"zip.empty":            "I couldn't find any images or Word/Excel files in this archive.",
+	"zip.invalid":          "Sorry, I couldn't open this ZIP file. Please check it isn't damaged.",
+	"zip.too_large":        "Sorry, this archive unpacks to more than %d MB. Please send fewer files at a time.",
+
+	"url.unreadable_image": "Sorry, I couldn't read the image at this link. Please send it as a JPEG or PNG.",
+	"url.blocked":          "Sorry, I can't open links to private or local addresses.",
+	"url.too_large":        "Sorry, the file at this link is too large. The maximum size is %.1f MB.",
+	"url.pdf":              "Sorry, I can't read PDF files yet. Please send a photo or screenshot of the invoice.",
+	"url.not_image":        "Sorry, this link doesn't point to an image. Please send a direct link to a JPEG, PNG, HEIC or WebP image, or upload the invoice.",
+	"url.unreachable":      "Sorry, I couldn't open this link. Please check it works, or upload the invoice instead.",
+
+	"openai.config":       "Sorry, the bot can't reach OpenAI right now because of a configuration problem. Please let the bot owner know.",
+	"openai.quota":        "Sorry, the bot has run out of OpenAI credit for now. Please let the bot owner know.",
+	"openai.rate_limited": "I'm getting too many requests right now. Please try again in a minute.",
+
+	"retry.nothing":  "There's nothing to retry. I keep your last image for %d minutes, so please send it again.",
+	"retry.retrying": "🔁 Retrying with %s...",
+
+	"export.empty":       "There are no invoices to export yet. Send me a photo of an invoice or receipt first.",
+	"export.failed":      "Sorry, I couldn't create the export.",
+	"export.send_failed": "Sorry, I couldn't send the export.",
+	"export.caption":     "%d invoice(s)",
+	"pdf.empty":          "There's no invoice to turn into a PDF yet. Send me a photo of an invoice or receipt first.",
+	"pdf.failed":         "Sorry, I couldn't create the PDF.",
+	"pdf.send_failed":    "Sorry, I couldn't send the PDF.",
+
+	"history.empty":        "There are no invoices in this chat yet. Send me a photo of an invoice or receipt first.",
+	"history.title":        "🗂 **Latest invoices** (%d of %d):",
+	"history.vendor_title": "🗂 **Invoices from %s** (%d of %d):",
+	"history.vendor_empty": "There are no invoices from %s in this chat. Send /vendors to see the vendors I know.",
+	"history.no_vendor":    "(no vendor)",
+	"history.usage":        "Usage: /history, or /history vendor <name>",
+	"vendors.title":        "🏷 **Vendors** (%d):",
+	"vendors.line":         "• %s — invoices: %d, total: %s",
+
+	"stats.chat_title":   "📊 **Usage in this chat**",
+	"stats.all_title":    "📊 **Usage across all chats**",
+	"stats.admins_only":  "Only bot admins can see stats for all chats.",
+	"stats.today":        "**Today:** %d extraction(s), %d tokens, ~$%.2f",
+	"stats.month":        "**This month:** %d extraction(s), %d tokens, ~$%.2f",
+	"stats.active_chats": "**Active chats this month:** %d",
+	"stats.note":         "_Costs are estimates and reset when the bot restarts._",
+
+	"webhook.admins_only":    "Only bot admins can set the webhook.",
+	"webhook.polling":        "The bot is running in polling mode, so there's no webhook to set.",
+	"webhook.not_configured": "WEBHOOK_URL isn't set, so I don't know which URL to register.",
+	"webhook.failed":         "Sorry, Telegram didn't accept the webhook.",
+	"webhook.set":            "✅ Webhook set to %s",
+	"webhook.pending":        "Pending updates: %d",
+	"webhook.last_error":     "Last delivery error: %s",
+
+	"inline.title":       "Inline mode isn't supported yet",
+	"inline.description": "Send invoice photos to the bot in a private chat instead.",
+	"inline.message":     "I read invoices from photos sent directly to me. Open a chat with me and send a photo of an invoice or receipt.",
+	"inline.button":      "Open a chat with the bot",
+}
+
+var koreanMessages = map[string]string{
+	"language.name": "한국어",
+
+	"help": `👋 청구서와 영수증을 읽어 드립니다.
+
+청구서나 영수증 사진을 보내 주시면 공급업체, 날짜, 품목, 합계를 알려 드립니다. 앨범으로 보낸 여러 장의 사진은 한 번에 답장합니다. Word(.docx)와 Excel(.xlsx) 청구서, 청구서를 묶은 ZIP 파일, 청구서 이미지 링크도 읽을 수 있습니다.
+
+명령어:
+/total - 사진 캡션에 /total을 쓰거나 사진에 /total로 답장하면 합계만 알려 드립니다
+사진 캡션에 "invoice number and date"처럼 항목 이름을 영어로 쓰면 해당 항목만 알려 드립니다
+/nocache - 캡션에 함께 쓰면 이전 결과를 재사용하지 않고 파일을 다시 읽습니다
+/lang <코드> - 문서 언어를 지정합니다 (예: /lang ko), /lang auto로 초기화
+/lang reply <코드> - 답장 언어를 선택합니다 (%s), /lang reply auto로 텔레그램 앱 언어를 따릅니다
+/retry - 마지막 이미지를 더 강력한 모델로 다시 읽습니다
+/export - 이 채팅에서 추출한 청구서를 CSV 파일로 받습니다
+/history - 이 채팅의 최근 청구서를 보여 줍니다, /history vendor <이름>으로 공급업체별로 볼 수 있습니다
+/vendors - 이 채팅의 공급업체별 청구서 수와 합계를 보여 줍니다
+/pdf - 마지막으로 추출한 청구서를 PDF 요약으로 받습니다
+/format fixed|default - 청구서를 복사하기 쉬운 정렬된 일반 텍스트 표 또는 기본 형식으로 보여 줍니다
+/stats - 이 채팅에서 처리한 이미지 수와 예상 비용을 보여 줍니다
+/cancel - "합계 수정"을 누른 뒤 합계 입력을 취소합니다
+/safemode on|off - 채팅 관리자는 이미지가 OpenAI로 전송되지 않도록 할 수 있습니다
+/help - 이 메시지를 보여 줍니다`,
+	"text.unknown_command": "죄송합니다. 알 수 없는 명령어입니다. /help를 보내 사용 가능한 기능을 확인하세요.",
+	"text.hint":            "청구서나 영수증 사진을 보내 주시면 내용을 추출해 드립니다. 자세한 내용은 /help를 보내 주세요.",
+	"media.unsupported":    "죄송합니다. 현재는 이미지와 문서만 읽을 수 있습니다: 사진, JPEG, PNG, HEIC, WebP 파일, Word(.docx) 또는 Excel(.xlsx) 청구서. 음성, 오디오, 동영상 메시지는 처리할 수 없습니다.",
+
+	"unauthorized":     "죄송합니다. 이 봇을 사용할 권한이 없습니다.",
+	"overloaded":       "지금은 요청이 너무 많습니다. 잠시 후 다시 시도해 주세요.",
+	"unexpected_error": "죄송합니다. 메시지를 처리하는 중 문제가 발생했습니다. 다시 시도해 주세요.",
+	"timeout":          "죄송합니다. 처리 시간이 너무 오래 걸렸습니다. 다시 시도해 주세요.",
+	"reference":        "참조 번호: `%s`",
+	"settings.failed":  "죄송합니다. 설정을 저장하지 못했습니다. 다시 시도해 주세요.",
+	"ocr_disabled":     "🔒 이 채팅에서는 정책에 따라 이미지 OCR이 비활성화되어 있습니다.",
+
+	"lang.status":          "🌐 문서 언어: %s.\n답장 언어: %s.\n/lang <코드> (%s) 또는 /lang reply <코드> (%s)로 설정하고, /lang auto와 /lang reply auto로 초기화할 수 있습니다.",
+	"lang.auto":            "자동 감지",
+	"lang.reply_auto":      "텔레그램 앱 언어",
+	"lang.document_auto":   "🌐 문서 언어를 자동 감지로 설정했습니다.",
+	"lang.document_set":    "🌐 문서 언어를 %s(으)로 설정했습니다.",
+	"lang.unknown":         "죄송합니다. \"%s\"은(는) 알 수 없는 언어 코드입니다. 지원되는 코드: %s.",
+	"lang.reply_follow":    "🌐 텔레그램 앱 언어로 답장하겠습니다.",
+	"lang.reply_set":       "🌐 이제 한국어로 답장하겠습니다.",
+	"safemode.on":          "🔒 이 채팅에서 안전 모드가 켜져 있습니다. 관리자는 /safemode on 또는 /safemode off로 변경할 수 있습니다.",
+	"safemode.off":         "🔒 이 채팅에서 안전 모드가 꺼져 있습니다. 관리자는 /safemode on 또는 /safemode off로 변경할 수 있습니다.",
+	"safemode.usage":       "사용법: /safemode on 또는 /safemode off",
+	"safemode.check_error": "죄송합니다. 권한을 확인하지 못했습니다. 다시 시도해 주세요.",
+	"safemode.admins_only": "채팅 관리자만 안전 모드를 변경할 수 있습니다.",
+	"safemode.enabled":     "🔒 안전 모드를 켰습니다. 이제 이미지가 OpenAI로 전송되지 않습니다.",
+	"safemode.disabled":    "🔓 안전 모드를 껐습니다. 이미지를 다시 OpenAI로 처리합니다.",
+
+	"format.status_default": "📄 청구서를 기본 형식으로 보여 드립니다. /format fixed를 보내면 정렬된 일반 텍스트 표로 바뀝니다.",
+	"format.status_fixed":   "📄 청구서를 정렬된 일반 텍스트 표로 보여 드립니다. /format default를 보내면 기본 형식으로 돌아갑니다.",
+	"format.fixed":          "📄 이제 청구서를 다른 시스템에 붙여 넣을 수 있는 정렬된 일반 텍스트 표로 보여 드립니다.",
+	"format.default":        "📄 이제 청구서를 기본 형식으로 보여 드립니다.",
+	"format.usage":          "사용법: /format fixed 또는 /format default",
+
+	"button.confirm":            "✅ 확인",
+	"button.fix_total":          "✏️ 합계 수정",
+	"button.rescan":             "🔁 다시 읽기",
+	"callback.too_old":          "너무 오래된 메시지입니다.",
+	"callback.unavailable":      "이 청구서는 더 이상 사용할 수 없습니다.",
+	"callback.verified":         "✅ 확인 완료로 표시했습니다",
+	"callback.send_total":       "✏️ %d분 안에 올바른 합계를 보내 주세요. 예: 1,234.50 USD (취소: /cancel)",
+	"callback.image_expired":    "이 이미지는 더 이상 저장되어 있지 않습니다. 다시 보내 주세요.",
+	"callback.ocr_disabled":     "이 채팅에서는 정책에 따라 이미지 OCR이 비활성화되어 있습니다.",
+	"callback.rescanning":       "🔁 %s(으)로 다시 읽는 중",
+	"correction.invalid_amount": "죄송합니다. 금액을 읽지 못했습니다. 1,234.50 USD처럼 보내 주세요.",
+	"correction.unavailable":    "죄송합니다. 이 청구서는 더 이상 사용할 수 없습니다.",
+	"correction.updated":        "✏️ **합계를 수정했습니다.**",
+	"cancel.done":               "합계 수정을 취소했습니다.",
+	"cancel.nothing":            "취소할 작업이 없습니다.",
+
+	"total.usage":                  "캡션에 /total을 쓴 사진을 보내거나, 사진에 /total로 답장해 주세요.",
+	"total.reply":                  "💰 **합계:** %s",
+	"total.failed":                 "죄송합니다. 이 이미지에서 합계를 읽지 못했습니다. 더 선명한 이미지로 다시 시도해 주세요.",
+	"image.reply":                  "🔍 **이미지%s에서 추출한 텍스트:**\n\n%s",
+	"image.failed":                 "죄송합니다. 이 이미지에서 텍스트를 추출하지 못했습니다. 더 선명한 이미지로 다시 시도해 주세요.",
+	"image.no_text":                "이 이미지에서 읽을 수 있는 텍스트를 찾지 못했습니다. 더 선명한 사진으로 다시 시도해 주세요.",
+	"image.too_long":               "죄송합니다. 이 청구서는 너무 길어서 한 번에 읽을 수 없습니다. 페이지마다 사진 한 장씩 나누어 보내 주세요.",
+	"truncated":                    "⚠️ 문서가 너무 길어서 위 결과가 중간에 잘렸습니다.",
+	"moderation.failed":            "죄송합니다. 지금은 이 이미지를 처리할 수 없습니다. 다시 시도해 주세요.",
+	"moderation.refused":           "죄송합니다. 이 이미지는 처리할 수 없습니다.",
+	"classify.not_invoice":         "청구서가 아닌 것 같습니다. 영수증이나 청구서를 보내 주세요.",
+	"usage.tokens":                 "_(토큰 %d개 사용)_",
+	"duplicate.warning":            "⚠️ %s에 보내신 청구서와 중복된 것 같습니다.",
+	"line_items.subtotal_mismatch": "⚠️ 품목 금액의 합은 %s인데 소계는 %s로 읽혔습니다. 원본과 금액을 확인해 주세요.",
+	"line_items.total_mismatch":    "⚠️ 품목 금액의 합은 %s인데 합계는 %s로 읽혔습니다. 원본과 금액을 확인해 주세요.",
+	"dry_run.header":               "🧪 **테스트 실행** - OpenAI를 호출하지 않았습니다.",
+	"dry_run.placeholder":          "추출한 텍스트가 여기에 표시됩니다.",
+	"dry_run.document":             "파일에서 %d자의 텍스트를 읽었습니다.",
+
+	"invoice.empty":      "이 이미지에서 청구서 정보나 읽을 수 있는 텍스트를 찾지 못했습니다. 더 선명한 이미지로 다시 시도해 주세요.",
+	"invoice.title":      "🧾 **청구서 정보:**",
+	"invoice.vendor":     "공급업체",
+	"invoice.number":     "청구서 번호",
+	"invoice.date":       "날짜",
+	"invoice.line_items": "품목",
+	"invoice.subtotal":   "소계",
+	"invoice.tax":        "세금",
+	"invoice.total":      "합계",
+	"invoice.other_text": "기타 텍스트",
+	"invoice.vin":        "차대번호(VIN)",
+
+	"invoice.column_description": "품목",
+	"invoice.column_qty":         "수량",
+	"invoice.column_price":       "단가",
+	"invoice.column_amount":      "금액",
+	"vin.valid":                  "✅ 체크섬 일치",
+	"vin.invalid":                "⚠️ 체크섬 불일치, 잘못 읽었을 수 있음",
+
+	"album.title":           "📚 **이미지 %d장에서 추출한 내용:**",
+	"album.image":           "**이미지 %d:**",
+	"album.download_failed": "죄송합니다. 이 이미지를 다운로드하지 못했습니다.",
+	"album.process_failed":  "죄송합니다. 지금은 이 이미지를 처리할 수 없습니다.",
+	"album.extract_failed":  "죄송합니다. 이 이미지에서 텍스트를 추출하지 못했습니다.",
+
+	"download.image_failed":      "죄송합니다. 이미지를 다운로드하지 못했습니다. 다시 시도해 주세요.",
+	"download.file_failed":       "죄송합니다. 파일을 다운로드하지 못했습니다. 다시 시도해 주세요.",
+	"download.unavailable":       "죄송합니다. 텔레그램에 이 파일이 더 이상 없습니다. 다시 보내 주세요.",
+	"file.too_large":             "죄송합니다. 파일이 너무 큽니다(%.1f MB). 최대 크기는 %.1f MB입니다.",
+	"file.telegram_too_large":    "파일이 너무 커서 텔레그램에서 다운로드할 수 없습니다(최대 20MB).",
+	"document.legacy_office":     "죄송합니다. 오래된 .doc 또는 .xls 파일은 읽을 수 없습니다. .docx 또는 .xlsx로 저장하거나 청구서 사진을 보내 주세요.",
+	"document.unsupported":       "죄송합니다. 이 파일 형식은 읽을 수 없습니다. JPEG, PNG, HEIC, WebP 이미지나 Word(.docx), Excel(.xlsx) 파일, 또는 이들을 묶은 ZIP 파일을 보내 주세요.",
+	"document.unreadable_image":  "죄송합니다. 이 이미지를 읽지 못했습니다. JPEG 또는 PNG로 보내 주세요.",
+	"document.extraction_off":    "🔒 이 채팅에서는 정책에 따라 문서 추출이 비활성화되어 있습니다.",
+	"document.no_text":           "이 파일에서 텍스트를 찾지 못했습니다. 청구서가 문서 안의 그림이라면 사진으로 보내 주세요.",
+	"document.unreadable":        "죄송합니다. 이 파일을 읽지 못했습니다. 파일이 제대로 열리는지 확인하거나 청구서 사진을 보내 주세요.",
+	"document.extraction_failed": "죄송합니다. 이 파일에서 청구서를 추출하지 못했습니다. 다시 시도해 주세요.",
+
+	"zip.title":            "📦 **압축 파일의 파일 %d개:**",
+	"zip.file":             "**%d. %s**",
+	"zip.totals":           "**합계:** %s",
+	"zip.skipped":          "**건너뜀:**",
+	"zip.skip_unsupported": "%s - 이미지, Word 또는 Excel 파일이 아님",
+	"zip.skip_unreadable":  "%s - 압축을 풀 수 없음(손상되었거나 암호로 보호됨)",
+	"zip.skip_limit":       "그 외 파일 %d개 - 압축 파일당 처음 %d개의 파일만 읽습니다",
+	"zip.pdf":              "죄송합니다. 아직 PDF는 읽을 수 없습니다. 청구서를 이미지로 보내 주세요.",
+	"zip.empty":            "이 압축 파일에서 이미지나 Word/Excel 파일을 찾지 못했습니다.",
+	"zip.invalid":          "죄송합니다. 이 ZIP 파일을 열지 못했습니다. 파일이 손상되지 않았는지 확인해 주세요.",
+	"zip.too_large":        "죄송합니다. 이 압축 파일은 풀었을 때 %dMB를 넘습니다. 파일을 나누어 보내 주세요.",
+
+	"url.unreadable_image": "죄송합니다. 이 링크의 이미지를 읽지 못했습니다. JPEG 또는 PNG로 보내 주세요.",
+	"url.blocked":          "죄송합니다. 사설 또는 로컬 주소로 연결되는 링크는 열 수 없습니다.",
+	"url.too_large":        "죄송합니다. 이 링크의 파일이 너무 큽니다. 최대 크기는 %.1f MB입니다.",
+	"url.pdf":              "죄송합니다. 아직 PDF 파일은 읽을 수 없습니다. 청구서 사진이나 스크린샷을 보내 주세요.",
+	"url.not_image":        "죄송합니다. 이 링크는 이미지가 아닙니다. JPEG, PNG, HEIC, WebP 이미지로 바로 연결되는 링크를 보내거나 청구서를 업로드해 주세요.",
+	"url.unreachable":      "죄송합니다. 이 링크를 열지 못했습니다. 링크가 올바른지 확인하거나 청구서를 업로드해 주세요.",
+
+	"openai.config":       "죄송합니다. 설정 문제로 지금은 OpenAI에 연결할 수 없습니다. 봇 관리자에게 알려 주세요.",
+	"openai.quota":        "죄송합니다. 봇의 OpenAI 크레딧이 소진되었습니다. 봇 관리자에게 알려 주세요.",
+	"openai.rate_limited": "지금은 요청이 너무 많습니다. 1분 후에 다시 시도해 주세요.",
+
+	"retry.nothing":  "다시 시도할 이미지가 없습니다. 마지막 이미지는 %d분 동안만 보관하므로 다시 보내 주세요.",
+	"retry.retrying": "🔁 %s(으)로 다시 시도하는 중...",
+
+	"export.empty":       "아직 내보낼 청구서가 없습니다. 먼저 청구서나 영수증 사진을 보내 주세요.",
+	"export.failed":      "죄송합니다. 내보내기 파일을 만들지 못했습니다.",
+	"export.send_failed": "죄송합니다. 내보내기 파일을 보내지 못했습니다.",
+	"export.caption":     "청구서 %d건",
+	"pdf.empty":          "아직 PDF로 만들 청구서가 없습니다. 먼저 청구서나 영수증 사진을 보내 주세요.",
+	"pdf.failed":         "죄송합니다. PDF를 만들지 못했습니다.",
+	"pdf.send_failed":    "죄송합니다. PDF를 보내지 못했습니다.",
+
+	"history.empty":        "아직 이 채팅에 청구서가 없습니다. 먼저 청구서나 영수증 사진을 보내 주세요.",
+	"history.title":        "🗂 **최근 청구서** (%d/%d건):",
+	"history.vendor_title": "🗂 **%s 청구서** (%d/%d건):",
+	"history.vendor_empty": "이 채팅에 %s의 청구서가 없습니다. /vendors로 공급업체 목록을 볼 수 있습니다.",
+	"history.no_vendor":    "(공급업체 없음)",
+	"history.usage":        "사용법: /history 또는 /history vendor <이름>",
+	"vendors.title":        "🏷 **공급업체** (%d곳):",
+	"vendors.line":         "• %s — 청구서 %d건, 합계 %s",
+
+	"stats.chat_title":   "📊 **이 채팅의 사용량**",
+	"stats.all_title":    "📊 **전체 채팅 사용량**",
+	"stats.admins_only":  "봇 관리자만 전체 채팅의 통계를 볼 수 있습니다.",
+	"stats.today":        "**오늘:** 추출 %d건, 토큰 %d개, 약 $%.2f",
+	"stats.month":        "**이번 달:** 추출 %d건, 토큰 %d개, 약 $%.2f",
+	"stats.active_chats": "**이번 달 활성 채팅:** %d",
+	"stats.note":         "_비용은 추정치이며 봇이 다시 시작되면 초기화됩니다._",
+
+	"webhook.admins_only":    "봇 관리자만 웹훅을 설정할 수 있습니다.",
+	"webhook.polling":        "봇이 폴링 모드로 실행 중이므로 설정할 웹훅이 없습니다.",
+	"webhook.not_configured": "WEBHOOK_URL이 설정되지 않아 등록할 URL을 알 수 없습니다.",
+	"webhook.failed":         "죄송합니다. Telegram이 웹훅을 받아들이지 않았습니다.",
+	"webhook.set":            "✅ 웹훅을 %s(으)로 설정했습니다",
+	"webhook.pending":        "대기 중인 업데이트: %d",
+	"webhook.last_error":     "마지막 전송 오류: %s",
+
+	"inline.title":       "인라인 모드는 아직 지원되지 않습니다",
+	"inline.description": "청구서 사진은 봇과의 개인 채팅으로 보내 주세요.",
+	"inline.message":     "저에게 직접 보낸 사진에서 청구서를 읽습니다. 저와 채팅을 열고 청구서나 영수증 사진을 보내 주세요.",
+	"inline.button":      "봇과 채팅 열기",
+}
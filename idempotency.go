@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cached response for a processed Idempotency-Key
+type idempotencyEntry struct {
+	done        bool
+	status      int
+	contentType string
+	body        []byte
+	expires     time.Time
+}
+
+var (
+	idempotencyMu    sync.Mutex
+	idempotencyKeys  = make(map[string]*idempotencyEntry)
+	idempotencySweep sweepSchedule
+	idempotencyTTL   = 10 * time.Minute
+)
+
+// responseRecorder captures the response body so it can be replayed for duplicates
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// idempotencyMiddleware returns the cached response for requests that repeat an
+// Idempotency-Key instead of processing them again. Requests without the header
+// are passed through untouched. A repeat that arrives while the original is
+// still running gets 409 with Retry-After, since there is no result to give it
+// yet; with ackInProgress it gets a plain 200 instead, which is all Telegram
+// needs to stop redelivering a webhook update.
+//
+// Mount it after the route's authentication, so unauthenticated requests
+// can't read or claim keys. Keys are scoped to the route and the client's IP,
+// so two callers picking the same key don't get each other's responses.
+func idempotencyMiddleware(ackInProgress bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" {
+			c.Next()
+			return
+		}
+		key = c.FullPath() + "|" + c.ClientIP() + "|" + key
+
+		idempotencyMu.Lock()
+		now := time.Now()
+		if idempotencySweep.due(now) {
+			for k, entry := range idempotencyKeys {
+				if now.After(entry.expires) {
+					delete(idempotencyKeys, k)
+				}
+			}
+		}
+
+		// Expired entries the sweep hasn't reached yet count as gone
+		if entry, ok := idempotencyKeys[key]; ok && !now.After(entry.expires) {
+			cached := *entry
+			idempotencyMu.Unlock()
+
+			if !cached.done {
+				// The original request is still being processed
+				if ackInProgress {
+					c.AbortWithStatusJSON(http.StatusOK, gin.H{"status": "ok"})
+					return
+				}
+				c.Header("Retry-After", "1")
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+				return
+			}
+
+			c.Data(cached.status, cached.contentType, cached.body)
+			c.Abort()
+			return
+		}
+
+		idempotencyKeys[key] = &idempotencyEntry{expires: now.Add(idempotencyTTL)}
+		idempotencyMu.Unlock()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+
+		c.Next()
+
+		idempotencyMu.Lock()
+		defer idempotencyMu.Unlock()
+
+		// Only successful responses are cached so failed requests can be retried
+		status := recorder.Status()
+		if status < 200 || status >= 300 {
+			delete(idempotencyKeys, key)
+			return
+		}
+
+		idempotencyKeys[key] = &idempotencyEntry{
+			done:        true,
+			status:      status,
+			contentType: recorder.Header().Get("Content-Type"),
+			body:        recorder.body.Bytes(),
+			expires:     time.Now().Add(idempotencyTTL),
+		}
+	}
+}
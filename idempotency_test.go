@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// extractRouter mounts /extract's middleware in front of handler
+func extractRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/extract", requireExtractAPIKey(&Config{ExtractAPIKey: "secret"}), idempotencyMiddleware(false), handler)
+	return router
+}
+
+func postExtract(router *gin.Engine, apiKey, idempotencyKey string) *httptest.ResponseRecorder {
+	return postExtractFrom(router, "192.0.2.1:1234", apiKey, idempotencyKey)
+}
+
+func postExtractFrom(router *gin.Engine, remoteAddr, apiKey, idempotencyKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/extract", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("X-API-Key", apiKey)
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotentReplayNeedsAPIKey(t *testing.T) {
+	router := extractRouter(func(c *gin.Context) {
+		c.JSON(200, gin.H{"invoice": "private"})
+	})
+
+	if w := postExtract(router, "secret", "replay-auth"); w.Code != 200 {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	if w := postExtract(router, "wrong", "replay-auth"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("replay without the key: status %d, body %s", w.Code, w.Body)
+	}
+	if w := postExtract(router, "secret", "replay-auth"); w.Code != 200 || w.Body.String() != `{"invoice":"private"}` {
+		t.Fatalf("replay with the key: status %d, body %s", w.Code, w.Body)
+	}
+}
+
+func TestIdempotentRepeatWhileInProgress(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	router := extractRouter(func(c *gin.Context) {
+		close(started)
+		<-release
+		c.JSON(200, gin.H{"invoice": "done"})
+	})
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- postExtract(router, "secret", "in-progress") }()
+	<-started
+
+	w := postExtract(router, "secret", "in-progress")
+	if w.Code != http.StatusConflict || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("repeat while running: status %d, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	close(release)
+	if w := <-first; w.Code != 200 {
+		t.Fatalf("original request: status %d", w.Code)
+	}
+	if w := postExtract(router, "secret", "in-progress"); w.Body.String() != `{"invoice":"done"}` {
+		t.Fatalf("repeat after finishing: body %s", w.Body)
+	}
+}
+
+func TestIdempotencyKeysAreScopedPerClient(t *testing.T) {
+	router := extractRouter(func(c *gin.Context) {
+		c.JSON(200, gin.H{"client": c.ClientIP()})
+	})
+
+	if w := postExtractFrom(router, "192.0.2.10:1", "secret", "shared-key"); w.Body.String() != `{"client":"192.0.2.10"}` {
+		t.Fatalf("first client: body %s", w.Body)
+	}
+	if w := postExtractFrom(router, "192.0.2.11:1", "secret", "shared-key"); w.Body.String() != `{"client":"192.0.2.11"}` {
+		t.Fatalf("second client with the same key got %s, want its own response", w.Body)
+	}
+}
+
+func TestExpiredIdempotencyKeyIsProcessedAgain(t *testing.T) {
+	defer restore(&idempotencyTTL, time.Millisecond)()
+	var calls atomic.Int32
+	router := extractRouter(func(c *gin.Context) {
+		calls.Add(1)
+		c.JSON(200, gin.H{"invoice": "again"})
+	})
+
+	postExtract(router, "secret", "expiring")
+	time.Sleep(5 * time.Millisecond)
+	postExtract(router, "secret", "expiring")
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want the expired key not to be replayed before the sweep removes it", n)
+	}
+}
+
+func TestWebhookReplayNeedsSecret(t *testing.T) {
+	captureLogs(t)
+	useFakeAPIs(t, &fakeTelegram{}, http.NotFoundHandler())
+	defer restore(&seenUpdates, updateDeduper(newUpdateSet()))()
+	cfg := &Config{WebhookSecret: "hook-secret"}
+	useTestWorkers(t, cfg)
+	defer stopWorkers(context.Background())
+
+	gin.SetMode(gin.TestMode)
+	router := newRouter(cfg)
+	body, _ := json.Marshal(TelegramUpdate{UpdateID: 9850})
+	post := func(secret string) int {
+		req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(body))
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		req.Header.Set("Idempotency-Key", "webhook-replay")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status := post("hook-secret"); status != http.StatusOK {
+		t.Fatalf("status %d, want 200", status)
+	}
+	// The cached response isn't replayed to a request that fails the secret check
+	if status := post("wrong"); status != http.StatusForbidden {
+		t.Errorf("replay with the wrong secret: status %d, want 403", status)
+	}
+}
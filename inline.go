@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// TelegramInlineQuery is sent when someone types "@yourbot ..." in any chat.
+// It only arrives if inline mode is enabled for the bot in BotFather.
+type TelegramInlineQuery struct {
+	ID       string       `json:"id"`
+	From     TelegramUser `json:"from"`
+	Query    string       `json:"query"`
+	Offset   string       `json:"offset"`
+	ChatType string       `json:"chat_type"`
+}
+
+// How long Telegram may reuse an inline answer for the same query
+const inlineCacheSeconds = 300
+
+// handleInlineQuery answers inline queries. Invoices can't be sent through inline
+// mode, so for now it offers a single result explaining that and a button that
+// opens a private chat. Inline features, e.g. searching extracted invoices,
+// would build their results here.
+func handleInlineQuery(ctx context.Context, query *TelegramInlineQuery) {
+	logger := loggerFrom(ctx).With("user_id", query.From.ID, "inline_query_id", query.ID)
+	logger.Info("Received inline query", "chat_type", query.ChatType)
+	lang := userLanguage(query.From.LanguageCode)
+
+	results := []map[string]interface{}{
+		{
+			"type":        "article",
+			"id":          "unsupported",
+			"title":       t("inline.title", lang),
+			"description": t("inline.description", lang),
+			"input_message_content": map[string]interface{}{
+				"message_text": t("inline.message", lang),
+			},
+		},
+	}
+	if err := answerInlineQuery(ctx, query.ID, results, lang); err != nil {
+		logger.Error("Failed to answer inline query", "error", err)
+	}
+}
+
+// answerInlineQuery sends inline results along with a button that opens a private chat with the bot
+func answerInlineQuery(ctx context.Context, queryID string, results []map[string]interface{}, lang string) error {
+	if err := callTelegramMethod(ctx, "answerInlineQuery", map[string]interface{}{
+		"inline_query_id": queryID,
+		"results":         results,
+		"cache_time":      inlineCacheSeconds,
+		"button": map[string]interface{}{
+			"text":            t("inline.button", lang),
+			"start_parameter": "inline",
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to answer inline query: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Invoice holds the structured fields extracted from an invoice or receipt
+type Invoice struct {
+	InvoiceNumber string      `json:"invoice_number"`
+	Date          InvoiceDate `json:"date"`
+	Vendor        string      `json:"vendor"`
+	Currency      string      `json:"currency"`
+	LineItems     []LineItem  `json:"line_items"`
+	Subtotal      *Decimal    `json:"subtotal"`
+	Tax           *Decimal    `json:"tax"`
+	Total         *Decimal    `json:"total"`
+	VINs          []string    `json:"vins"`
+	OtherText     string      `json:"other_text"`
+}
+
+type LineItem struct {
+	Description string   `json:"description"`
+	Quantity    *Decimal `json:"quantity"`
+	UnitPrice   *Decimal `json:"unit_price"`
+	Amount      *Decimal `json:"amount"`
+}
+
+// UnmarshalJSON drops amounts the model wrote in a form we can't read, rather
+// than failing the whole invoice over one field
+func (i *Invoice) UnmarshalJSON(data []byte) error {
+	type plain Invoice
+	if err := json.Unmarshal(data, (*plain)(i)); err != nil {
+		return err
+	}
+	for _, amount := range []**Decimal{&i.Subtotal, &i.Tax, &i.Total} {
+		dropUnparsed(amount)
+	}
+	return nil
+}
+
+// UnmarshalJSON drops unreadable amounts like Invoice.UnmarshalJSON does
+func (l *LineItem) UnmarshalJSON(data []byte) error {
+	type plain LineItem
+	if err := json.Unmarshal(data, (*plain)(l)); err != nil {
+		return err
+	}
+	for _, amount := range []**Decimal{&l.Quantity, &l.UnitPrice, &l.Amount} {
+		dropUnparsed(amount)
+	}
+	return nil
+}
+
+// Decimal is an exact decimal number, so amounts never pick up float rounding errors
+type Decimal struct {
+	big.Rat
+
+	unparsed bool // UnmarshalJSON couldn't read the value
+}
+
+// UnmarshalJSON accepts JSON numbers and numeric strings, including amounts
+// with currency symbols and locale separators like "₩1,234,500" or "12,50 €".
+// Anything else is marked unparsed instead of failing, and the Invoice or
+// LineItem holding it drops the field.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	value := strings.TrimSpace(strings.Trim(string(data), `"`))
+	if _, ok := d.SetString(value); ok {
+		return nil
+	}
+
+	amount, currency, err := normalizeAmount(value)
+	if err != nil {
+		slog.Warn("Ignoring unreadable amount", "value", string(data), "error", err)
+		d.unparsed = true
+		return nil
+	}
+	d.Set(&minorUnitsToDecimal(amount, currency).Rat)
+	return nil
+}
+
+// dropUnparsed clears an amount that UnmarshalJSON couldn't read
+func dropUnparsed(amount **Decimal) {
+	if *amount != nil && (*amount).unparsed {
+		*amount = nil
+	}
+}
+
+// MarshalJSON writes the exact value, so a cached invoice reads back unchanged.
+// Amounts are finite decimals and become JSON numbers; anything else (like 1/3)
+// is written as a fraction string, which UnmarshalJSON parses back.
+func (d *Decimal) MarshalJSON() ([]byte, error) {
+	if digits, exact := d.FloatPrec(); exact {
+		return []byte(d.FloatString(digits)), nil
+	}
+	return json.Marshal(d.RatString())
+}
+
+// String formats whole numbers without decimals and everything else with two
+func (d *Decimal) String() string {
+	if d == nil {
+		return ""
+	}
+	if d.IsInt() {
+		return d.Rat.FloatString(0)
+	}
+	return d.Rat.FloatString(2)
+}
+
+// InvoiceDate parses the YYYY-MM-DD dates the model is asked to return
+type InvoiceDate struct {
+	time.Time
+}
+
+// UnmarshalJSON leaves the date unset when the model returns null or something unparseable
+func (d *InvoiceDate) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil || value == "" {
+		return nil
+	}
+
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		d.Time = t
+	}
+	return nil
+}
+
+func (d InvoiceDate) MarshalJSON() ([]byte, error) {
+	if d.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(d.Format("2006-01-02"))
+}
+
+const invoiceExtractionPrompt = `Extract the invoice or receipt details from this image and reply with a JSON object with exactly these keys:
+- "invoice_number": string
+- "date": invoice date as "YYYY-MM-DD"
+- "vendor": name of the seller
+- "currency": ISO 4217 currency code, e.g. "USD"
+- "line_items": one object per row of the line-item table, with "description" (string), "quantity", "unit_price" and "amount" (the line total) as numbers
+- "subtotal", "tax", "total": numbers
+- "vins": every vehicle identification number (VIN) on the document, exactly as printed, as an array of strings
+- "other_text": any other readable text that doesn't fit the fields above, such as license plates
+Write amounts as plain numbers without currency symbols or thousands separators. Use null for anything that is missing.`
+
+// extractInvoiceFields asks the model for structured invoice fields using JSON mode
+func extractInvoiceFields(ctx context.Context, imageURL, prompt, model string) (*Invoice, Usage, error) {
+	return requestInvoiceFields(ctx, model, []Content{
+		{
+			Type: "text",
+			Text: prompt,
+		},
+		{
+			Type: "image_url",
+			ImageURL: &ImageURL{
+				URL: imageURL,
+			},
+		},
+	})
+}
+
+// extractInvoiceFieldsFromText structures the text of a document that wasn't sent as an image
+func extractInvoiceFieldsFromText(ctx context.Context, text, prompt, model string) (*Invoice, Usage, error) {
+	return requestInvoiceFields(ctx, model, []Content{
+		{
+			Type: "text",
+			Text: prompt,
+		},
+		{
+			Type: "text",
+			Text: "The document was sent as a file rather than an image, so here is its text:\n\n" + text,
+		},
+	})
+}
+
+func requestInvoiceFields(ctx context.Context, model string, input []Content) (*Invoice, Usage, error) {
+	request := OpenAIRequest{
+		Model:          model,
+		ResponseFormat: &ResponseFormat{Type: "json_object"},
+		Messages: []Message{
+			{
+				Role:    "user",
+				Content: input,
+			},
+		},
+	}
+
+	content, usage, err := callOpenAI(ctx, request)
+	if err != nil {
+		return nil, usage, err
+	}
+
+	var invoice Invoice
+	if err := json.Unmarshal([]byte(content), &invoice); err != nil {
+		return nil, usage, fmt.Errorf("failed to parse invoice fields: %v", err)
+	}
+	if isNoTextAnswer(invoice.OtherText) {
+		invoice.OtherText = ""
+	}
+
+	return &invoice, usage, nil
+}
+
+// isEmpty reports whether the model found nothing at all
+func (inv *Invoice) isEmpty() bool {
+	return inv.InvoiceNumber == "" && inv.Date.IsZero() && inv.Vendor == "" &&
+		len(inv.LineItems) == 0 && inv.Subtotal == nil && inv.Tax == nil && inv.Total == nil &&
+		len(inv.VINs) == 0 && strings.TrimSpace(inv.OtherText) == ""
+}
+
+// defaultInvoiceReply is the built-in invoice message, used unless INVOICE_REPLY_TEMPLATE is set
+func defaultInvoiceReply(inv *Invoice, lang string) string {
+	if inv.isEmpty() {
+		return t("invoice.empty", lang)
+	}
+
+	var b strings.Builder
+	b.WriteString(t("invoice.title", lang) + "\n")
+
+	if inv.Vendor != "" {
+		fmt.Fprintf(&b, "\n**%s:** %s", t("invoice.vendor", lang), escapeMarkdown(inv.Vendor))
+	}
+	if inv.InvoiceNumber != "" {
+		fmt.Fprintf(&b, "\n**%s:** %s", t("invoice.number", lang), escapeMarkdown(inv.InvoiceNumber))
+	}
+	if !inv.Date.IsZero() {
+		fmt.Fprintf(&b, "\n**%s:** %s", t("invoice.date", lang), inv.Date.Format("2006-01-02"))
+	}
+	b.WriteString(formatVINs(inv.VINs, lang))
+
+	if len(inv.LineItems) > 0 {
+		fmt.Fprintf(&b, "\n\n**%s:**", t("invoice.line_items", lang))
+		for _, item := range inv.LineItems {
+			fmt.Fprintf(&b, "\n• %s", escapeMarkdown(item.Description))
+			if item.Quantity != nil && item.UnitPrice != nil {
+				fmt.Fprintf(&b, " — %s × %s", item.Quantity, item.UnitPrice)
+			}
+			if item.Amount != nil {
+				fmt.Fprintf(&b, " = %s", item.Amount)
+			}
+		}
+	}
+
+	if inv.Subtotal != nil || inv.Tax != nil || inv.Total != nil {
+		b.WriteString("\n")
+	}
+	if inv.Subtotal != nil {
+		fmt.Fprintf(&b, "\n**%s:** %s", t("invoice.subtotal", lang), formatMoney(inv.Subtotal, inv.Currency))
+	}
+	if inv.Tax != nil {
+		fmt.Fprintf(&b, "\n**%s:** %s", t("invoice.tax", lang), formatMoney(inv.Tax, inv.Currency))
+	}
+	if inv.Total != nil {
+		fmt.Fprintf(&b, "\n**%s:** %s", t("invoice.total", lang), formatMoney(inv.Total, inv.Currency))
+	}
+
+	if text := strings.TrimSpace(inv.OtherText); text != "" {
+		fmt.Fprintf(&b, "\n\n📝 **%s:**\n%s", t("invoice.other_text", lang), escapeMarkdown(text))
+	}
+
+	return b.String()
+}
+
+func formatMoney(amount *Decimal, currency string) string {
+	if currency == "" {
+		return amount.String()
+	}
+	return amount.String() + " " + escapeMarkdown(currency)
+}
+
+// lineItemsMismatch adds up the line items and compares them with the
+// subtotal, or with the total when the invoice has neither subtotal nor tax.
+// It returns both amounts and true when they differ by more than rounding:
+// a cent per line or 1% of the expected amount, whichever is larger. Invoices
+// that can't be checked, e.g. with a line missing its amount, return false.
+func lineItemsMismatch(inv *Invoice) (*Decimal, *Decimal, bool) {
+	expected := inv.Subtotal
+	if expected == nil && inv.Tax == nil {
+		expected = inv.Total
+	}
+	if expected == nil || len(inv.LineItems) == 0 {
+		return nil, nil, false
+	}
+
+	sum := &Decimal{}
+	for _, item := range inv.LineItems {
+		switch {
+		case item.Amount != nil:
+			sum.Add(&sum.Rat, &item.Amount.Rat)
+		case item.Quantity != nil && item.UnitPrice != nil:
+			var amount big.Rat
+			sum.Add(&sum.Rat, amount.Mul(&item.Quantity.Rat, &item.UnitPrice.Rat))
+		default:
+			return nil, nil, false
+		}
+	}
+
+	var diff, tolerance, relative big.Rat
+	diff.Abs(diff.Sub(&sum.Rat, &expected.Rat))
+	tolerance.SetFrac64(int64(len(inv.LineItems)), 100)
+	relative.Abs(relative.Mul(&expected.Rat, big.NewRat(1, 100)))
+	if relative.Cmp(&tolerance) > 0 {
+		tolerance.Set(&relative)
+	}
+	return sum, expected, diff.Cmp(&tolerance) > 0
+}
+
+// lineItemsWarning returns the note appended to a reply when the line items
+// don't add up, or "" if they do
+func lineItemsWarning(inv *Invoice, lang string) string {
+	sum, expected, mismatch := lineItemsMismatch(inv)
+	if !mismatch {
+		return ""
+	}
+	key := "line_items.subtotal_mismatch"
+	if expected != inv.Subtotal {
+		key = "line_items.total_mismatch"
+	}
+	return "\n\n" + t(key, lang, formatMoney(sum, inv.Currency), formatMoney(expected, inv.Currency))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestDecimalJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		value string
+		json  string
+	}{
+		{"12.5", "12.5"},
+		{"1234567.891", "1234567.891"},
+		{"0.005", "0.005"},
+		{"-3.125", "-3.125"},
+		{"1200", "1200"},
+		{"1/3", `"1/3"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			data, err := json.Marshal(decimal(tt.value))
+			if err != nil || string(data) != tt.json {
+				t.Fatalf("marshalled to %s, %v, want %s", data, err, tt.json)
+			}
+			var back Decimal
+			if err := json.Unmarshal(data, &back); err != nil {
+				t.Fatal(err)
+			}
+			if back.Cmp(&decimal(tt.value).Rat) != 0 {
+				t.Errorf("read back %s, want %s", back.RatString(), tt.value)
+			}
+		})
+	}
+}
+
+func TestDecimalUnmarshalIsLenient(t *testing.T) {
+	tests := []struct {
+		json string
+		want string
+	}{
+		{`12.5`, "12.5"},
+		{`"12.50"`, "12.5"},
+		{`"1,234.50"`, "1234.5"},
+		{`"1.234,50"`, "1234.5"},
+		{`"12,5"`, "12.5"},
+		{`"₩1,234,000"`, "1234000"},
+		{`"$ 99.99"`, "99.99"},
+		{`"1 234,56 EUR"`, "1234.56"},
+	}
+
+	for _, tt := range tests {
+		var d Decimal
+		if err := json.Unmarshal([]byte(tt.json), &d); err != nil || d.unparsed {
+			t.Errorf("%s: %v, unparsed %v", tt.json, err, d.unparsed)
+			continue
+		}
+		if d.Cmp(&decimal(tt.want).Rat) != 0 {
+			t.Errorf("%s: got %s, want %s", tt.json, d.RatString(), tt.want)
+		}
+	}
+}
+
+func TestInvoiceDropsUnreadableAmounts(t *testing.T) {
+	data := `{"vendor":"Lenient Garage","total":"about 40","tax":"","subtotal":"35.00",
+		"line_items":[{"description":"Oil","quantity":"two","unit_price":"12,50 €","amount":"N/A"}]}`
+
+	var invoice Invoice
+	if err := json.Unmarshal([]byte(data), &invoice); err != nil {
+		t.Fatalf("one unreadable field failed the whole invoice: %v", err)
+	}
+	if invoice.Vendor != "Lenient Garage" || invoice.Subtotal.String() != "35" {
+		t.Errorf("readable fields lost: %+v", invoice)
+	}
+	if invoice.Total != nil || invoice.Tax != nil {
+		t.Errorf("total %v and tax %v, want both dropped", invoice.Total, invoice.Tax)
+	}
+	item := invoice.LineItems[0]
+	if item.Quantity != nil || item.Amount != nil || item.UnitPrice.String() != "12.50" {
+		t.Errorf("line item quantity %v, unit price %v, amount %v, want only the unit price", item.Quantity, item.UnitPrice, item.Amount)
+	}
+}
+
+func TestCachedInvoiceKeepsExactAmounts(t *testing.T) {
+	defer restore(&extractionCache, ExtractionCache(newMemoryCache()))()
+	defer restore(&extractionCacheTTL, time.Minute)()
+
+	extract := func() (*Invoice, Usage, error) {
+		return &Invoice{
+			Currency:  "KWD",
+			Total:     decimal("12.345"),
+			LineItems: []LineItem{{Description: "Oil", Quantity: decimal("1.5"), UnitPrice: decimal("8.23"), Amount: decimal("12.345")}},
+		}, Usage{}, nil
+	}
+	cachedInvoice(context.Background(), "exact", "prompt", "gpt-4o", extract)
+
+	cached, _, err := cachedInvoice(context.Background(), "exact", "prompt", "gpt-4o", func() (*Invoice, Usage, error) {
+		t.Fatal("second extraction wasn't served from the cache")
+		return nil, Usage{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Total.Cmp(&decimal("12.345").Rat) != 0 || cached.LineItems[0].Amount.Cmp(&decimal("12.345").Rat) != 0 {
+		t.Errorf("cached total %s, amount %s, want 12.345", cached.Total.RatString(), cached.LineItems[0].Amount.RatString())
+	}
+}
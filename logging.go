@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupLogging installs a JSON logger at the given level (LOG_LEVEL: debug, info, warn, error)
+func setupLogging(level string) error {
+	var logLevel slog.Level
+	if level != "" {
+		if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL %q", level)
+		}
+	}
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})
+	slog.SetDefault(slog.New(&redactingHandler{Handler: handler}))
+	return nil
+}
+
+// fatal logs an error and exits, like log.Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// requestLogger logs each HTTP request as a structured record instead of Gin's text lines
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		slog.Info("HTTP request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"client_ip", c.ClientIP(),
+			"duration_ms", time.Since(start).Milliseconds(),
+		)
+	}
+}
+
+// Personal data masked in every log line (LOG_REDACT_PATTERNS). Extracted
+// invoices carry names, emails and account numbers; the full text only goes
+// to the user and the invoice store.
+var piiPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[\w.+-]+@[\w-]+(?:\.[\w-]+)+`),     // email address
+	regexp.MustCompile(`\b[A-Z]{2}\d{2}[A-Z0-9]{11,30}\b`), // IBAN
+	regexp.MustCompile(`\b\d(?:[ -]?\d){8,}\b`),            // card, account or phone number
+}
+
+// parseRedactPatterns compiles LOG_REDACT_PATTERNS, reporting the first invalid one
+func parseRedactPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// redactLogText masks secrets and personal data before a log line is written
+func redactLogText(s string) string {
+	s = redactSecrets(s)
+	for _, re := range piiPatterns {
+		s = re.ReplaceAllString(s, "[REDACTED]")
+	}
+	return s
+}
+
+// redactSecrets replaces the bot token and API key wherever they appear.
+// Telegram URLs embed the token, and HTTP client errors quote the URL.
+func redactSecrets(s string) string {
+	for _, secret := range append([]string{telegramBotToken}, openAIKeys.all()...) {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "[REDACTED]")
+		}
+	}
+	return s
+}
+
+// redactingHandler scrubs secrets and personal data from every message and attribute before it's written
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	redacted := slog.NewRecord(r.Time, r.Level, redactLogText(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		redacted.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &redactingHandler{Handler: h.Handler.WithAttrs(redacted)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	value := a.Value.Resolve()
+
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactLogText(value.String()))
+	case slog.KindGroup:
+		group := value.Group()
+		redacted := make([]slog.Attr, len(group))
+		for i, attr := range group {
+			redacted[i] = redactAttr(attr)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(redacted...)}
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return slog.String(a.Key, redactLogText(err.Error()))
+		}
+	}
+
+	return slog.Attr{Key: a.Key, Value: value}
+}
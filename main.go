@@ -2,39 +2,78 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Telegram API structures
 type TelegramUpdate struct {
-	UpdateID int64           `json:"update_id"`
-	Message  TelegramMessage `json:"message"`
+	UpdateID      int64                  `json:"update_id"`
+	Message       TelegramMessage        `json:"message"`
+	EditedMessage *TelegramMessage       `json:"edited_message"`
+	CallbackQuery *TelegramCallbackQuery `json:"callback_query"`
+	InlineQuery   *TelegramInlineQuery   `json:"inline_query"`
+}
+
+// TelegramCallbackQuery is sent when a user presses an inline keyboard button
+type TelegramCallbackQuery struct {
+	ID      string           `json:"id"`
+	From    TelegramUser     `json:"from"`
+	Message *TelegramMessage `json:"message"`
+	Data    string           `json:"data"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
 }
 
 type TelegramMessage struct {
-	MessageID int64           `json:"message_id"`
-	From      TelegramUser    `json:"from"`
-	Chat      TelegramChat    `json:"chat"`
-	Date      int64           `json:"date"`
-	Text      string          `json:"text"`
-	Photo     []TelegramPhoto `json:"photo"`
+	MessageID      int64              `json:"message_id"`
+	From           TelegramUser       `json:"from"`
+	Chat           TelegramChat       `json:"chat"`
+	Date           int64              `json:"date"`
+	Text           string             `json:"text"`
+	Caption        string             `json:"caption"`
+	Photo          []TelegramPhoto    `json:"photo"`
+	Document       *TelegramDocument  `json:"document"`
+	Voice          *TelegramMediaFile `json:"voice"`
+	Audio          *TelegramMediaFile `json:"audio"`
+	Video          *TelegramMediaFile `json:"video"`
+	VideoNote      *TelegramMediaFile `json:"video_note"`
+	MediaGroupID   string             `json:"media_group_id"`
+	ReplyToMessage *TelegramMessage   `json:"reply_to_message"`
 }
 
 type TelegramUser struct {
-	ID        int64  `json:"id"`
-	IsBot     bool   `json:"is_bot"`
-	FirstName string `json:"first_name"`
-	Username  string `json:"username"`
+	ID           int64  `json:"id"`
+	IsBot        bool   `json:"is_bot"`
+	FirstName    string `json:"first_name"`
+	Username     string `json:"username"`
+	LanguageCode string `json:"language_code"`
 }
 
 type TelegramChat struct {
@@ -51,18 +90,60 @@ type TelegramPhoto struct {
 	FileSize     int    `json:"file_size"`
 }
 
+type TelegramDocument struct {
+	FileID       string `json:"file_id"`
+	FileUniqueID string `json:"file_unique_id"`
+	FileName     string `json:"file_name"`
+	MimeType     string `json:"mime_type"`
+	FileSize     int    `json:"file_size"`
+}
+
+// TelegramMediaFile covers the voice, audio and video fields we only need to recognize
+type TelegramMediaFile struct {
+	FileID   string `json:"file_id"`
+	Duration int    `json:"duration"`
+	MimeType string `json:"mime_type"`
+	FileSize int64  `json:"file_size"`
+}
+
 type TelegramGetFileResponse struct {
-	OK     bool `json:"ok"`
-	Result struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Result      struct {
 		FileID   string `json:"file_id"`
 		FilePath string `json:"file_path"`
 	} `json:"result"`
 }
 
+type TelegramErrorResponse struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+type TelegramGetChatMemberResponse struct {
+	OK     bool `json:"ok"`
+	Result struct {
+		Status string `json:"status"`
+	} `json:"result"`
+}
+
 // OpenAI API structures
 type OpenAIRequest struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	Temperature    *float64        `json:"temperature,omitempty"`
+	Seed           *int64          `json:"seed,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+type ResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type Message struct {
@@ -77,7 +158,8 @@ type Content struct {
 }
 
 type ImageURL struct {
-	URL string `json:"url"`
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
 }
 
 type OpenAIResponse struct {
@@ -93,44 +175,211 @@ type OpenAIResponse struct {
 		} `json:"message"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage Usage `json:"usage"`
+}
+
+// Usage is the token count OpenAI bills a request for
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// add accumulates the usage of several requests
+func (u *Usage) add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
 }
 
+// Prompt for the /total fast path
+const totalExtractionPrompt = "What is the grand total of this invoice or receipt? Reply with only the amount and its currency code, for example \"1,234.50 USD\". If there is no total, reply \"not found\"."
+
+// API endpoints, overridable for a local Bot API server or an OpenAI-compatible proxy
+var (
+	telegramAPIBase = "https://api.telegram.org"
+	openAIAPIBase   = "https://api.openai.com"
+)
+
 // Global variables
 var (
 	telegramBotToken string
-	openAIAPIKey     string
+	openAIModel      string
+	safeMode         bool
+	showUsage        bool
+
+	moderationEnabled bool
+	// MODERATION_REFUSAL_MESSAGE; empty uses the built-in reply in the user's language
+	moderationRefusalMessage string
+
+	// Largest file we'll download and process
+	maxFileSizeBytes int64 = 20 * 1024 * 1024
 )
 
 func main() {
 	// Load environment variables
 	err := godotenv.Load()
+
+	// Structured JSON logging, set up first so everything below goes through it
+	if err := setupLogging(os.Getenv("LOG_LEVEL")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	if err != nil {
-		log.Println("Warning: .env file not found, using system environment variables")
+		slog.Warn(".env file not found, using system environment variables")
 	}
 
-	telegramBotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
-	openAIAPIKey = os.Getenv("OPENAI_API_KEY")
+	// Read and validate all settings, reporting every problem at once
+	cfg, err := loadConfig(os.Getenv)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	cfg.apply()
 
-	if telegramBotToken == "" || openAIAPIKey == "" {
-		log.Fatal("Missing required environment variables: TELEGRAM_BOT_TOKEN and OPENAI_API_KEY")
+	if len(cfg.OpenAIAPIKeys) > 1 {
+		slog.Info("Rotating OpenAI API keys", "keys", len(cfg.OpenAIAPIKeys))
+	}
+	slog.Info("Using OpenAI model", "model", cfg.OpenAIModel)
+	if cfg.WebhookSecret == "" {
+		slog.Warn("TELEGRAM_WEBHOOK_SECRET not set, webhook requests are not verified")
+	}
+	if cfg.WebhookURL != "" && cfg.BotMode == "polling" {
+		slog.Warn("WEBHOOK_URL is ignored in polling mode")
+	}
+	if cfg.SafeMode {
+		slog.Info("Safe mode enabled: image OCR is disabled by default")
+	}
+	if cfg.Moderation {
+		slog.Info("Moderation pre-check enabled")
+	}
+	if cfg.DryRun {
+		slog.Warn("Dry run enabled: OpenAI is not called and replies are placeholders")
+	}
+	if cfg.DebugDumpRequests {
+		slog.Warn("Dumping OpenAI requests and responses, which include invoice contents", "file", cfg.DebugDumpFile, "per_minute", cfg.DebugDumpPerMinute)
+	}
+	if len(cfg.AllowedChatIDs) > 0 || len(cfg.AllowedUserIDs) > 0 {
+		slog.Info("Restricting bot to allowlisted chats and users", "chats", len(cfg.AllowedChatIDs), "users", len(cfg.AllowedUserIDs))
 	}
 
-	// Initialize Gin router
-	router := gin.Default()
+	// Optional caption-to-prompt rules (hot-reloaded when the file changes)
+	if cfg.CaptionRulesFile != "" {
+		if err := initCaptionRules(cfg.CaptionRulesFile); err != nil {
+			fatal("Invalid CAPTION_RULES_FILE", "error", err)
+		}
+		slog.Info("Loaded caption rules", "path", cfg.CaptionRulesFile)
+	}
 
-	// Routes
-	router.GET("/", healthCheck)
-	router.POST("/webhook", handleWebhook)
-	router.POST("/test-image", handleTestImage)
+	// Shared state for running several instances or surviving restarts
+	if cfg.StateBackend != "memory" {
+		store, err := newStateStore(cfg.StateBackend, cfg.RedisURL)
+		if err != nil {
+			fatal("Invalid state backend configuration", "error", err)
+		}
+		stateStore = store
+		seenUpdates = storeUpdateSet{store: store}
+		extractionCache = storeExtractionCache{store: store}
+		slog.Info("Using shared state backend", "backend", cfg.StateBackend)
+	}
+
+	// Background workers for webhook updates
+	startWorkers(cfg)
+
+	// Cancelled on SIGINT/SIGTERM (Render sends SIGTERM on deploy)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// Get port from environment (Render provides this)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Catch a revoked key at deploy time; dry runs never call OpenAI
+	if cfg.OpenAIStartupCheck && !cfg.DryRun {
+		if err := checkOpenAIKeys(ctx); err != nil {
+			fatal("OpenAI API key check failed; set OPENAI_STARTUP_CHECK=false to skip it", "error", err)
+		}
+	}
+
+	// Group chats only get answers when the bot is mentioned, which needs its username
+	if cfg.GroupRequireMention {
+		if err := fetchBotInfo(ctx); err != nil {
+			fatal("Failed to fetch bot info for GROUP_REQUIRE_MENTION", "error", err)
+		}
+		slog.Info("Group chats require a mention", "bot_username", botUsername)
+	}
+
+	// Webhook (default) or long polling, which needs no public HTTPS URL
+	pollingDone := make(chan struct{})
+	if cfg.BotMode == "polling" {
+		slog.Info("Running in long-polling mode")
+		go func() {
+			defer close(pollingDone)
+			startPolling(ctx)
+		}()
+	} else {
+		close(pollingDone)
+		// Not fatal: a webhook set earlier keeps working, and admins can retry with /setwebhook
+		if cfg.WebhookURL != "" {
+			if err := registerWebhook(ctx, cfg); err != nil {
+				slog.Error("Failed to register WEBHOOK_URL with Telegram", "error", err)
+			} else {
+				slog.Info("Registered webhook", "url", cfg.WebhookURL)
+			}
+		}
+		logWebhookInfo(ctx)
 	}
 
-	log.Printf("Starting server on port %s", port)
-	router.Run(":" + port)
+	server := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: newRouter(cfg),
+	}
+
+	go func() {
+		slog.Info("Starting server", "port", cfg.Port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fatal("Server failed", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down, waiting for in-flight updates", "timeout_seconds", cfg.ShutdownTimeout.Seconds())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting requests and wait for running webhook handlers
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Server shutdown did not complete", "error", err)
+	}
+
+	// The polling loop finishes the update it's on before returning
+	select {
+	case <-pollingDone:
+	case <-shutdownCtx.Done():
+		slog.Error("Polling did not stop before the shutdown timeout")
+	}
+
+	// Stop queueing and let the workers drain the queue; anything still
+	// submitted after this is dropped rather than sent on a closed channel
+	if err := stopWorkers(shutdownCtx); err != nil {
+		slog.Error("Background updates did not finish before the shutdown timeout", "error", err)
+	}
+
+	slog.Info("Shutdown complete")
+}
+
+// newRouter sets up the HTTP routes, handing cfg to the handlers that check
+// the webhook secret and the extract API key
+func newRouter(cfg *Config) *gin.Engine {
+	router := gin.New()
+	router.Use(requestLogger(), gin.Recovery(), inFlightMetrics())
+
+	router.GET("/", healthCheck)
+	router.POST("/webhook", webhookRecovery(), requireWebhookSecret(cfg), idempotencyMiddleware(true), handleWebhook)
+	router.POST("/test-image", idempotencyMiddleware(false), handleTestImage)
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	if cfg.ExtractAPIKey != "" {
+		router.POST("/extract", requireExtractAPIKey(cfg), idempotencyMiddleware(false), handleExtract)
+	}
+	return router
 }
 
 func healthCheck(c *gin.Context) {
@@ -140,82 +389,400 @@ func healthCheck(c *gin.Context) {
 	})
 }
 
+// requireWebhookSecret verifies webhook requests come from Telegram, when
+// cfg.WebhookSecret is set
+func requireWebhookSecret(cfg *Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.WebhookSecret != "" {
+			token := c.GetHeader("X-Telegram-Bot-Api-Secret-Token")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.WebhookSecret)) != 1 {
+				slog.Warn("Rejected webhook request: invalid secret token", "client_ip", c.ClientIP())
+				c.AbortWithStatusJSON(403, gin.H{"error": "Forbidden"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// handleWebhook accepts updates from Telegram
 func handleWebhook(c *gin.Context) {
 	var update TelegramUpdate
 
 	if err := c.ShouldBindJSON(&update); err != nil {
-		log.Printf("Error parsing webhook: %v", err)
+		slog.Error("Error parsing webhook", "error", err)
 		c.JSON(400, gin.H{"error": "Invalid JSON"})
 		return
 	}
 
-	// Debug logging
-	log.Printf("Received webhook - UpdateID: %d, MessageID: %d, ChatID: %d, Text: '%s', Photos: %d",
-		update.UpdateID, update.Message.MessageID, update.Message.Chat.ID, update.Message.Text, len(update.Message.Photo))
+	// Telegram redelivers updates we were slow to acknowledge
+	if seenUpdates.markSeen(c.Request.Context(), update.UpdateID) {
+		slog.Info("Skipping duplicate update", "update_id", update.UpdateID)
+		c.JSON(200, gin.H{"status": "ok"})
+		return
+	}
+
+	// Answer right away and do the slow work in the background, so Telegram
+	// doesn't time out and redeliver. When the queue is full the user is told
+	// to try again instead.
+	submitUpdate(update)
+	c.JSON(200, gin.H{"status": "ok"})
+}
+
+// processUpdate handles a single Telegram update. It's shared by the webhook
+// handler and the long-polling loop.
+func processUpdate(ctx context.Context, update TelegramUpdate) {
+	updatesReceived.Inc()
+
+	// Covers both webhook and long-polling updates
+	if rejectUnauthorized(ctx, update) {
+		return
+	}
+
+	// Inline keyboard button presses
+	if update.CallbackQuery != nil {
+		handleCallbackQuery(ctx, update.CallbackQuery)
+		return
+	}
+
+	// "@bot ..." typed in any chat
+	if update.InlineQuery != nil {
+		handleInlineQuery(ctx, update.InlineQuery)
+		return
+	}
+
+	if update.EditedMessage != nil {
+		handleEditedMessage(ctx, update.UpdateID, *update.EditedMessage)
+		return
+	}
+
+	processMessage(ctx, update)
+}
+
+// processMessage handles the message in an update: commands, photos, documents and text
+func processMessage(ctx context.Context, update TelegramUpdate) {
+	logger := loggerFrom(ctx).With("chat_id", update.Message.Chat.ID)
+	ctx = withLogger(ctx, logger)
+	lang := messageLanguage(ctx, update.Message)
+	ctx = withLanguage(ctx, lang)
+	logger.Info("Received update",
+		"message_id", update.Message.MessageID,
+		"text", update.Message.Text,
+		"photos", len(update.Message.Photo))
+
+	// Lets a later edit that swaps the file be told apart from a caption edit
+	rememberMessageMedia(ctx, update.Message)
+
+	// Handle bot commands
+	if handleCommand(ctx, update.Message) {
+		return
+	}
+
+	// In groups, images are only read when the bot is mentioned or replied to.
+	// Albums are checked once complete, since only one photo carries the caption.
+	hasMedia := len(update.Message.Photo) > 0 || update.Message.Document != nil
+	if hasMedia && update.Message.MediaGroupID == "" && !isAddressedToBot(update.Message) {
+		logger.Debug("Ignoring group image that doesn't mention the bot")
+		return
+	}
+	update.Message.Caption = removeBotMention(update.Message.Caption)
+
+	// "/nocache" in the caption forces a fresh extraction of an already seen file
+	if caption, noCache := stripNoCacheOption(update.Message.Caption); noCache {
+		update.Message.Caption = caption
+		ctx = withoutCachedResults(ctx)
+	}
+
+	// /total as a photo caption, or as a reply to a photo, asks only for the grand total
+	photos := update.Message.Photo
+	totalOnly := isTotalRequest(update.Message.Caption)
+	if command, _ := parseCommand(update.Message.Text); command == "/total" {
+		if update.Message.ReplyToMessage == nil || len(update.Message.ReplyToMessage.Photo) == 0 {
+			sendTelegramMessage(ctx, update.Message.Chat.ID, update.Message.MessageID, t("total.usage", lang))
+			return
+		}
+		photos = update.Message.ReplyToMessage.Photo
+		totalOnly = true
+	}
 
 	// Check if message has photos
-	if len(update.Message.Photo) > 0 {
-		log.Printf("Processing photo - Available photos: %d", len(update.Message.Photo))
+	if len(photos) > 0 {
+		logger.Info("Processing photo", "photos", len(photos))
+
+		// Photos sent as an album are collected and answered together, safe
+		// mode included, so the album gets one reply rather than one per photo
+		if update.Message.MediaGroupID != "" && len(update.Message.Photo) > 0 {
+			bufferMediaGroupMessage(ctx, update.Message)
+			return
+		}
+
+		// Safe mode forbids sending images to OpenAI
+		settings := getChatSettings(ctx, update.Message.Chat.ID)
+		if settings.safeModeEnabled() {
+			logger.Info("Safe mode enabled, skipping image OCR")
+			sendTelegramMessage(ctx, update.Message.Chat.ID, update.Message.MessageID, t("ocr_disabled", lang))
+			return
+		}
 
 		// Get the last uploaded photo (most recent/highest quality)
-		latestPhoto := update.Message.Photo[len(update.Message.Photo)-1]
+		latestPhoto := photos[len(photos)-1]
 
-		log.Printf("Selected latest photo - FileID: %s, FileSize: %d", latestPhoto.FileID, latestPhoto.FileSize)
+		logger = logger.With("file_id", latestPhoto.FileID)
+		ctx = withLogger(ctx, logger)
+		logger.Info("Selected latest photo", "file_size", latestPhoto.FileSize)
 
 		// Validate we have a valid photo
 		if latestPhoto.FileID == "" {
-			log.Printf("No valid photo found")
-			c.JSON(200, gin.H{"status": "ok"})
+			logger.Warn("No valid photo found")
+			return
+		}
+
+		// Don't download files we won't process anyway
+		if reply := telegramFileSizeError(int64(latestPhoto.FileSize), lang); reply != "" {
+			logger.Warn("Rejected photo: file too large", "file_size", latestPhoto.FileSize, "max_file_size", maxFileSizeBytes)
+			sendTelegramMessage(ctx, update.Message.Chat.ID, update.Message.MessageID, reply)
 			return
 		}
 
 		// Download image from Telegram
-		log.Printf("Downloading image with FileID: %s", latestPhoto.FileID)
-		imageURL, err := downloadImage(latestPhoto.FileID)
+		start := time.Now()
+		content, err := downloadTelegramFile(ctx, latestPhoto.FileID)
+		if err != nil {
+			replyError(ctx, update.Message.Chat.ID, update.Message.MessageID, downloadErrorMessage(err, t("download.image_failed", lang), lang), fmt.Errorf("downloading image %s: %v", latestPhoto.FileID, err))
+			return
+		}
+
+		fileHash := contentHash(content)
+		logger.Info("Image downloaded", "duration_ms", time.Since(start).Milliseconds(), "file_hash", fileHash)
+
+		// The file URL contains the bot token, so OpenAI only ever sees the bytes.
+		// /retry gets the original in case preprocessing made things worse.
+		rememberImage(update.Message, imageDataURL(content), totalOnly)
+		extractAndReply(ctx, update.Message, prepareForExtraction(ctx, content), fileHash, totalOnly, settings, openAIModel)
+		return
+	}
+
+	// Images sent as files rather than photos
+	if update.Message.Document != nil {
+		processDocument(ctx, update.Message, totalOnly)
+		return
+	}
+
+	// Media we can't read gets an explanation instead of silence
+	if kind := unsupportedMediaKind(update.Message); kind != "" {
+		logger.Info("Unsupported media", "kind", kind)
+		replyToUnsupportedMedia(ctx, update.Message)
+		return
+	}
+
+	// No photos in message
+	logger.Debug("No photos in message")
+	if update.Message.Text != "" {
+		// A corrected total after pressing "Fix total"
+		if handleTotalCorrection(ctx, update.Message) {
+			return
+		}
+		// A link to an invoice image pasted instead of uploading it
+		if link := pastedURL(update.Message.Text); link != "" && isAddressedToBot(update.Message) {
+			processURL(ctx, update.Message, link)
+			return
+		}
+		replyToText(ctx, update.Message)
+	}
+}
+
+// extractAndReply runs moderation, classification and the extraction the message asks for on an
+// already downloaded image with the given model, and replies with the result
+func extractAndReply(ctx context.Context, message TelegramMessage, imageURL, fileHash string, totalOnly bool, settings ChatSettings, model string) {
+	logger := loggerFrom(ctx)
+	lang := languageFrom(ctx)
+
+	// Everything up to here (download, conversion, preprocessing) has run; stop before OpenAI
+	if dryRun {
+		kind := "invoice"
+		if totalOnly {
+			kind = "total"
+		} else if rule := matchCaptionRule(message.Caption); rule != nil {
+			kind = "text (caption rule " + escapeMarkdown(rule.Name) + ")"
+		} else if fields := requestedFields(message.Caption); fields != nil {
+			kind = "invoice fields " + escapeMarkdown(strings.Join(fields, ", "))
+		}
+		logger.Info("Dry run, skipping OpenAI", "kind", kind)
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, dryRunReply(imageURL, kind, model, lang))
+		return
+	}
+
+	// Refuse flagged content before extraction
+	if moderationEnabled {
+		start := time.Now()
+		flagged, categories, err := moderateImage(ctx, imageURL)
+		if err != nil {
+			replyError(ctx, message.Chat.ID, message.MessageID, openAIErrorMessage(err, t("moderation.failed", lang), lang), fmt.Errorf("running moderation check: %v", err))
+			return
+		}
+		if flagged {
+			logger.Warn("Image flagged by moderation", "categories", categories, "duration_ms", time.Since(start).Milliseconds())
+			sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, moderationRefusal(lang))
+			return
+		}
+	}
+
+	// Skip extraction for images that aren't documents at all. A caption rule
+	// asks for this image explicitly, and a failed check doesn't block extraction.
+	if classifyImages && matchCaptionRule(message.Caption) == nil {
+		start := time.Now()
+		kind, usage, err := classifyImage(ctx, imageURL, fileHash, model)
+		if err != nil {
+			logger.Warn("Image classification failed, extracting anyway", "error", err)
+		} else {
+			logger.Info("Image classified", "classification", kind, "duration_ms", time.Since(start).Milliseconds())
+			recordChatTokens(message.Chat.ID, usage)
+			if kind == imageKindOther {
+				sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, nonInvoiceReply(lang))
+				return
+			}
+		}
+	}
+
+	// Fast path: only the grand total
+	if totalOnly {
+		start := time.Now()
+		prompt := buildPrompt(totalExtractionPrompt, settings)
+		total, usage, err := cachedExtraction(ctx, fileHash, "total", model, prompt, func() (string, Usage, error) {
+			return extractTotalFromImage(ctx, imageURL, prompt, model)
+		})
+		recordExtraction("total", err)
 		if err != nil {
-			log.Printf("Error downloading image: %v", err)
-			sendTelegramMessage(update.Message.Chat.ID, "Sorry, I couldn't download the image. Please try again.")
-			c.JSON(200, gin.H{"status": "ok"})
+			replyError(ctx, message.Chat.ID, message.MessageID, openAIErrorMessage(err, t("total.failed", lang), lang), fmt.Errorf("extracting total: %v", err))
 			return
 		}
 
-		log.Printf("Image downloaded successfully: %s", imageURL)
+		logger.Info("Total extracted", "duration_ms", time.Since(start).Milliseconds())
+		recordChatUsage(message.Chat.ID, usage)
+
+		// Normalize separators and symbols when we can, otherwise show what the model said
+		formatted := escapeMarkdown(total)
+		if value, currency, err := normalizeAmount(total); err == nil {
+			formatted = formatMinorUnits(value, currency)
+		} else {
+			logger.Debug("Could not normalize total", "total", total, "error", err)
+		}
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("total.reply", lang, formatted)+usageFooter(usage, lang))
+		return
+	}
+
+	// A matching caption rule overrides the prompt and gets plain text back
+	if rule := matchCaptionRule(message.Caption); rule != nil {
+		logger.Info("Caption rule matched", "rule", rule.Name)
 
 		// Extract text using OpenAI Vision API
-		log.Printf("Sending image to OpenAI for text extraction...")
-		extractedData, err := extractTextFromImage(imageURL)
+		start := time.Now()
+		prompt := withUserNote(buildPrompt(rule.buildPrompt(extractionPrompt), settings), message.Caption)
+		extractedData, usage, err := cachedExtraction(ctx, fileHash, "text", model, prompt, func() (string, Usage, error) {
+			return extractTextFromImage(ctx, imageURL, prompt, model)
+		})
+		recordExtraction("text", err)
 		if err != nil {
-			log.Printf("Error extracting text: %v", err)
-			sendTelegramMessage(update.Message.Chat.ID, "Sorry, I couldn't extract any text from this image. Please try with a clearer image.")
-			c.JSON(200, gin.H{"status": "ok"})
+			replyError(ctx, message.Chat.ID, message.MessageID, openAIErrorMessage(err, t("image.failed", lang), lang), fmt.Errorf("extracting text: %v", err))
 			return
 		}
 
-		log.Printf("Text extracted successfully: %s", extractedData)
+		logger.Info("Text extracted", "duration_ms", time.Since(start).Milliseconds())
+		recordChatUsage(message.Chat.ID, usage)
+		logger.Debug("Extracted text", "text", extractedData)
 
 		// Send response back to Telegram
-		responseText := fmt.Sprintf("🔍 **Extracted text from image:**\n\n%s", extractedData)
-		log.Printf("Sending response to Telegram chat %d", update.Message.Chat.ID)
-		sendTelegramMessage(update.Message.Chat.ID, responseText)
-		c.JSON(200, gin.H{"status": "ok"})
+		responseText := formatImageReply(extractedData, "", lang) + usageFooter(usage, lang)
+		logger.Info("Sending response to Telegram")
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, responseText)
 		return
 	}
 
-	// No photos in message
-	log.Println("No photos in message")
-	c.JSON(200, gin.H{"status": "ok"})
+	// A caption like "invoice number and date" asks for just those fields
+	if fields := requestedFields(message.Caption); fields != nil {
+		logger.Info("Caption requests specific fields", "fields", fields)
+
+		start := time.Now()
+		prompt := withRequestedFields(buildPrompt(invoiceExtractionPrompt, settings), fields)
+		invoice, usage, err := cachedInvoiceExtraction(ctx, fileHash, imageURL, prompt, model)
+		recordExtraction("invoice", err)
+		if err != nil {
+			replyError(ctx, message.Chat.ID, message.MessageID, openAIErrorMessage(err, t("image.failed", lang), lang), fmt.Errorf("extracting requested fields: %v", err))
+			return
+		}
+
+		logger.Info("Requested fields extracted", "duration_ms", time.Since(start).Milliseconds())
+		recordChatUsage(message.Chat.ID, usage)
+
+		// Partial invoices aren't stored, so they don't show up in /export or duplicate checks
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, formatInvoice(invoice, settings, lang)+usageFooter(usage, lang))
+		return
+	}
+
+	// Extract structured invoice fields using OpenAI Vision API
+	start := time.Now()
+	invoice, usage, err := cachedInvoiceExtraction(ctx, fileHash, imageURL, withUserNote(buildPrompt(invoiceExtractionPrompt, settings), message.Caption), model)
+	recordExtraction("invoice", err)
+	if errors.Is(err, errResponseTruncated) {
+		replyError(ctx, message.Chat.ID, message.MessageID, t("image.too_long", lang), fmt.Errorf("extracting invoice fields: %v", err))
+		return
+	}
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, openAIErrorMessage(err, t("image.failed", lang), lang), fmt.Errorf("extracting invoice fields: %v", err))
+		return
+	}
+
+	logger.Info("Invoice extracted",
+		"vendor", invoice.Vendor,
+		"invoice_number", invoice.InvoiceNumber,
+		"total", invoice.Total.String(),
+		"duration_ms", time.Since(start).Milliseconds())
+	replyWithInvoice(ctx, message, invoice, usage, fileHash)
+}
+
+// replyWithInvoice stores an extracted invoice and sends it with the confirm/fix buttons
+func replyWithInvoice(ctx context.Context, message TelegramMessage, invoice *Invoice, usage Usage, fileHash string) {
+	recordChatUsage(message.Chat.ID, usage)
+	lang := languageFrom(ctx)
+
+	// Check for an earlier copy before this one is stored
+	warning := duplicateWarning(message.Chat.ID, message.MessageID, invoice, fileHash, lang)
+
+	// Keep the invoice so the buttons under the reply can confirm or correct it
+	id := saveInvoice(message.Chat.ID, message.MessageID, invoice, fileHash)
+
+	// Send response back to Telegram
+	responseText := formatInvoice(invoice, getChatSettings(ctx, message.Chat.ID), lang) + warning + usageFooter(usage, lang)
+	loggerFrom(ctx).Info("Sending response to Telegram", "invoice_id", id)
+	sendTelegramMessageWithKeyboard(ctx, message.Chat.ID, message.MessageID, responseText, invoiceKeyboard(id, lang))
 }
 
 // Handle local image testing endpoint
 func handleTestImage(c *gin.Context) {
+	ctx := withCorrelationID(c.Request.Context(), newCorrelationID())
+	logger := loggerFrom(ctx)
+
+	// Safe mode forbids sending images to OpenAI
+	if safeMode {
+		c.JSON(403, gin.H{"error": "Image OCR is disabled by policy"})
+		return
+	}
+
 	// Get the uploaded image file
 	file, err := c.FormFile("image")
 	if err != nil {
-		log.Printf("Error getting uploaded file: %v", err)
+		logger.Error("Error getting uploaded file", "error", err)
 		c.JSON(400, gin.H{"error": "No image file uploaded"})
 		return
 	}
 
+	// Check the upload size
+	if file.Size > maxFileSizeBytes {
+		logger.Warn("Rejected upload: file too large", "filename", file.Filename, "file_size", file.Size, "max_file_size", maxFileSizeBytes)
+		c.JSON(413, gin.H{"error": fileTooLargeMessage(file.Size, defaultLanguage)})
+		return
+	}
+
 	// Check if it's a supported image format
 	contentType := file.Header.Get("Content-Type")
 	if contentType != "image/jpeg" && contentType != "image/jpg" && contentType != "image/png" {
@@ -226,7 +793,7 @@ func handleTestImage(c *gin.Context) {
 	// Open the uploaded file
 	src, err := file.Open()
 	if err != nil {
-		log.Printf("Error opening uploaded file: %v", err)
+		logger.Error("Error opening uploaded file", "error", err)
 		c.JSON(500, gin.H{"error": "Failed to open uploaded file"})
 		return
 	}
@@ -235,30 +802,59 @@ func handleTestImage(c *gin.Context) {
 	// Read image content into memory
 	imageContent, err := io.ReadAll(src)
 	if err != nil {
-		log.Printf("Error reading image content: %v", err)
+		logger.Error("Error reading image content", "error", err)
 		c.JSON(500, gin.H{"error": "Failed to read image content"})
 		return
 	}
 
 	// Convert to base64 and send to OpenAI
 	base64Image := fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(imageContent))
-	extractedData, err := extractTextFromImageBase64(base64Image)
+
+	if dryRun {
+		c.JSON(200, gin.H{
+			"success":      true,
+			"dry_run":      true,
+			"filename":     file.Filename,
+			"content_type": contentType,
+			"size":         len(imageContent),
+		})
+		return
+	}
+
+	// Refuse flagged content before extraction
+	if moderationEnabled {
+		flagged, categories, err := moderateImage(ctx, base64Image)
+		if err != nil {
+			logger.Error("Error running moderation check", "error", err)
+			c.JSON(500, gin.H{"error": "Failed to run moderation check", "correlation_id": correlationID(ctx)})
+			return
+		}
+		if flagged {
+			logger.Warn("Uploaded image flagged by moderation", "filename", file.Filename, "categories", categories)
+			c.JSON(400, gin.H{"error": moderationRefusal(defaultLanguage)})
+			return
+		}
+	}
+
+	extractedData, usage, err := extractTextFromImageBase64(ctx, base64Image)
+	recordExtraction("text", err)
 	if err != nil {
-		log.Printf("Error extracting text from image: %v", err)
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to extract text from image: %v", err)})
+		logger.Error("Error extracting text from image", "error", err)
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to extract text from image: %v", err), "correlation_id": correlationID(ctx)})
 		return
 	}
 
 	// Get chat ID from environment
 	chatIDStr := os.Getenv("TELEGRAM_CHAT_ID")
 	if chatIDStr == "" {
-		log.Println("Warning: TELEGRAM_CHAT_ID not set, skipping Telegram notification")
+		logger.Warn("TELEGRAM_CHAT_ID not set, skipping Telegram notification")
 		c.JSON(200, gin.H{
 			"success":        true,
 			"message":        "Image processed successfully!",
 			"extracted_data": extractedData,
 			"filename":       file.Filename,
 			"size":           len(imageContent),
+			"usage":          usage,
 		})
 		return
 	}
@@ -266,22 +862,22 @@ func handleTestImage(c *gin.Context) {
 	// Parse chat ID
 	var chatID int64
 	if _, err := fmt.Sscanf(chatIDStr, "%d", &chatID); err != nil {
-		log.Printf("Error parsing chat ID: %v", err)
+		logger.Error("Error parsing chat ID", "error", err)
 		c.JSON(500, gin.H{"error": "Invalid TELEGRAM_CHAT_ID format"})
 		return
 	}
 
 	// Send the original image to Telegram
-	err = sendImageToTelegram(chatID, imageContent, fmt.Sprintf("Original Image: %s", file.Filename))
+	err = sendImageToTelegram(ctx, chatID, imageContent, fmt.Sprintf("Original Image: %s", file.Filename))
 	if err != nil {
-		log.Printf("Error sending image to Telegram: %v", err)
+		logger.Error("Error sending image to Telegram", "chat_id", chatID, "error", err)
 	}
 
 	// Send extracted data to Telegram
-	responseText := fmt.Sprintf("🔍 **Extracted text from image (%s):**\n\n%s", file.Filename, extractedData)
-	err = sendTelegramMessage(chatID, responseText)
+	responseText := formatImageReply(extractedData, file.Filename, defaultLanguage) + usageFooter(usage, defaultLanguage)
+	err = sendTelegramMessage(ctx, chatID, 0, responseText)
 	if err != nil {
-		log.Printf("Error sending message to Telegram: %v", err)
+		logger.Error("Error sending message to Telegram", "chat_id", chatID, "error", err)
 	}
 
 	// Return success response
@@ -292,14 +888,104 @@ func handleTestImage(c *gin.Context) {
 		"filename":       file.Filename,
 		"size":           len(imageContent),
 		"chat_id":        chatID,
+		"usage":          usage,
 	})
 }
 
-func downloadImage(fileID string) (string, error) {
+// fileTooLargeMessage tells the user a file exceeds maxFileSizeBytes
+func fileTooLargeMessage(size int64, lang string) string {
+	const mb = 1024 * 1024
+	return t("file.too_large", lang, float64(size)/mb, float64(maxFileSizeBytes)/mb)
+}
+
+// parseBaseURL checks an API base URL is absolute http(s) and drops a trailing slash
+func parseBaseURL(base string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(base))
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("expected an absolute http(s) URL")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("base URL must not have a query or fragment")
+	}
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// usageFooter is appended to replies when SHOW_USAGE is set
+func usageFooter(usage Usage, lang string) string {
+	if !showUsage || usage.TotalTokens == 0 {
+		return ""
+	}
+	return "\n\n" + t("usage.tokens", lang, usage.TotalTokens)
+}
+
+// The Bot API refuses to serve files larger than this through getFile
+const telegramDownloadLimit = 20 * 1024 * 1024
+
+var (
+	// errTelegramFileTooBig is returned when getFile refuses a file over telegramDownloadLimit
+	errTelegramFileTooBig = errors.New("file is too big to download via the Bot API")
+
+	// errFileLinkExpired is returned when a file path from getFile no longer resolves
+	errFileLinkExpired = errors.New("file link expired")
+
+	// errTelegramFileUnavailable is returned when getFile no longer knows the file
+	errTelegramFileUnavailable = errors.New("file is no longer available on Telegram")
+)
+
+// telegramFileSizeError returns the reply for a Telegram file we shouldn't try
+// to download, or "" if its reported size is fine
+func telegramFileSizeError(size int64, lang string) string {
+	if size > telegramDownloadLimit {
+		return t("file.telegram_too_large", lang)
+	}
+	if size > maxFileSizeBytes {
+		return fileTooLargeMessage(size, lang)
+	}
+	return ""
+}
+
+// downloadErrorMessage picks the reply for a failed download
+func downloadErrorMessage(err error, fallback, lang string) string {
+	switch {
+	case errors.Is(err, errTelegramFileTooBig):
+		return t("file.telegram_too_large", lang)
+	case errors.Is(err, errTelegramFileUnavailable), errors.Is(err, errFileLinkExpired):
+		return t("download.unavailable", lang)
+	}
+	return fallback
+}
+
+// downloadTelegramFile fetches a file's bytes. File paths from getFile expire,
+// so a 404 gets one retry with a fresh path.
+func downloadTelegramFile(ctx context.Context, fileID string) ([]byte, error) {
+	fileURL, err := downloadImage(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := downloadFile(ctx, fileURL)
+	if errors.Is(err, errFileLinkExpired) {
+		loggerFrom(ctx).Info("File link expired, fetching a fresh one", "file_id", fileID)
+		if fileURL, err = downloadImage(ctx, fileID); err != nil {
+			return nil, err
+		}
+		content, err = downloadFile(ctx, fileURL)
+	}
+	return content, err
+}
+
+func downloadImage(ctx context.Context, fileID string) (string, error) {
 	// Get file info from Telegram
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/getFile?file_id=%s", telegramBotToken, fileID)
+	url := fmt.Sprintf("%s/bot%s/getFile?file_id=%s", telegramAPIBase, telegramBotToken, fileID)
 
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to get file info: %v", err)
 	}
@@ -312,30 +998,123 @@ func downloadImage(fileID string) (string, error) {
 
 	var fileResponse TelegramGetFileResponse
 	if err := json.Unmarshal(body, &fileResponse); err != nil {
-		return "", fmt.Errorf("failed to parse file response: %v", err)
+		// e.g. an HTML error page from a proxy in front of the Bot API
+		return "", fmt.Errorf("failed to parse file response (status %d): %v", resp.StatusCode, err)
 	}
 
 	if !fileResponse.OK {
-		return "", fmt.Errorf("telegram API error: file not found")
+		switch {
+		case strings.Contains(fileResponse.Description, "file is too big"):
+			return "", errTelegramFileTooBig
+		case strings.Contains(fileResponse.Description, "wrong file_id"), strings.Contains(fileResponse.Description, "file not found"):
+			return "", fmt.Errorf("%w: %d %s", errTelegramFileUnavailable, fileResponse.ErrorCode, fileResponse.Description)
+		case fileResponse.Description == "":
+			return "", fmt.Errorf("telegram API error: status %d without a description", resp.StatusCode)
+		}
+		return "", fmt.Errorf("telegram API error: %d %s", fileResponse.ErrorCode, fileResponse.Description)
+	}
+	if fileResponse.Result.FilePath == "" {
+		return "", fmt.Errorf("telegram API returned no file path for %s", fileID)
 	}
 
-	// Construct the public URL for the image
-	imageURL := fmt.Sprintf("https://api.telegram.org/file/bot%s/%s", telegramBotToken, fileResponse.Result.FilePath)
+	// Construct the download URL for the image. It embeds the bot token,
+	// so it must never leave this process; OpenAI gets a data URL instead.
+	imageURL := fmt.Sprintf("%s/file/bot%s/%s", telegramAPIBase, telegramBotToken, fileResponse.Result.FilePath)
 
 	return imageURL, nil
 }
 
-func extractTextFromImage(imageURL string) (string, error) {
+// downloadFile fetches a Telegram file, refusing anything over maxFileSizeBytes
+func downloadFile(ctx context.Context, fileURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, errFileLinkExpired
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("failed to download file: status %d", resp.StatusCode)
+	}
+
+	// Telegram's reported size can be missing, so cap what we read as well
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSizeBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %v", err)
+	}
+	if int64(len(content)) > maxFileSizeBytes {
+		return nil, fmt.Errorf("file exceeds %d bytes", maxFileSizeBytes)
+	}
+
+	return content, nil
+}
+
+// imageDataURL encodes image bytes as a data URL OpenAI accepts in place of an image URL
+func imageDataURL(imageContent []byte) string {
+	contentType := http.DetectContentType(imageContent)
+	return fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(imageContent))
+}
+
+// isChatAdmin reports whether the user is an administrator of the chat.
+// ADMIN_USER_IDS are admins everywhere. In private chats the user counts as the
+// admin only while SAFE_MODE is off, so the global policy can't be switched
+// off one chat at a time.
+func isChatAdmin(ctx context.Context, chatID, userID int64, chatType string) (bool, error) {
+	if adminUserIDs[userID] {
+		return true, nil
+	}
+	if chatType == "private" {
+		return !safeMode, nil
+	}
+
+	url := fmt.Sprintf("%s/bot%s/getChatMember?chat_id=%d&user_id=%d", telegramAPIBase, telegramBotToken, chatID, userID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to get chat member: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var memberResponse TelegramGetChatMemberResponse
+	if err := json.Unmarshal(body, &memberResponse); err != nil {
+		return false, fmt.Errorf("failed to parse chat member response: %v", err)
+	}
+
+	if !memberResponse.OK {
+		return false, fmt.Errorf("telegram API error: %s", string(body))
+	}
+
+	status := memberResponse.Result.Status
+	return status == "creator" || status == "administrator", nil
+}
+
+func extractTextFromImage(ctx context.Context, imageURL, prompt, model string) (string, Usage, error) {
 	// Prepare OpenAI request
 	request := OpenAIRequest{
-		Model: "gpt-4o-mini",
+		Model: model,
 		Messages: []Message{
 			{
 				Role: "user",
 				Content: []Content{
 					{
 						Type: "text",
-						Text: "Extract any text visible in this image, including VIN numbers, license plates, or any other readable text. If you find multiple pieces of text, list them clearly.",
+						Text: prompt,
 					},
 					{
 						Type: "image_url",
@@ -348,60 +1127,54 @@ func extractTextFromImage(imageURL string) (string, error) {
 		},
 	}
 
-	// Convert request to JSON
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
-	}
-
-	// Make request to OpenAI
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+openAIAPIKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to make request: %v", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
-	}
-
-	var openAIResponse OpenAIResponse
-	if err := json.Unmarshal(body, &openAIResponse); err != nil {
-		return "", fmt.Errorf("failed to parse OpenAI response: %v", err)
-	}
+	content, usage, err := callOpenAI(ctx, request)
+	return withTruncationNote(content, usage, err, languageFrom(ctx))
+}
 
-	if len(openAIResponse.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
+func extractTextFromImageBase64(ctx context.Context, base64Image string) (string, Usage, error) {
+	request := OpenAIRequest{
+		Model: openAIModel,
+		Messages: []Message{
+			{
+				Role: "user",
+				Content: []Content{
+					{
+						Type: "text",
+						Text: extractionPrompt,
+					},
+					{
+						Type: "image_url",
+						ImageURL: &ImageURL{
+							URL: base64Image,
+						},
+					},
+				},
+			},
+		},
 	}
 
-	return openAIResponse.Choices[0].Message.Content, nil
+	content, usage, err := callOpenAI(ctx, request)
+	return withTruncationNote(content, usage, err, languageFrom(ctx))
 }
 
-func extractTextFromImageBase64(base64Image string) (string, error) {
+// extractTotalFromImage is a cheap fast path that only asks for the grand total
+func extractTotalFromImage(ctx context.Context, imageURL, prompt, model string) (string, Usage, error) {
 	request := OpenAIRequest{
-		Model: "gpt-4o-mini",
+		Model:     model,
+		MaxTokens: 30,
 		Messages: []Message{
 			{
 				Role: "user",
 				Content: []Content{
 					{
 						Type: "text",
-						Text: "Extract any text visible in this image, including VIN numbers, license plates, or any other readable text. If you find multiple pieces of text, list them clearly.",
+						Text: prompt,
 					},
 					{
 						Type: "image_url",
 						ImageURL: &ImageURL{
-							URL: base64Image,
+							URL:    imageURL,
+							Detail: "low",
 						},
 					},
 				},
@@ -409,60 +1182,189 @@ func extractTextFromImageBase64(base64Image string) (string, error) {
 		},
 	}
 
+	return callOpenAI(ctx, request)
+}
+
+// Output token limit for OpenAI requests (OPENAI_MAX_TOKENS). A truncated
+// response is retried once with double the limit, up to openAIMaxTokensCap.
+var openAIMaxTokens = 4096
+
+const openAIMaxTokensCap = 16384
+
+// Sampling settings sent with every chat completion (OPENAI_TEMPERATURE, OPENAI_SEED).
+// A nil value is left out of the request.
+var (
+	defaultTemperature = 0.0
+
+	openAITemperature = &defaultTemperature
+	openAISeed        *int64
+)
+
+// System message sent ahead of every extraction request (OPENAI_SYSTEM_PROMPT, "off" disables)
+var systemPrompt = "You are an OCR assistant. Output only the extracted content, with no preamble or commentary."
+
+// errResponseTruncated is returned along with the partial content when the
+// model hit max_tokens even after the retry
+var errResponseTruncated = errors.New("response truncated at max_tokens")
+
+// withTruncationNote keeps a truncated plain-text result and tells the user, in lang, that it's incomplete
+func withTruncationNote(content string, usage Usage, err error, lang string) (string, Usage, error) {
+	if errors.Is(err, errResponseTruncated) {
+		return content + "\n\n" + t("truncated", lang), usage, nil
+	}
+	return content, usage, err
+}
+
+// callOpenAI sends a chat completion request and returns the first choice's
+// content along with the tokens it used. Content cut off at max_tokens comes
+// back with errResponseTruncated.
+func callOpenAI(ctx context.Context, request OpenAIRequest) (string, Usage, error) {
+	if request.MaxTokens == 0 {
+		request.MaxTokens = openAIMaxTokens
+	}
+	request.Temperature = openAITemperature
+	request.Seed = openAISeed
+	if systemPrompt != "" {
+		system := Message{Role: "system", Content: []Content{{Type: "text", Text: systemPrompt}}}
+		request.Messages = append([]Message{system}, request.Messages...)
+	}
+
+	content, finishReason, usage, err := sendChatCompletion(ctx, request)
+	if err != nil || finishReason != "length" {
+		return content, usage, err
+	}
+
+	// The model ran out of tokens, e.g. on a very long invoice. Retry once with
+	// more room before giving up on a complete answer.
+	if request.MaxTokens < openAIMaxTokensCap {
+		request.MaxTokens = min(request.MaxTokens*2, openAIMaxTokensCap)
+		loggerFrom(ctx).Warn("OpenAI response truncated, retrying with a larger max_tokens", "max_tokens", request.MaxTokens)
+
+		var retryUsage Usage
+		content, finishReason, retryUsage, err = sendChatCompletion(ctx, request)
+		usage.add(retryUsage)
+		if err != nil || finishReason != "length" {
+			return content, usage, err
+		}
+	}
+
+	loggerFrom(ctx).Warn("OpenAI response truncated", "max_tokens", request.MaxTokens)
+	return content, usage, errResponseTruncated
+}
+
+// sendChatCompletion makes one chat completion request and returns the first
+// choice's content and finish reason
+func sendChatCompletion(ctx context.Context, request OpenAIRequest) (string, string, Usage, error) {
 	// Convert request to JSON
 	jsonData, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %v", err)
+		return "", "", Usage{}, fmt.Errorf("failed to marshal request: %v", err)
 	}
 
 	// Make request to OpenAI
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+openAIAPIKey)
+	start := time.Now()
+	resp, err := doOpenAIRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", openAIAPIBase+"/v1/chat/completions", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to make request: %v", err)
+		return "", "", Usage{}, fmt.Errorf("failed to make request: %v", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return "", "", Usage{}, fmt.Errorf("failed to read response: %v", err)
+	}
+	dumpOpenAIExchange(ctx, request, resp.StatusCode, body, time.Since(start))
+
+	if resp.StatusCode != 200 {
+		return "", "", Usage{}, parseOpenAIError(resp.StatusCode, body)
 	}
 
 	var openAIResponse OpenAIResponse
 	if err := json.Unmarshal(body, &openAIResponse); err != nil {
-		return "", fmt.Errorf("failed to parse OpenAI response: %v", err)
+		return "", "", Usage{}, fmt.Errorf("failed to parse OpenAI response: %v", err)
 	}
 
+	// Logged for every call so costs can be reconciled against the OpenAI bill
+	usage := openAIResponse.Usage
+	loggerFrom(ctx).Info("OpenAI usage",
+		"model", request.Model,
+		"prompt_tokens", usage.PromptTokens,
+		"completion_tokens", usage.CompletionTokens,
+		"total_tokens", usage.TotalTokens)
+
 	if len(openAIResponse.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
+		return "", "", usage, fmt.Errorf("no response from OpenAI")
 	}
 
-	return openAIResponse.Choices[0].Message.Content, nil
+	choice := openAIResponse.Choices[0]
+	return choice.Message.Content, choice.FinishReason, usage, nil
 }
 
-func sendTelegramMessage(chatID int64, text string) error {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", telegramBotToken)
+// sendTelegramMessage sends text to a chat, split into several messages if it's
+// longer than Telegram allows. A non-zero replyToMessageID threads the answer
+// under that message, so in a busy group it's clear which upload it belongs to.
+func sendTelegramMessage(ctx context.Context, chatID, replyToMessageID int64, text string) error {
+	return sendTelegramMessageWithKeyboard(ctx, chatID, replyToMessageID, text, nil)
+}
+
+// sendTelegramMessageWithKeyboard sends text with inline keyboard buttons under
+// it. Long text is split; the first message is the reply and the keyboard goes
+// on the last one.
+func sendTelegramMessageWithKeyboard(ctx context.Context, chatID, replyToMessageID int64, text string, keyboard *InlineKeyboardMarkup) error {
+	chunks := splitMessage(text, telegramMaxMessageLength)
+	for i, chunk := range chunks {
+		var markup *InlineKeyboardMarkup
+		if i == len(chunks)-1 {
+			markup = keyboard
+		}
+		replyTo := replyToMessageID
+		if i > 0 {
+			replyTo = 0
+		}
+		if err := sendTelegramMessageChunk(ctx, chatID, replyTo, chunk, markup); err != nil {
+			return fmt.Errorf("failed to send part %d of %d: %v", i+1, len(chunks), err)
+		}
+	}
+	return nil
+}
+
+func sendTelegramMessageChunk(ctx context.Context, chatID, replyToMessageID int64, text string, keyboard *InlineKeyboardMarkup) error {
+	url := fmt.Sprintf("%s/bot%s/sendMessage", telegramAPIBase, telegramBotToken)
 
 	payload := map[string]interface{}{
 		"chat_id":    chatID,
 		"text":       text,
 		"parse_mode": "Markdown",
 	}
+	if keyboard != nil {
+		payload["reply_markup"] = keyboard
+	}
+	if replyToMessageID != 0 {
+		payload["reply_to_message_id"] = replyToMessageID
+	}
 
 	jsonData, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %v", err)
 	}
 
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
+	resp, err := sender.send(ctx, chatID, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		return httpClient.Do(req)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to send message: %v", err)
 	}
@@ -471,6 +1373,12 @@ func sendTelegramMessage(chatID int64, text string) error {
 	// Check response status
 	if resp.StatusCode != 200 {
 		body, _ := io.ReadAll(resp.Body)
+
+		// The user deleted their message before the answer was ready; send it unthreaded
+		if replyToMessageID != 0 && isReplyTargetMissing(resp.StatusCode, body) {
+			slog.Info("Message to reply to was deleted, sending without reply", "chat_id", chatID, "reply_to_message_id", replyToMessageID)
+			return sendTelegramMessageChunk(ctx, chatID, 0, text, keyboard)
+		}
 		return fmt.Errorf("telegram API error: %d - %s", resp.StatusCode, string(body))
 	}
 
@@ -480,12 +1388,68 @@ func sendTelegramMessage(chatID int64, text string) error {
 		return fmt.Errorf("failed to read telegram response: %v", err)
 	}
 
-	log.Printf("Telegram API response: %s", string(body))
+	slog.Debug("Telegram API response", "chat_id", chatID, "body", string(body))
 	return nil
 }
 
-func sendImageToTelegram(chatID int64, imageData []byte, caption string) error {
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendPhoto", telegramBotToken)
+// isReplyTargetMissing reports whether sendMessage failed only because the message
+// it replies to no longer exists
+func isReplyTargetMissing(statusCode int, body []byte) bool {
+	return statusCode == http.StatusBadRequest && strings.Contains(string(body), "message to be replied not found")
+}
+
+// sendDocumentToTelegram uploads a file as a document, e.g. a CSV export
+func sendDocumentToTelegram(ctx context.Context, chatID int64, data []byte, filename, caption string) error {
+	url := fmt.Sprintf("%s/bot%s/sendDocument", telegramAPIBase, telegramBotToken)
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	writer.WriteField("chat_id", fmt.Sprintf("%d", chatID))
+	if caption != "" {
+		writer.WriteField("caption", caption)
+	}
+
+	part, err := writer.CreateFormFile("document", filename)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %v", err)
+	}
+	part.Write(data)
+
+	writer.Close()
+
+	resp, err := sender.send(ctx, chatID, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return httpClient.Do(req)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send document: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("telegram API error: %d - %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+func sendImageToTelegram(ctx context.Context, chatID int64, imageData []byte, caption string) error {
+	url := fmt.Sprintf("%s/bot%s/sendPhoto", telegramAPIBase, telegramBotToken)
+
+	// Images Telegram won't take as a photo are shrunk, or sent as a file if that fails
+	photo, err := photoForTelegram(ctx, imageData)
+	if err != nil {
+		loggerFrom(ctx).Warn("Image too large for a photo, sending it as a document", "bytes", len(imageData), "error", err)
+		return sendDocumentToTelegram(ctx, chatID, imageData, imageFileName(imageData), caption)
+	}
+	imageData = photo
 
 	// Create multipart form data
 	var buf bytes.Buffer
@@ -495,8 +1459,11 @@ func sendImageToTelegram(chatID int64, imageData []byte, caption string) error {
 	writer.WriteField("chat_id", fmt.Sprintf("%d", chatID))
 	writer.WriteField("caption", caption)
 
-	// Add photo
-	part, err := writer.CreateFormFile("photo", "image.jpg")
+	// Add photo with a filename and content type matching the actual image format
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="photo"; filename="%s"`, imageFileName(imageData)))
+	header.Set("Content-Type", http.DetectContentType(imageData))
+	part, err := writer.CreatePart(header)
 	if err != nil {
 		return fmt.Errorf("failed to create form file: %v", err)
 	}
@@ -504,19 +1471,32 @@ func sendImageToTelegram(chatID int64, imageData []byte, caption string) error {
 
 	writer.Close()
 
-	req, err := http.NewRequest("POST", url, &buf)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := sender.send(ctx, chatID, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return httpClient.Do(req)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to send image: %v", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("telegram API error: %d - %s", resp.StatusCode, string(body))
+	}
+
 	return nil
 }
+
+// imageFileName names an image upload after its actual format
+func imageFileName(imageData []byte) string {
+	if http.DetectContentType(imageData) == "image/png" {
+		return "image.png"
+	}
+	return "image.jpg"
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testBotToken = "123:test"
+
+// fakeTelegram is a Bot API stand-in that serves one photo and records every sent message
+type fakeTelegram struct {
+	mu       sync.Mutex
+	messages []string
+
+	// Responses for getFile and the file download; zero values serve the photo
+	getFileBody    string
+	downloadStatus int
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/bot"+testBotToken+"/getFile":
+		body := f.getFileBody
+		if body == "" {
+			body = `{"ok":true,"result":{"file_id":"photo","file_path":"photos/photo.png"}}`
+		}
+		w.Write([]byte(body))
+	case r.URL.Path == "/file/bot"+testBotToken+"/photos/photo.png":
+		if f.downloadStatus != 0 {
+			w.WriteHeader(f.downloadStatus)
+			return
+		}
+		w.Write(testPNG())
+	case r.URL.Path == "/bot"+testBotToken+"/sendMessage":
+		var payload struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		f.mu.Lock()
+		f.messages = append(f.messages, payload.Text)
+		f.mu.Unlock()
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	default:
+		w.Write([]byte(`{"ok":true,"result":true}`))
+	}
+}
+
+func (f *fakeTelegram) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.messages...)
+}
+
+// testPNG is a small valid image
+func testPNG() []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)))
+	return buf.Bytes()
+}
+
+// openAIReply answers every chat completion with content, or with status and body when status isn't 200
+func openAIReply(status int, content string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			w.Write([]byte(content))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"choices": []map[string]any{{
+				"message":       map[string]string{"role": "assistant", "content": content},
+				"finish_reason": "stop",
+			}},
+			"usage": map[string]int{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+		})
+	}
+}
+
+// useFakeAPIs points Telegram and OpenAI calls at test servers and turns off
+// pacing, retries and caching so each test sees exactly its own calls
+func useFakeAPIs(t *testing.T, telegram, openAI http.Handler) {
+	t.Helper()
+	telegramServer := httptest.NewServer(telegram)
+	openAIServer := httptest.NewServer(openAI)
+	t.Cleanup(telegramServer.Close)
+	t.Cleanup(openAIServer.Close)
+
+	saved := []func(){
+		restore(&telegramAPIBase, telegramServer.URL),
+		restore(&openAIAPIBase, openAIServer.URL),
+		restore(&telegramBotToken, testBotToken),
+		restore(&openAIKeys, newKeyPool([]string{"sk-test"})),
+		restore(&sender, newTelegramSender(0, 0)),
+		restore(&openAIMaxRetries, 0),
+		restore(&extractionCacheTTL, 0),
+	}
+	t.Cleanup(func() {
+		for _, undo := range saved {
+			undo()
+		}
+	})
+}
+
+// restore sets *v to value and returns a function that puts the old value back
+func restore[T any](v *T, value T) func() {
+	old := *v
+	*v = value
+	return func() { *v = old }
+}
+
+func photoUpdate(chatID int64) TelegramUpdate {
+	var update TelegramUpdate
+	update.UpdateID = chatID
+	update.Message.MessageID = 1
+	update.Message.Chat = TelegramChat{ID: chatID, Type: "private"}
+	update.Message.From = TelegramUser{ID: chatID, LanguageCode: "en"}
+	update.Message.Photo = []TelegramPhoto{{FileID: "photo", FileSize: 100}}
+	return update
+}
+
+// englishText is t for English, usable where t is the *testing.T
+func englishText(key string) string {
+	return t(key, "en")
+}
+
+func processTestUpdate(update TelegramUpdate) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	processUpdate(ctx, update)
+}
+
+func TestPhotoIsExtractedAndAnswered(t *testing.T) {
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, `{"vendor":"ACME Motors","invoice_number":"INV-7","currency":"USD","total":"12.50"}`))
+
+	processTestUpdate(photoUpdate(9001))
+
+	sent := telegram.sent()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1: %q", len(sent), sent)
+	}
+	for _, want := range []string{"ACME Motors", "INV-7", "12.50"} {
+		if !strings.Contains(sent[0], want) {
+			t.Errorf("reply %q doesn't contain %q", sent[0], want)
+		}
+	}
+}
+
+func TestWebhookPhotoIsAnsweredByAWorker(t *testing.T) {
+	captureLogs(t)
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, `{"vendor":"Webhook Motors","invoice_number":"WH-1","currency":"USD","total":"30.00"}`))
+	defer restore(&seenUpdates, updateDeduper(newUpdateSet()))()
+	cfg := &Config{WebhookSecret: "hook-secret"}
+	useTestWorkers(t, cfg)
+	defer stopWorkers(context.Background())
+
+	gin.SetMode(gin.TestMode)
+	router := newRouter(cfg)
+	body, _ := json.Marshal(photoUpdate(9700))
+	post := func(secret string) int {
+		req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(body))
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if status := post("wrong"); status != http.StatusForbidden {
+		t.Fatalf("wrong secret: status %d, want 403", status)
+	}
+	if status := post("hook-secret"); status != http.StatusOK {
+		t.Fatalf("status %d, want 200", status)
+	}
+
+	// The webhook answers before the update is processed
+	deadline := time.Now().Add(5 * time.Second)
+	for len(telegram.sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := telegram.sent()
+	if len(sent) != 1 || !strings.Contains(sent[0], "Webhook Motors") {
+		t.Fatalf("sent %q, want one reply with the extracted invoice", sent)
+	}
+}
+
+func TestPhotoDownloadFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		telegram *fakeTelegram
+		want     string
+	}{
+		{
+			name:     "download error",
+			telegram: &fakeTelegram{downloadStatus: http.StatusInternalServerError},
+			want:     englishText("download.image_failed"),
+		},
+		{
+			name:     "file gone",
+			telegram: &fakeTelegram{getFileBody: `{"ok":false,"error_code":400,"description":"Bad Request: wrong file_id specified"}`},
+			want:     englishText("download.unavailable"),
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var openAICalled atomic.Bool
+			useFakeAPIs(t, tt.telegram, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				openAICalled.Store(true)
+			}))
+
+			processTestUpdate(photoUpdate(9100 + int64(i)))
+
+			sent := tt.telegram.sent()
+			if len(sent) != 1 || !strings.HasPrefix(sent[0], tt.want) {
+				t.Fatalf("sent %q, want a reply starting with %q", sent, tt.want)
+			}
+			if openAICalled.Load() {
+				t.Error("OpenAI was called for an image that couldn't be downloaded")
+			}
+		})
+	}
+}
+
+func TestPhotoExtractionFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"server error", http.StatusInternalServerError, `{"error":{"message":"boom","type":"server_error"}}`, englishText("image.failed")},
+		{"quota", http.StatusTooManyRequests, `{"error":{"message":"quota","type":"insufficient_quota","code":"insufficient_quota"}}`, englishText("openai.quota")},
+		{"unreadable reply", http.StatusOK, `not json`, englishText("image.failed")},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			telegram := &fakeTelegram{}
+			useFakeAPIs(t, telegram, openAIReply(tt.status, tt.body))
+
+			processTestUpdate(photoUpdate(9200 + int64(i)))
+
+			sent := telegram.sent()
+			if len(sent) != 1 || !strings.HasPrefix(sent[0], tt.want) {
+				t.Fatalf("sent %q, want a reply starting with %q", sent, tt.want)
+			}
+		})
+	}
+}
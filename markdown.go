@@ -0,0 +1,17 @@
+package main
+
+import "strings"
+
+// Characters with special meaning in Telegram's legacy Markdown parse mode
+var markdownEscaper = strings.NewReplacer(
+	`_`, `\_`,
+	`*`, `\*`,
+	"`", "\\`",
+	`[`, `\[`,
+)
+
+// escapeMarkdown makes dynamic text (model output, filenames) safe to embed in
+// a Markdown message, so a stray underscore or asterisk can't break parsing
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"ACME Motors", "ACME Motors"},
+		{"invoice_2024_03.jpg", `invoice\_2024\_03.jpg`},
+		{"*SALE* price", `\*SALE\* price`},
+		{"[draft] copy", `\[draft] copy`},
+		{"code `A-17`", "code \\`A-17\\`"},
+		{"_*`[", "\\_\\*\\`\\["},
+		{"청구서_최종", `청구서\_최종`},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := escapeMarkdown(tt.in); got != tt.want {
+			t.Errorf("escapeMarkdown(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
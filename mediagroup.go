@@ -0,0 +1,364 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long to wait for more photos of the same album before processing it
+const mediaGroupWindow = 2 * time.Second
+
+// How long album photos stay in the state store, well past the window, in case
+// the instance that would have processed them stops first
+const mediaGroupTTL = time.Minute
+
+// Photos sent as one album (same media_group_id) that are processed together
+type mediaGroup struct {
+	chatID        int64
+	correlationID string // from the update that started the album
+	noCache       bool   // a photo's caption had /nocache
+	messages      []TelegramMessage
+}
+
+// bufferedPhoto is one album photo as kept in the state store
+type bufferedPhoto struct {
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	NoCache       bool            `json:"no_cache,omitempty"`
+	Message       TelegramMessage `json:"message"`
+}
+
+// Album photos are buffered in the state store, so instances sharing it see the
+// whole album whichever one each photo's update reached. Only the flush timers
+// are local: each instance times the album from the last photo it received, and
+// the one holding the album's last photo processes it.
+type mediaGroupTimer struct {
+	timer *time.Timer
+	seen  int64 // photos in the store when this instance last buffered one
+}
+
+var (
+	mediaGroupTimersMu sync.Mutex
+	mediaGroupTimers   = make(map[string]*mediaGroupTimer)
+
+	// Albums buffered or being processed, which run on their timer rather than
+	// on a worker; stopWorkers waits for them
+	mediaGroupsWG sync.WaitGroup
+)
+
+// mediaGroupKey prefixes the album's keys: KEY:count numbers its photos,
+// KEY:N holds photo N and KEY:claimed picks the instance that processes it
+func mediaGroupKey(chatID int64, mediaGroupID string) string {
+	return fmt.Sprintf("mediagroup:%d:%s", chatID, mediaGroupID)
+}
+
+// bufferMediaGroupMessage adds the message to its album and restarts the flush timer.
+// Telegram delivers each album photo in a separate update, so the album is only
+// processed once no new photo has arrived for mediaGroupWindow. A photo the store
+// can't take is processed on its own rather than lost.
+func bufferMediaGroupMessage(ctx context.Context, message TelegramMessage) {
+	logger := loggerFrom(ctx)
+	key := mediaGroupKey(message.Chat.ID, message.MediaGroupID)
+	photo := bufferedPhoto{CorrelationID: correlationID(ctx), NoCache: cachedResultsSkipped(ctx), Message: message}
+
+	n, err := storeMediaGroupPhoto(ctx, key, photo)
+	if err != nil {
+		logger.Error("Error buffering media group photo, processing it alone", "media_group_id", message.MediaGroupID, "error", err)
+		processMediaGroup(&mediaGroup{chatID: message.Chat.ID, correlationID: photo.CorrelationID, noCache: photo.NoCache, messages: []TelegramMessage{message}})
+		return
+	}
+
+	mediaGroupTimersMu.Lock()
+	defer mediaGroupTimersMu.Unlock()
+
+	local, ok := mediaGroupTimers[key]
+	if !ok {
+		local = &mediaGroupTimer{}
+		mediaGroupsWG.Add(1)
+		local.timer = time.AfterFunc(mediaGroupWindow, func() {
+			flushMediaGroup(key)
+		})
+		mediaGroupTimers[key] = local
+	} else {
+		local.timer.Reset(mediaGroupWindow)
+	}
+	local.seen = max(local.seen, n)
+	logger.Debug("Buffered media group photo", "media_group_id", message.MediaGroupID, "count", n)
+}
+
+// storeMediaGroupPhoto numbers the photo within its album and stores it,
+// returning its number
+func storeMediaGroupPhoto(ctx context.Context, key string, photo bufferedPhoto) (int64, error) {
+	data, err := json.Marshal(photo)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal media group photo: %v", err)
+	}
+	n, err := stateStore.Incr(ctx, key+":count", mediaGroupTTL)
+	if err != nil {
+		return 0, err
+	}
+	if err := stateStore.Set(ctx, fmt.Sprintf("%s:%d", key, n), string(data), mediaGroupTTL); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// flushMediaGroup stops timing the album and processes it, unless another
+// instance has received a later photo and will process it instead
+func flushMediaGroup(key string) {
+	mediaGroupTimersMu.Lock()
+	local, ok := mediaGroupTimers[key]
+	delete(mediaGroupTimers, key)
+	mediaGroupTimersMu.Unlock()
+
+	if !ok {
+		return
+	}
+	defer mediaGroupsWG.Done()
+
+	group, err := claimMediaGroup(context.Background(), key, local.seen)
+	if err != nil {
+		slog.Error("Error reading buffered media group", "media_group", key, "error", err)
+		return
+	}
+	if group != nil {
+		processMediaGroup(group)
+	}
+}
+
+// claimMediaGroup takes the album's photos out of the store if it still has
+// the seen photos this instance last buffered, and no other instance has
+// claimed them first. It returns nil when the album isn't this instance's to process.
+func claimMediaGroup(ctx context.Context, key string, seen int64) (*mediaGroup, error) {
+	value, _, err := stateStore.Get(ctx, key+":count")
+	if err != nil {
+		return nil, err
+	}
+	if count, _ := strconv.ParseInt(value, 10, 64); count != seen {
+		return nil, nil
+	}
+	if claims, err := stateStore.Incr(ctx, key+":claimed", mediaGroupTTL); err != nil || claims > 1 {
+		return nil, err
+	}
+
+	group := &mediaGroup{}
+	keys := []string{key + ":count", key + ":claimed"}
+	for i := int64(1); i <= seen; i++ {
+		photoKey := fmt.Sprintf("%s:%d", key, i)
+		keys = append(keys, photoKey)
+		value, ok, err := stateStore.Get(ctx, photoKey)
+		if err != nil {
+			return nil, err
+		}
+		var photo bufferedPhoto
+		if !ok || json.Unmarshal([]byte(value), &photo) != nil {
+			slog.Warn("Buffered media group photo missing", "media_group", key, "photo", i)
+			continue
+		}
+		if group.correlationID == "" {
+			group.correlationID = photo.CorrelationID
+		}
+		group.chatID = photo.Message.Chat.ID
+		group.noCache = group.noCache || photo.NoCache
+		group.messages = append(group.messages, photo.Message)
+	}
+
+	// A photo arriving after this starts a new album, as it would have in memory
+	for _, k := range keys {
+		if err := stateStore.Delete(ctx, k); err != nil {
+			slog.Warn("Error removing buffered media group", "key", k, "error", err)
+		}
+	}
+	if len(group.messages) == 0 {
+		return nil, nil
+	}
+	return group, nil
+}
+
+// flushMediaGroups processes every album this instance is timing now instead
+// of when its window ends, for shutdown
+func flushMediaGroups() {
+	mediaGroupTimersMu.Lock()
+	keys := make([]string, 0, len(mediaGroupTimers))
+	for key, local := range mediaGroupTimers {
+		local.timer.Stop()
+		keys = append(keys, key)
+	}
+	mediaGroupTimersMu.Unlock()
+
+	for _, key := range keys {
+		go flushMediaGroup(key)
+	}
+}
+
+// processMediaGroup extracts every photo of the album and replies once with the combined result
+func processMediaGroup(group *mediaGroup) {
+	messages := group.messages
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].MessageID < messages[j].MessageID
+	})
+
+	ctx, cancel := newUpdateContext(group.correlationID)
+	defer cancel()
+	logger := loggerFrom(ctx).With("chat_id", group.chatID)
+	ctx = withLogger(ctx, logger)
+	if group.noCache {
+		ctx = withoutCachedResults(ctx)
+	}
+	logger.Info("Processing media group", "photos", len(messages))
+
+	// Albums carry the caption on a single photo
+	var caption string
+	addressed := false
+	for _, message := range messages {
+		addressed = addressed || isAddressedToBot(message)
+		if caption == "" && message.Caption != "" {
+			caption = removeBotMention(message.Caption)
+		}
+	}
+	if !addressed {
+		logger.Debug("Ignoring group album that doesn't mention the bot")
+		return
+	}
+	settings := getChatSettings(ctx, group.chatID)
+	lang := messageLanguage(ctx, messages[0])
+
+	// Safe mode forbids sending images to OpenAI
+	if settings.safeModeEnabled() {
+		logger.Info("Safe mode enabled, skipping media group OCR")
+		sendTelegramMessage(ctx, group.chatID, messages[0].MessageID, t("ocr_disabled", lang))
+		return
+	}
+
+	var b strings.Builder
+	var totalUsage Usage
+	b.WriteString(t("album.title", lang, len(messages)))
+
+	for i, message := range messages {
+		b.WriteString("\n\n" + t("album.image", lang, i+1) + "\n")
+
+		photo := message.Photo[len(message.Photo)-1]
+		if reply := telegramFileSizeError(int64(photo.FileSize), lang); reply != "" {
+			logger.Warn("Rejected media group photo: file too large", "file_id", photo.FileID, "file_size", photo.FileSize, "max_file_size", maxFileSizeBytes)
+			b.WriteString(reply)
+			continue
+		}
+
+		imageCtx := withLogger(ctx, logger.With("image", i+1))
+		content, err := downloadTelegramFile(imageCtx, photo.FileID)
+		if err != nil {
+			logger.Error("Error downloading media group image", "image", i+1, "error", err)
+			b.WriteString(downloadErrorMessage(err, t("album.download_failed", lang), lang))
+			continue
+		}
+		fileHash := contentHash(content)
+		imageURL := prepareForExtraction(imageCtx, content)
+
+		reply, usage := extractAlbumImage(imageCtx, message, imageURL, fileHash, caption, settings, lang)
+		totalUsage.add(usage)
+		b.WriteString(reply)
+	}
+
+	b.WriteString(usageFooter(totalUsage, lang))
+
+	// Threaded under the album's first photo
+	if err := sendTelegramMessage(ctx, group.chatID, messages[0].MessageID, b.String()); err != nil {
+		logger.Error("Error sending media group result", "error", err)
+	}
+}
+
+// extractAlbumImage runs one album photo through the same steps as a single
+// photo: moderation, classification, then the caption rule, requested fields or
+// full invoice extraction. Full invoices are checked for duplicates and stored,
+// so they show up in /export. Returns the photo's part of the album reply.
+func extractAlbumImage(ctx context.Context, message TelegramMessage, imageURL, fileHash, caption string, settings ChatSettings, lang string) (string, Usage) {
+	logger := loggerFrom(ctx)
+	rule := matchCaptionRule(caption)
+	fields := requestedFields(caption)
+
+	if dryRun {
+		kind := "invoice"
+		if rule != nil {
+			kind = "text (caption rule " + escapeMarkdown(rule.Name) + ")"
+		} else if fields != nil {
+			kind = "invoice fields " + escapeMarkdown(strings.Join(fields, ", "))
+		}
+		return dryRunReply(imageURL, kind, openAIModel, lang), Usage{}
+	}
+
+	if moderationEnabled {
+		flagged, categories, err := moderateImage(ctx, imageURL)
+		if err != nil {
+			logger.Error("Error running moderation check on media group image", "error", err)
+			return openAIErrorMessage(err, t("album.process_failed", lang), lang), Usage{}
+		}
+		if flagged {
+			logger.Warn("Media group image flagged by moderation", "categories", categories)
+			return moderationRefusal(lang), Usage{}
+		}
+	}
+
+	// As for single photos, a caption rule skips the check and a failed check doesn't block extraction
+	if classifyImages && rule == nil {
+		kind, usage, err := classifyImage(ctx, imageURL, fileHash, openAIModel)
+		if err != nil {
+			logger.Warn("Image classification failed, extracting anyway", "error", err)
+		} else {
+			logger.Info("Image classified", "classification", kind)
+			recordChatTokens(message.Chat.ID, usage)
+			if kind == imageKindOther {
+				return nonInvoiceReply(lang), Usage{}
+			}
+		}
+	}
+
+	if rule != nil {
+		prompt := withUserNote(buildPrompt(rule.buildPrompt(extractionPrompt), settings), caption)
+		extractedData, usage, err := cachedExtraction(ctx, fileHash, "text", openAIModel, prompt, func() (string, Usage, error) {
+			return extractTextFromImage(ctx, imageURL, prompt, openAIModel)
+		})
+		recordExtraction("text", err)
+		if err != nil {
+			logger.Error("Error extracting text from media group image", "error", err)
+			return openAIErrorMessage(err, t("album.extract_failed", lang), lang), usage
+		}
+		recordChatUsage(message.Chat.ID, usage)
+		if isNoTextAnswer(extractedData) {
+			return t("image.no_text", lang), usage
+		}
+		return escapeMarkdown(extractedData), usage
+	}
+
+	prompt := withUserNote(buildPrompt(invoiceExtractionPrompt, settings), caption)
+	if fields != nil {
+		prompt = withRequestedFields(buildPrompt(invoiceExtractionPrompt, settings), fields)
+	}
+	invoice, usage, err := cachedInvoiceExtraction(ctx, fileHash, imageURL, prompt, openAIModel)
+	recordExtraction("invoice", err)
+	if errors.Is(err, errResponseTruncated) {
+		logger.Error("Media group image too long to extract", "error", err)
+		return t("image.too_long", lang), usage
+	}
+	if err != nil {
+		logger.Error("Error extracting invoice fields from media group image", "error", err)
+		return openAIErrorMessage(err, t("album.extract_failed", lang), lang), usage
+	}
+	recordChatUsage(message.Chat.ID, usage)
+
+	// Partial invoices aren't stored, as for single photos
+	if fields != nil {
+		return formatInvoice(invoice, settings, lang), usage
+	}
+
+	warning := duplicateWarning(message.Chat.ID, message.MessageID, invoice, fileHash, lang)
+	id := saveInvoice(message.Chat.ID, message.MessageID, invoice, fileHash)
+	logger.Info("Media group invoice extracted", "invoice_id", id)
+	return formatInvoice(invoice, settings, lang) + warning, usage
+}
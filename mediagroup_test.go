@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// albumGroup builds a two-photo album for chatID
+func albumGroup(chatID int64, noCache bool) *mediaGroup {
+	group := &mediaGroup{chatID: chatID, noCache: noCache}
+	for i := 1; i <= 2; i++ {
+		message := photoUpdate(chatID).Message
+		message.MessageID = int64(i)
+		message.MediaGroupID = "album"
+		group.messages = append(group.messages, message)
+	}
+	return group
+}
+
+func TestAlbumUsesExtractionCache(t *testing.T) {
+	var calls atomic.Int32
+	reply := openAIReply(http.StatusOK, `{"vendor":"Album Garage","currency":"USD","total":"10.00"}`)
+	useFakeAPIs(t, &fakeTelegram{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		reply(w, r)
+	}))
+	defer restore(&extractionCache, ExtractionCache(newMemoryCache()))()
+	defer restore(&extractionCacheTTL, time.Minute)()
+
+	// Both photos are the same file, so the second one is already cached
+	processMediaGroup(albumGroup(9800, false))
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("%d OpenAI calls for the album, want 1", n)
+	}
+
+	processMediaGroup(albumGroup(9801, false))
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d OpenAI calls after resending the album, want it served from the cache", n)
+	}
+
+	processMediaGroup(albumGroup(9802, true))
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d OpenAI calls after /nocache, want both photos extracted again", n)
+	}
+}
+
+func TestAlbumInvoicesAreStoredAndChecked(t *testing.T) {
+	const chatID = 9810
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, `{"vendor":"Album Garage","invoice_number":"AG-1","currency":"USD","total":"10.00"}`))
+
+	// Both photos carry the same invoice, so the second is flagged as a duplicate of the first
+	processMediaGroup(albumGroup(chatID, false))
+
+	if stored := chatInvoices(chatID); len(stored) != 2 {
+		t.Errorf("%d invoices stored, want both album photos", len(stored))
+	}
+	warning := fmt.Sprintf(englishText("duplicate.warning"), time.Now().Format("2006-01-02"))
+	if sent := telegram.sent(); len(sent) != 1 || strings.Count(sent[0], warning) != 1 {
+		t.Errorf("sent %q, want one reply with one duplicate warning", sent)
+	}
+}
+
+func TestAlbumSkipsNonInvoices(t *testing.T) {
+	const chatID = 9811
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, "other"))
+	defer restore(&classifyImages, true)()
+
+	processMediaGroup(albumGroup(chatID, false))
+
+	if stored := chatInvoices(chatID); len(stored) != 0 {
+		t.Errorf("%d invoices stored from photos that aren't invoices", len(stored))
+	}
+	if sent := telegram.sent(); len(sent) != 1 || strings.Count(sent[0], englishText("classify.not_invoice")) != 2 {
+		t.Errorf("sent %q, want the not-an-invoice reply for both photos", sent)
+	}
+}
+
+func TestAlbumRequestedFieldsAreNotStored(t *testing.T) {
+	const chatID = 9812
+	useFakeAPIs(t, &fakeTelegram{}, openAIReply(http.StatusOK, `{"invoice_number":"AG-2","date":"2026-01-02"}`))
+
+	group := albumGroup(chatID, false)
+	group.messages[0].Caption = "invoice number and date"
+	processMediaGroup(group)
+
+	if stored := chatInvoices(chatID); len(stored) != 0 {
+		t.Errorf("%d partial invoices stored", len(stored))
+	}
+}
+
+func TestAlbumInSafeModeGetsOneRefusal(t *testing.T) {
+	const chatID = 9820
+	telegram := &fakeTelegram{}
+	var openAICalled atomic.Bool
+	useFakeAPIs(t, telegram, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		openAICalled.Store(true)
+	}))
+	defer restore(&safeMode, true)()
+
+	for _, message := range albumGroup(chatID, false).messages {
+		update := photoUpdate(chatID)
+		update.Message = message
+		processTestUpdate(update)
+	}
+	flushMediaGroups()
+	mediaGroupsWG.Wait()
+
+	if sent := telegram.sent(); len(sent) != 1 || sent[0] != englishText("ocr_disabled") {
+		t.Errorf("sent %q, want one safe mode refusal for the album", sent)
+	}
+	if openAICalled.Load() {
+		t.Error("an album photo was sent to OpenAI in safe mode")
+	}
+}
+
+func TestAlbumIsProcessedByTheInstanceWithItsLastPhoto(t *testing.T) {
+	const chatID = 9830
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, `{"vendor":"Split Garage","currency":"USD","total":"10.00"}`))
+	ctx := context.Background()
+	key := mediaGroupKey(chatID, "split")
+
+	var photos []TelegramMessage
+	for i := 1; i <= 3; i++ {
+		message := photoUpdate(chatID).Message
+		message.MessageID = int64(i)
+		message.MediaGroupID = "split"
+		photos = append(photos, message)
+	}
+
+	// Two photos reach this instance, then another instance sharing the store gets the third
+	bufferMediaGroupMessage(ctx, photos[0])
+	bufferMediaGroupMessage(ctx, photos[1])
+	if _, err := storeMediaGroupPhoto(ctx, key, bufferedPhoto{Message: photos[2]}); err != nil {
+		t.Fatal(err)
+	}
+	flushMediaGroups()
+	mediaGroupsWG.Wait()
+	if sent := telegram.sent(); len(sent) != 0 {
+		t.Fatalf("sent %q before the album's last photo was timed out, want nothing", sent)
+	}
+
+	// The other instance's timer takes the whole album
+	group, err := claimMediaGroup(ctx, key, 3)
+	if err != nil || group == nil {
+		t.Fatalf("claimMediaGroup = %v, %v; want the album", group, err)
+	}
+	processMediaGroup(group)
+	title := fmt.Sprintf(englishText("album.title"), 3)
+	if sent := telegram.sent(); len(sent) != 1 || !strings.HasPrefix(sent[0], title) {
+		t.Errorf("sent %q, want one reply for all three photos", sent)
+	}
+	if group, _ := claimMediaGroup(ctx, key, 3); group != nil {
+		t.Error("album claimed a second time")
+	}
+}
+
+func TestRepeatedErrorReplyIsSuppressedAcrossInstances(t *testing.T) {
+	const chatID = 9831
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, "{}"))
+	ctx := withLanguage(context.Background(), "en")
+
+	// The store is all instances share, so the same reply twice is one message wherever it's sent from
+	replyError(ctx, chatID, 1, "Could not read the image", errors.New("first"))
+	replyError(ctx, chatID, 2, "Could not read the image", errors.New("redelivered"))
+	replyError(ctx, chatID, 3, "Could not download the image", errors.New("other"))
+
+	if sent := telegram.sent(); len(sent) != 2 {
+		t.Errorf("sent %q, want the repeated reply suppressed", sent)
+	}
+}
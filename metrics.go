@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus collectors, registered with the default registry on startup and served on /metrics
+var (
+	updatesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "telegram_updates_received_total",
+		Help: "Telegram updates received via webhook or polling.",
+	})
+
+	extractionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "extractions_total",
+		Help: "Image extractions by kind (invoice, text, total) and result (success, failure).",
+	}, []string{"kind", "result"})
+
+	extractionCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "extraction_cache_requests_total",
+		Help: "Extraction cache lookups by kind and result (hit, miss, bypass for /nocache).",
+	}, []string{"kind", "result"})
+
+	openAIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "openai_request_duration_seconds",
+		Help:    "Latency of individual OpenAI API calls, including failed attempts.",
+		Buckets: []float64{0.25, 0.5, 1, 2, 5, 10, 20, 30, 60},
+	}, []string{"status"})
+
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "update_queue_depth",
+		Help: "Updates waiting for a worker.",
+	}, func() float64 { return float64(len(updateQueue)) })
+
+	workersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "update_workers_busy",
+		Help: "Workers currently processing an update.",
+	})
+
+	updatesRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "updates_rejected_total",
+		Help: "Updates dropped because the queue was full.",
+	})
+)
+
+// recordExtraction counts the outcome of one extraction
+func recordExtraction(kind string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	extractionsTotal.WithLabelValues(kind, result).Inc()
+	extractionOutcomes.record(err != nil)
+}
+
+// inFlightMetrics tracks how many HTTP requests are being served right now
+func inFlightMetrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+		c.Next()
+	}
+}
+
+// observeOpenAIRequest records the latency of one OpenAI call, labelled by status code or "error"
+func observeOpenAIRequest(start time.Time, status string) {
+	openAIRequestDuration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// OpenAI moderation API structures
+type ModerationRequest struct {
+	Model string    `json:"model"`
+	Input []Content `json:"input"`
+}
+
+type ModerationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// moderateImage asks OpenAI's moderation endpoint whether the image is
+// inappropriate. Returns whether it was flagged and the flagged categories.
+func moderateImage(ctx context.Context, imageURL string) (bool, []string, error) {
+	request := ModerationRequest{
+		Model: "omni-moderation-latest",
+		Input: []Content{
+			{
+				Type: "image_url",
+				ImageURL: &ImageURL{
+					URL: imageURL,
+				},
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	resp, err := doOpenAIRequest(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", openAIAPIBase+"/v1/moderations", bytes.NewReader(jsonData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %v", err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to make request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return false, nil, parseOpenAIError(resp.StatusCode, body)
+	}
+
+	var moderationResponse ModerationResponse
+	if err := json.Unmarshal(body, &moderationResponse); err != nil {
+		return false, nil, fmt.Errorf("failed to parse moderation response: %v", err)
+	}
+
+	if len(moderationResponse.Results) == 0 {
+		return false, nil, fmt.Errorf("no result from moderation API")
+	}
+
+	result := moderationResponse.Results[0]
+	var categories []string
+	for category, flagged := range result.Categories {
+		if flagged {
+			categories = append(categories, category)
+		}
+	}
+	sort.Strings(categories)
+
+	return result.Flagged, categories, nil
+}
+
+// moderationRefusal is the reply for a flagged image: the operator's message if set, otherwise the built-in one
+func moderationRefusal(lang string) string {
+	if moderationRefusalMessage != "" {
+		return moderationRefusalMessage
+	}
+	return t("moderation.refused", lang)
+}
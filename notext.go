@@ -0,0 +1,48 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Answers longer than this are treated as real text even if they start like a
+// refusal, so a document that happens to say "no text" isn't thrown away
+const maxNoTextAnswerLength = 200
+
+// Ways the model says an image has no text, e.g. "I don't see any text in this
+// image", "There is no readable text." or "The image does not contain any words."
+var noTextPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b(?:can ?not|can't|cannot|could ?not|couldn't|do ?not|don't|unable to|not able to)\s+(?:see|find|detect|identify|read|extract|make out|locate)\b.*\b(?:text|words?|characters?|writing|content)\b`),
+	regexp.MustCompile(`(?i)\b(?:no|not any|isn't any|aren't any)\s+(?:\w+\s+){0,2}(?:text|words?|characters?|writing)\b`),
+	regexp.MustCompile(`(?i)\b(?:does not|doesn't|do not|don't)\s+(?:appear to\s+)?(?:contain|have|include|show)\s+(?:any\s+)?(?:\w+\s+)?(?:text|words?|characters?|writing)\b`),
+	regexp.MustCompile(`(?i)^(?:n/?a|none|null|nil|empty|blank|no_text)$`),
+	regexp.MustCompile(`텍스트.{0,20}(?:없|찾을 수 없)`),
+}
+
+// isNoTextAnswer reports whether a text extraction result is empty, next to
+// empty (only punctuation or a stray character), or the model saying it found
+// no text, in any of the usual phrasings
+func isNoTextAnswer(text string) bool {
+	text = strings.Trim(strings.TrimSpace(text), "\"'`*_.!-–— \n")
+	if len(text) > maxNoTextAnswerLength {
+		return false
+	}
+
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			letters++
+		}
+	}
+	if letters < 2 {
+		return true
+	}
+
+	for _, pattern := range noTextPatterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
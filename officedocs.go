@@ -0,0 +1,315 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Word and Excel invoices are read as text and structured by the model, since
+// OpenAI can't take them as images
+var officeDocumentTypes = map[string]func([]byte) (string, error){
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": docxText,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":       xlsxText,
+}
+
+var officeExtensionTypes = map[string]string{
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// Older binary Office formats we can't parse, so users are asked to re-save them
+var legacyOfficeTypes = map[string]bool{
+	"application/msword":       true,
+	"application/vnd.ms-excel": true,
+}
+
+const (
+	// Longest document text sent to the model; the rest is cut off
+	maxOfficeTextLength = 30000
+
+	// Largest uncompressed XML part read from a document, so a small zip can't expand without limit
+	maxOfficePartBytes = 50 * 1024 * 1024
+)
+
+var errNoDocumentText = errors.New("document contains no text")
+
+// officeDocumentType returns the Word/Excel mime type of a file, or "" if it isn't one
+func officeDocumentType(mimeType, fileName string) string {
+	mimeType = strings.ToLower(mimeType)
+	if _, ok := officeDocumentTypes[mimeType]; ok {
+		return mimeType
+	}
+	return officeExtensionTypes[strings.ToLower(filepath.Ext(fileName))]
+}
+
+// isLegacyOfficeDocument reports whether a file is an old .doc or .xls
+func isLegacyOfficeDocument(mimeType, fileName string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	return legacyOfficeTypes[strings.ToLower(mimeType)] || ext == ".doc" || ext == ".xls"
+}
+
+// processOfficeDocument extracts the text of a DOCX or XLSX file and has the model structure it as an invoice
+func processOfficeDocument(ctx context.Context, message TelegramMessage, mimeType string) {
+	document := message.Document
+	logger := loggerFrom(ctx).With("file_id", document.FileID, "mime_type", mimeType)
+	ctx = withLogger(ctx, logger)
+	lang := languageFrom(ctx)
+
+	// Safe mode forbids sending documents to OpenAI just like images
+	settings := getChatSettings(ctx, message.Chat.ID)
+	if settings.safeModeEnabled() {
+		logger.Info("Safe mode enabled, skipping document extraction")
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("document.extraction_off", lang))
+		return
+	}
+
+	if reply := telegramFileSizeError(int64(document.FileSize), lang); reply != "" {
+		logger.Warn("Rejected document: file too large", "file_size", document.FileSize, "max_file_size", maxFileSizeBytes)
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, reply)
+		return
+	}
+
+	content, err := downloadTelegramFile(ctx, document.FileID)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, downloadErrorMessage(err, t("download.file_failed", lang), lang), fmt.Errorf("downloading document %s: %v", document.FileID, err))
+		return
+	}
+	fileHash := contentHash(content)
+
+	text, err := officeDocumentTypes[mimeType](content)
+	if errors.Is(err, errNoDocumentText) {
+		logger.Info("Document has no text")
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("document.no_text", lang))
+		return
+	}
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, t("document.unreadable", lang), fmt.Errorf("reading %s document: %v", mimeType, err))
+		return
+	}
+	if len(text) > maxOfficeTextLength {
+		logger.Info("Truncating document text", "length", len(text), "max_length", maxOfficeTextLength)
+		text = strings.ToValidUTF8(text[:maxOfficeTextLength], "")
+	}
+	logger.Info("Document text extracted", "length", len(text), "file_hash", fileHash)
+
+	if dryRun {
+		logger.Info("Dry run, skipping OpenAI")
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("dry_run.header", lang)+"\n\n"+t("dry_run.document", lang, len(text))+"\nModel: "+escapeMarkdown(openAIModel))
+		return
+	}
+
+	start := time.Now()
+	prompt := withUserNote(buildPrompt(invoiceExtractionPrompt, settings), message.Caption)
+	invoice, usage, err := cachedInvoice(ctx, fileHash, prompt, openAIModel, func() (*Invoice, Usage, error) {
+		return extractInvoiceFieldsFromText(ctx, text, prompt, openAIModel)
+	})
+	recordExtraction("invoice", err)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, openAIErrorMessage(err, t("document.extraction_failed", lang), lang), fmt.Errorf("extracting invoice fields from document: %v", err))
+		return
+	}
+
+	logger.Info("Invoice extracted",
+		"vendor", invoice.Vendor,
+		"invoice_number", invoice.InvoiceNumber,
+		"total", invoice.Total.String(),
+		"duration_ms", time.Since(start).Milliseconds())
+	replyWithInvoice(ctx, message, invoice, usage, fileHash)
+}
+
+// docxText returns the paragraphs of a Word document, with table cells separated by " | "
+func docxText(content []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open docx: %v", err)
+	}
+
+	part, err := readZipPart(archive, "word/document.xml")
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	tableDepth := 0
+	decoder := xml.NewDecoder(bytes.NewReader(part))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse docx: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				var text string
+				if err := decoder.DecodeElement(&text, &t); err != nil {
+					return "", fmt.Errorf("failed to parse docx: %v", err)
+				}
+				b.WriteString(text)
+			case "tab":
+				b.WriteString("\t")
+			case "br", "cr":
+				b.WriteString("\n")
+			case "tbl":
+				tableDepth++
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p":
+				// Keep each table row on one line
+				if tableDepth > 0 {
+					b.WriteString(" ")
+				} else {
+					b.WriteString("\n")
+				}
+			case "tc":
+				b.WriteString("| ")
+			case "tr":
+				b.WriteString("\n")
+			case "tbl":
+				tableDepth--
+			}
+		}
+	}
+
+	return documentText(b.String())
+}
+
+// xlsxText returns every sheet of a workbook, one row per line with cells separated by " | "
+func xlsxText(content []byte) (string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open xlsx: %v", err)
+	}
+
+	// Most text cells point into the shared strings table
+	var sharedStrings []string
+	if part, err := readZipPart(archive, "xl/sharedStrings.xml"); err == nil {
+		var table struct {
+			Items []struct {
+				Text string `xml:"t"`
+				Runs []struct {
+					Text string `xml:"t"`
+				} `xml:"r"`
+			} `xml:"si"`
+		}
+		if err := xml.Unmarshal(part, &table); err != nil {
+			return "", fmt.Errorf("failed to parse shared strings: %v", err)
+		}
+		for _, item := range table.Items {
+			text := item.Text
+			for _, run := range item.Runs {
+				text += run.Text
+			}
+			sharedStrings = append(sharedStrings, text)
+		}
+	}
+
+	var sheets []string
+	for _, file := range archive.File {
+		if strings.HasPrefix(file.Name, "xl/worksheets/sheet") && strings.HasSuffix(file.Name, ".xml") {
+			sheets = append(sheets, file.Name)
+		}
+	}
+	// sheet2.xml before sheet10.xml
+	sort.Slice(sheets, func(i, j int) bool {
+		if len(sheets[i]) != len(sheets[j]) {
+			return len(sheets[i]) < len(sheets[j])
+		}
+		return sheets[i] < sheets[j]
+	})
+
+	var b strings.Builder
+	for _, name := range sheets {
+		part, err := readZipPart(archive, name)
+		if err != nil {
+			return "", err
+		}
+
+		var sheet struct {
+			Rows []struct {
+				Cells []struct {
+					Type   string `xml:"t,attr"`
+					Value  string `xml:"v"`
+					Inline struct {
+						Text string `xml:"t"`
+					} `xml:"is"`
+				} `xml:"c"`
+			} `xml:"sheetData>row"`
+		}
+		if err := xml.Unmarshal(part, &sheet); err != nil {
+			return "", fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+
+		for _, row := range sheet.Rows {
+			var cells []string
+			for _, cell := range row.Cells {
+				value := cell.Value
+				switch cell.Type {
+				case "s":
+					if i, err := strconv.Atoi(value); err == nil && i >= 0 && i < len(sharedStrings) {
+						value = sharedStrings[i]
+					}
+				case "inlineStr":
+					value = cell.Inline.Text
+				}
+				if value = strings.TrimSpace(value); value != "" {
+					cells = append(cells, value)
+				}
+			}
+			if len(cells) > 0 {
+				b.WriteString(strings.Join(cells, " | "))
+				b.WriteString("\n")
+			}
+		}
+		b.WriteString("\n")
+	}
+
+	return documentText(b.String())
+}
+
+// readZipPart reads one file from an Office document, refusing oversized parts
+func readZipPart(archive *zip.Reader, name string) ([]byte, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxOfficePartBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	if len(data) > maxOfficePartBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxOfficePartBytes)
+	}
+	return data, nil
+}
+
+// documentText drops blank lines and reports documents with no text at all
+func documentText(text string) (string, error) {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), "|")); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		return "", errNoDocumentText
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// OpenAIError is the {"error": {...}} body OpenAI returns with a non-200 status
+type OpenAIError struct {
+	StatusCode int
+	Message    string
+	Type       string
+	Code       string
+}
+
+func (e *OpenAIError) Error() string {
+	return fmt.Sprintf("OpenAI API error %d: %s (type=%s, code=%s)", e.StatusCode, e.Message, e.Type, e.Code)
+}
+
+// parseOpenAIError builds an OpenAIError from a failed response. Bodies that
+// aren't OpenAI's error shape (e.g. a proxy's HTML page) keep only the status.
+func parseOpenAIError(statusCode int, body []byte) *OpenAIError {
+	var response struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    any    `json:"code"` // usually a string, sometimes null or a number
+		} `json:"error"`
+	}
+
+	apiErr := &OpenAIError{StatusCode: statusCode}
+	if err := json.Unmarshal(body, &response); err != nil || response.Error.Message == "" {
+		apiErr.Message = "unexpected response"
+		return apiErr
+	}
+
+	apiErr.Message = response.Error.Message
+	apiErr.Type = response.Error.Type
+	if response.Error.Code != nil {
+		apiErr.Code = fmt.Sprint(response.Error.Code)
+	}
+	return apiErr
+}
+
+// openAIErrorMessage picks the reply for a failed OpenAI call, falling back
+// to the caller's message for errors users can't do anything about
+func openAIErrorMessage(err error, fallback, lang string) string {
+	var apiErr *OpenAIError
+	if !errors.As(err, &apiErr) {
+		return fallback
+	}
+
+	switch {
+	case apiErr.Code == "invalid_api_key" || apiErr.StatusCode == 401:
+		return t("openai.config", lang)
+	case apiErr.Code == "insufficient_quota" || apiErr.Type == "insufficient_quota":
+		return t("openai.quota", lang)
+	case apiErr.Code == "rate_limit_exceeded" || apiErr.StatusCode == 429:
+		return t("openai.rate_limited", lang)
+	}
+	return fallback
+}
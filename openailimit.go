@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Simultaneous OpenAI HTTP calls across all workers, albums and archives
+// (MAX_CONCURRENT_OPENAI), to stay under the account's rate limits; 0 means no limit
+var maxConcurrentOpenAI = 4
+
+// One slot per allowed concurrent call; nil when there's no limit
+var openAISlots = newOpenAISlots(maxConcurrentOpenAI)
+
+var (
+	openAIInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "openai_requests_in_flight",
+		Help: "OpenAI API calls currently in progress.",
+	})
+
+	openAIWaiting = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "openai_requests_waiting",
+		Help: "OpenAI API calls waiting for a free MAX_CONCURRENT_OPENAI slot.",
+	})
+)
+
+func newOpenAISlots(limit int) chan struct{} {
+	if limit <= 0 {
+		return nil
+	}
+	return make(chan struct{}, limit)
+}
+
+// acquireOpenAISlot waits until another OpenAI call may start, so a burst of
+// work queues up here instead of turning into 429s. The returned function
+// frees the slot and is safe to call more than once.
+func acquireOpenAISlot(ctx context.Context) (func(), error) {
+	if openAISlots != nil {
+		select {
+		case openAISlots <- struct{}{}:
+		default:
+			openAIWaiting.Inc()
+			loggerFrom(ctx).Debug("Waiting for a free OpenAI slot", "limit", cap(openAISlots))
+			select {
+			case openAISlots <- struct{}{}:
+				openAIWaiting.Dec()
+			case <-ctx.Done():
+				openAIWaiting.Dec()
+				return nil, fmt.Errorf("waiting for an OpenAI slot: %v", ctx.Err())
+			}
+		}
+	}
+	openAIInFlight.Inc()
+
+	slots := openAISlots
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			openAIInFlight.Dec()
+			if slots != nil {
+				<-slots
+			}
+		})
+	}, nil
+}
+
+// slotBody frees the call's slot once the response body is closed, since the
+// body is still being downloaded after the headers arrive
+type slotBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *slotBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
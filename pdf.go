@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// TrueType font used for /pdf, loaded from PDF_FONT_PATH. The built-in font
+// only covers Latin text, so Korean and other scripts need e.g. NanumGothic.ttf.
+var pdfFont []byte
+
+// Handle /pdf - sends the chat's most recent invoice as a PDF summary
+func handlePDFCommand(ctx context.Context, message TelegramMessage, args string) {
+	lang := languageFrom(ctx)
+	invoices := chatInvoices(message.Chat.ID)
+	if len(invoices) == 0 {
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("pdf.empty", lang))
+		return
+	}
+	stored := invoices[len(invoices)-1]
+
+	data, err := renderInvoicePDF(&stored.Invoice)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, t("pdf.failed", lang), err)
+		return
+	}
+
+	filename := fmt.Sprintf("invoice-%d.pdf", stored.ID)
+	if err := sendDocumentToTelegram(ctx, message.Chat.ID, data, filename, ""); err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, t("pdf.send_failed", lang), err)
+		return
+	}
+
+	loggerFrom(ctx).Info("Sent invoice PDF", "invoice_id", stored.ID)
+}
+
+// renderInvoicePDF lays out the invoice fields, line items and totals on an A4 page
+func renderInvoicePDF(invoice *Invoice) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+
+	// Without a Unicode font, text is mapped to the built-in font's code page
+	family := "Helvetica"
+	text := pdf.UnicodeTranslatorFromDescriptor("")
+	if pdfFont != nil {
+		family = "body"
+		pdf.AddUTF8FontFromBytes(family, "", pdfFont)
+		pdf.AddUTF8FontFromBytes(family, "B", pdfFont)
+		text = func(s string) string { return s }
+	}
+
+	pdf.AddPage()
+
+	pdf.SetFont(family, "B", 18)
+	pdf.CellFormat(0, 10, text("Invoice summary"), "", 1, "L", false, 0, "")
+	pdf.Ln(2)
+
+	pdf.SetFont(family, "", 11)
+	field := func(label, value string) {
+		if value == "" {
+			return
+		}
+		pdf.SetFont(family, "B", 11)
+		pdf.CellFormat(35, 7, text(label), "", 0, "L", false, 0, "")
+		pdf.SetFont(family, "", 11)
+		pdf.MultiCell(0, 7, text(value), "", "L", false)
+	}
+	field("Vendor", invoice.Vendor)
+	field("Invoice number", invoice.InvoiceNumber)
+	if !invoice.Date.IsZero() {
+		field("Date", invoice.Date.Format("2006-01-02"))
+	}
+	field("Currency", invoice.Currency)
+	pdf.Ln(4)
+
+	// Line items table
+	widths := []float64{95, 20, 30, 35}
+	if len(invoice.LineItems) > 0 {
+		pdf.SetFont(family, "B", 10)
+		pdf.SetFillColor(235, 235, 235)
+		for i, header := range []string{"Description", "Qty", "Unit price", "Amount"} {
+			align := "R"
+			if i == 0 {
+				align = "L"
+			}
+			pdf.CellFormat(widths[i], 8, text(header), "1", 0, align, true, 0, "")
+		}
+		pdf.Ln(-1)
+
+		pdf.SetFont(family, "", 10)
+		const lineHeight = 6
+		for _, item := range invoice.LineItems {
+			// Keep a wrapped row on one page: estimate its height before drawing it
+			lines := math.Ceil(pdf.GetStringWidth(text(item.Description)) / (widths[0] - 2))
+			if pdf.GetY()+max(lines, 1)*lineHeight > 282 {
+				pdf.AddPage()
+			}
+
+			x, y := pdf.GetXY()
+			pdf.MultiCell(widths[0], lineHeight, text(item.Description), "1", "L", false)
+			height := pdf.GetY() - y
+			pdf.SetXY(x+widths[0], y)
+			pdf.CellFormat(widths[1], height, item.Quantity.String(), "1", 0, "R", false, 0, "")
+			pdf.CellFormat(widths[2], height, item.UnitPrice.String(), "1", 0, "R", false, 0, "")
+			pdf.CellFormat(widths[3], height, item.Amount.String(), "1", 0, "R", false, 0, "")
+			pdf.SetXY(x, y+height)
+		}
+		pdf.Ln(4)
+	}
+
+	// Totals, right-aligned under the amount column
+	total := func(label string, value *Decimal, style string) {
+		if value == nil {
+			return
+		}
+		pdf.SetFont(family, style, 11)
+		pdf.CellFormat(widths[0]+widths[1]+widths[2], 7, text(label), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 7, text(value.String()+" "+invoice.Currency), "", 1, "R", false, 0, "")
+	}
+	total("Subtotal", invoice.Subtotal, "")
+	total("Tax", invoice.Tax, "")
+	total("Total", invoice.Total, "B")
+
+	if len(invoice.VINs) > 0 {
+		pdf.Ln(6)
+		pdf.SetFont(family, "B", 10)
+		pdf.CellFormat(0, 6, text("VIN"), "", 1, "L", false, 0, "")
+		pdf.SetFont(family, "", 9)
+		for _, vin := range invoice.VINs {
+			status := "checksum doesn't match, may be misread"
+			if validateVIN(vin) {
+				status = "valid checksum"
+			}
+			pdf.CellFormat(0, 5, text(normalizeVIN(vin)+" ("+status+")"), "", 1, "L", false, 0, "")
+		}
+	}
+
+	if invoice.OtherText != "" {
+		pdf.Ln(6)
+		pdf.SetFont(family, "B", 10)
+		pdf.CellFormat(0, 6, text("Other text"), "", 1, "L", false, 0, "")
+		pdf.SetFont(family, "", 9)
+		pdf.MultiCell(0, 5, text(invoice.OtherText), "", "L", false)
+	}
+
+	pdf.Ln(6)
+	pdf.SetFont(family, "", 8)
+	pdf.SetTextColor(120, 120, 120)
+	pdf.CellFormat(0, 5, text("Extracted automatically on "+time.Now().Format("2006-01-02")+". Please check against the original."), "", 1, "L", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to render PDF: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// loadPDFFont reads the PDF_FONT_PATH font once at startup
+func loadPDFFont(path string) ([]byte, error) {
+	font, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font: %v", err)
+	}
+	return font, nil
+}
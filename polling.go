@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// How long each getUpdates call waits for new updates
+const pollTimeoutSeconds = 30
+
+type TelegramGetUpdatesResponse struct {
+	OK          bool             `json:"ok"`
+	Description string           `json:"description"`
+	Result      []TelegramUpdate `json:"result"`
+}
+
+// startPolling fetches updates with getUpdates and runs them through processUpdate,
+// as an alternative to receiving them on the webhook. It returns once ctx is
+// cancelled and the current batch of updates has been processed.
+func startPolling(ctx context.Context) {
+	// getUpdates is refused while a webhook is set
+	if err := deleteWebhook(ctx); err != nil {
+		slog.Warn("Failed to delete webhook before polling", "error", err)
+	}
+
+	// Long poll requests stay open for pollTimeoutSeconds, so they need a longer client timeout
+	client := newHTTPClient((pollTimeoutSeconds + 10) * time.Second)
+
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := getUpdates(ctx, client, offset)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Error("Error polling for updates", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			submitUpdate(update)
+			offset = update.UpdateID + 1
+		}
+	}
+}
+
+func getUpdates(ctx context.Context, client *http.Client, offset int64) ([]TelegramUpdate, error) {
+	url := fmt.Sprintf("%s/bot%s/getUpdates?offset=%d&timeout=%d", telegramAPIBase, telegramBotToken, offset, pollTimeoutSeconds)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get updates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var updatesResponse TelegramGetUpdatesResponse
+	if err := json.Unmarshal(body, &updatesResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse updates response: %v", err)
+	}
+
+	if !updatesResponse.OK {
+		return nil, fmt.Errorf("telegram API error: %s", updatesResponse.Description)
+	}
+
+	return updatesResponse.Result, nil
+}
+
+func deleteWebhook(ctx context.Context) error {
+	url := fmt.Sprintf("%s/bot%s/deleteWebhook", telegramAPIBase, telegramBotToken)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("telegram API error: %d - %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Optional cleanup of photos before extraction (PREPROCESS_IMAGES)
+var preprocessImages bool
+
+// Images whose longest side is larger are downscaled before extraction; 0 keeps
+// the original size (MAX_IMAGE_DIMENSION). Vision reads no better past this,
+// and larger images cost more tokens.
+var maxImageDimension = 2048
+
+// prepareForExtraction returns the data URL sent to OpenAI: the downscaled
+// image, or the cleaned-up one with preprocessing enabled. Either falls back
+// to the original if processing fails.
+func prepareForExtraction(ctx context.Context, content []byte) string {
+	if preprocessImages {
+		processed, err := preprocessImage(ctx, content)
+		if err == nil {
+			return imageDataURL(processed)
+		}
+		loggerFrom(ctx).Warn("Image preprocessing failed, using the original", "error", err)
+	}
+
+	resized, err := downscaleImage(ctx, content)
+	if err != nil {
+		loggerFrom(ctx).Warn("Image downscaling failed, using the original", "error", err)
+		return imageDataURL(content)
+	}
+	return imageDataURL(resized)
+}
+
+// downscaleImage shrinks an image whose longest side exceeds maxImageDimension,
+// keeping its aspect ratio and colors. Smaller images are returned unchanged.
+func downscaleImage(ctx context.Context, content []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image size: %v", err)
+	}
+	width, height, ok := fitWithin(config.Width, config.Height, maxImageDimension)
+	if !ok {
+		return content, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	resized, err := scaleToJPEG(src, width, height, 90)
+	if err != nil {
+		return nil, err
+	}
+
+	loggerFrom(ctx).Info("Downscaled image",
+		"before_width", config.Width, "before_height", config.Height,
+		"after_width", width, "after_height", height,
+		"before_bytes", len(content), "after_bytes", len(resized))
+	return resized, nil
+}
+
+// scaleToJPEG resizes an image to width x height and encodes it as JPEG
+func scaleToJPEG(src image.Image, width, height, quality int) ([]byte, error) {
+	// JPEG has no transparency, so transparent PNG areas become white rather than black
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(resized, resized.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(resized, resized.Bounds(), src, src.Bounds(), draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fitWithin returns the size that brings the longest side down to maxDimension,
+// and false if the image already fits or maxDimension is 0
+func fitWithin(width, height, maxDimension int) (int, int, bool) {
+	longest := max(width, height)
+	if maxDimension <= 0 || longest <= maxDimension {
+		return width, height, false
+	}
+	scale := float64(maxDimension) / float64(longest)
+	return max(int(float64(width)*scale), 1), max(int(float64(height)*scale), 1), true
+}
+
+// sendPhoto refuses photos over 10 MB or whose width and height add up to more than 10000
+const (
+	telegramPhotoMaxBytes     = 10 * 1024 * 1024
+	telegramPhotoMaxDimension = 5000
+)
+
+var errPhotoTooLarge = errors.New("image can't be shrunk under Telegram's photo limit")
+
+// photoForTelegram returns an image sendPhoto accepts. One over the limits is
+// downscaled and recompressed as JPEG, a step smaller each time until it fits.
+func photoForTelegram(ctx context.Context, content []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image size: %v", err)
+	}
+	if len(content) <= telegramPhotoMaxBytes && config.Width+config.Height <= 2*telegramPhotoMaxDimension {
+		return content, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	for maxDimension := min(max(config.Width, config.Height), telegramPhotoMaxDimension); maxDimension >= 256; maxDimension = maxDimension * 3 / 4 {
+		width, height, _ := fitWithin(config.Width, config.Height, maxDimension)
+		photo, err := scaleToJPEG(src, width, height, 85)
+		if err != nil {
+			return nil, err
+		}
+		if len(photo) <= telegramPhotoMaxBytes {
+			loggerFrom(ctx).Info("Shrunk image for Telegram",
+				"before_width", config.Width, "before_height", config.Height,
+				"after_width", width, "after_height", height,
+				"before_bytes", len(content), "after_bytes", len(photo))
+			return photo, nil
+		}
+	}
+	return nil, errPhotoTooLarge
+}
+
+// preprocessImage converts an image to grayscale, stretches its contrast, and
+// downscales it if its longest side exceeds maxImageDimension. Faint receipt
+// photos read better and large photos cost fewer tokens.
+func preprocessImage(ctx context.Context, content []byte) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %v", err)
+	}
+	before := src.Bounds()
+
+	gray := toGrayscale(src)
+	stretchContrast(gray)
+
+	var out image.Image = gray
+	if width, height, ok := fitWithin(before.Dx(), before.Dy(), maxImageDimension); ok {
+		resized := image.NewGray(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(resized, resized.Bounds(), gray, gray.Bounds(), draw.Src, nil)
+		out = resized
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, out, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG: %v", err)
+	}
+
+	after := out.Bounds()
+	loggerFrom(ctx).Info("Preprocessed image",
+		"before_width", before.Dx(), "before_height", before.Dy(),
+		"after_width", after.Dx(), "after_height", after.Dy(),
+		"before_bytes", len(content), "after_bytes", buf.Len())
+	return buf.Bytes(), nil
+}
+
+func toGrayscale(src image.Image) *image.Gray {
+	bounds := src.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x-bounds.Min.X, y-bounds.Min.Y, color.GrayModel.Convert(src.At(x, y)))
+		}
+	}
+	return gray
+}
+
+// stretchContrast maps the 1st–99th percentile of brightness onto the full
+// range, so faded print becomes dark and the paper becomes white
+func stretchContrast(img *image.Gray) {
+	var histogram [256]int
+	for _, v := range img.Pix {
+		histogram[v]++
+	}
+
+	clip := len(img.Pix) / 100
+	low, high := 0, 255
+	for count := 0; low < 255 && count+histogram[low] <= clip; low++ {
+		count += histogram[low]
+	}
+	for count := 0; high > 0 && count+histogram[high] <= clip; high-- {
+		count += histogram[high]
+	}
+	if high <= low {
+		return
+	}
+
+	var lookup [256]uint8
+	for v := range lookup {
+		scaled := (v - low) * 255 / (high - low)
+		lookup[v] = uint8(min(max(scaled, 0), 255))
+	}
+	for i, v := range img.Pix {
+		img.Pix[i] = lookup[v]
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// noisePNG encodes random pixels, which PNG can't compress, so the file is
+// about 3 bytes per pixel
+func noisePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rng.Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestPhotoForTelegramShrinksOversizedImage(t *testing.T) {
+	content := noisePNG(t, 2000, 2000)
+	if len(content) <= telegramPhotoMaxBytes {
+		t.Fatalf("test image is only %d bytes, want it over the %d byte limit", len(content), telegramPhotoMaxBytes)
+	}
+
+	photo, err := photoForTelegram(context.Background(), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(photo) > telegramPhotoMaxBytes {
+		t.Errorf("shrunk photo is %d bytes, over the limit", len(photo))
+	}
+	decoded, format, err := image.Decode(bytes.NewReader(photo))
+	if err != nil {
+		t.Fatalf("shrunk photo doesn't decode: %v", err)
+	}
+	if format != "jpeg" || decoded.Bounds().Dx() < 256 {
+		t.Errorf("got a %s of %v", format, decoded.Bounds())
+	}
+}
+
+func TestPhotoForTelegramFitsDimensions(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 9000, 2000))
+	for x := 0; x < 9000; x += 10 {
+		img.SetGray(x, 1000, color.Gray{Y: 255})
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+
+	photo, err := photoForTelegram(context.Background(), buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(photo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width+config.Height > 2*telegramPhotoMaxDimension {
+		t.Errorf("shrunk photo is %dx%d, still over the dimension limit", config.Width, config.Height)
+	}
+}
+
+func TestPhotoForTelegramKeepsSmallImage(t *testing.T) {
+	content := testPNG()
+	photo, err := photoForTelegram(context.Background(), content)
+	if err != nil || !bytes.Equal(photo, content) {
+		t.Errorf("small image changed: %d bytes, %v", len(photo), err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest webhook body read; Telegram updates are a few kilobytes
+const maxWebhookBodyBytes = 1 << 20
+
+// webhookRecovery catches panics while handling a webhook update, logs the raw
+// update and stack trace, and still answers 200. A 500 would make Telegram
+// redeliver the same poison update forever.
+func webhookRecovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			slog.Warn("Rejected webhook request: body too large", "limit_bytes", tooLarge.Limit, "client_ip", c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return
+		}
+		if err != nil {
+			slog.Error("Error reading webhook body", "error", err)
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		defer func() {
+			if r := recover(); r != nil {
+				slog.Error("Panic while processing webhook", "panic", fmt.Sprint(r), "update", string(body), "stack", string(debug.Stack()))
+				c.AbortWithStatusJSON(http.StatusOK, gin.H{"status": "ok"})
+			}
+		}()
+
+		c.Next()
+	}
+}
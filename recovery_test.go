@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// syncBuffer is a bytes.Buffer that's safe to log into from several goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs sends slog's default logger to a buffer for the rest of the test
+func captureLogs(t *testing.T) *syncBuffer {
+	t.Helper()
+	logs := &syncBuffer{}
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return logs
+}
+
+func TestWebhookRecoveryAnswersPanicWith200(t *testing.T) {
+	logs := captureLogs(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/webhook", webhookRecovery(), func(c *gin.Context) {
+		var update TelegramUpdate
+		c.ShouldBindJSON(&update)
+		// What a handler trusting a malformed update would do
+		_ = update.Message.ReplyToMessage.MessageID
+	})
+
+	body := `{"update_id":777,"message":{"message_id":1,"text":"poison"}}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/webhook", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200 so Telegram doesn't redeliver", w.Code)
+	}
+	logged := logs.String()
+	for _, want := range []string{"Panic while processing webhook", `"poison`, "recovery_test.go"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log doesn't contain %q:\n%s", want, logged)
+		}
+	}
+}
+
+func TestWebhookSurvivesMalformedUpdate(t *testing.T) {
+	captureLogs(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/webhook", webhookRecovery(), handleWebhook)
+
+	for _, body := range []string{`{"update_id":`, `[]`, `{"update_id":"x","message":7}`} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/webhook", strings.NewReader(body)))
+		if w.Code >= 500 {
+			t.Errorf("body %q: status %d", body, w.Code)
+		}
+	}
+}
+
+func TestWebhookRejectsOversizedBody(t *testing.T) {
+	captureLogs(t)
+	defer restore(&updateQueue, make(chan TelegramUpdate, 10))()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/webhook", webhookRecovery(), handleWebhook)
+
+	body := `{"update_id":1,"message":{"text":"` + strings.Repeat("a", maxWebhookBodyBytes) + `"}}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/webhook", strings.NewReader(body)))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status %d, want 413", w.Code)
+	}
+	if n := len(updateQueue); n != 0 {
+		t.Errorf("%d updates queued from an oversized body", n)
+	}
+}
+
+// poisonStore panics when chatID's settings are read, like a bug that only
+// one update's contents trigger
+type poisonStore struct {
+	StateStore
+	chatID int64
+}
+
+func (s poisonStore) Get(ctx context.Context, key string) (string, bool, error) {
+	if key == chatSettingsKey(s.chatID) {
+		panic("poison update")
+	}
+	return s.StateStore.Get(ctx, key)
+}
+
+func TestWorkerSurvivesPoisonUpdate(t *testing.T) {
+	logs := captureLogs(t)
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, `{"vendor":"Survivor Motors","invoice_number":"SV-1","currency":"USD","total":"5.00"}`))
+	defer restore(&stateStore, StateStore(poisonStore{StateStore: newMemoryStateStore(), chatID: 9740}))()
+	useTestWorkers(t, &Config{})
+	defer stopWorkers(context.Background())
+
+	// As many poison updates as workers; the last update is only answered if
+	// the workers outlived them
+	for i := 0; i < workerCount; i++ {
+		poison := photoUpdate(9740)
+		poison.UpdateID += int64(i)
+		submitUpdate(poison)
+	}
+	submitUpdate(photoUpdate(9741))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(telegram.sent()) < workerCount+1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var errorReplies, invoiceReplies int
+	for _, text := range telegram.sent() {
+		switch {
+		case strings.HasPrefix(text, englishText("unexpected_error")):
+			errorReplies++
+		case strings.Contains(text, "Survivor Motors"):
+			invoiceReplies++
+		}
+	}
+	if errorReplies != workerCount || invoiceReplies != 1 {
+		t.Errorf("sent %q, want %d error replies and the invoice", telegram.sent(), workerCount)
+	}
+	if !strings.Contains(logs.String(), "Panic while processing update") {
+		t.Error("panic wasn't logged")
+	}
+}
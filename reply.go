@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The same error reply to a chat within this window is only sent once
+const errorReplyWindow = 10 * time.Second
+
+// errorReplyKey counts how often a chat was sent the same error reply in the
+// state store, so instances sharing it suppress each other's repeats
+func errorReplyKey(chatID int64, text string) string {
+	return fmt.Sprintf("errorreply:%d:%s", chatID, contentHash([]byte(text)))
+}
+
+// replyError logs the real error and sends the user one friendly message,
+// ending with the correlation ID so they can quote it. It's the single place
+// failures are reported to users, so callers should return right after it. A
+// failure reported again shortly after (e.g. a redelivered update failing the
+// same way) doesn't send the message a second time.
+func replyError(ctx context.Context, chatID, replyToMessageID int64, userMsg string, err error) {
+	logger := loggerFrom(ctx)
+	logger.Error(userMsg, "error", err)
+
+	// Past the update's deadline the image isn't to blame, so say what happened instead
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		userMsg = t("timeout", languageFrom(ctx))
+	}
+
+	// Store errors send the reply, since a repeat beats saying nothing
+	n, err := stateStore.Incr(ctx, errorReplyKey(chatID, userMsg), errorReplyWindow)
+	if err != nil {
+		logger.Warn("Error checking for a repeated error reply", "error", err)
+	} else if n > 1 {
+		logger.Info("Suppressed repeated error reply")
+		return
+	}
+	// The reply still goes out after the update's deadline has passed
+	sendTelegramMessage(context.WithoutCancel(ctx), chatID, replyToMessageID, withReference(ctx, userMsg))
+}
+
+// withReference appends the context's correlation ID to a message for the user
+func withReference(ctx context.Context, text string) string {
+	if id := correlationID(ctx); id != "" {
+		return text + "\n\n" + t("reference", languageFrom(ctx), id)
+	}
+	return text
+}
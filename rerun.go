@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// /retry re-runs a chat's last image with a stronger model (RETRY_MODEL, RETRY_CACHE_TTL_SECONDS)
+var (
+	retryModel    = "gpt-4o"
+	retryCacheTTL = 10 * time.Minute
+)
+
+// Last downloaded image per chat, kept so /retry doesn't need a re-upload
+type recentImage struct {
+	message   TelegramMessage
+	imageURL  string
+	totalOnly bool
+	expires   time.Time
+}
+
+var (
+	recentImagesMu sync.Mutex
+	recentImages   = make(map[int64]recentImage)
+)
+
+// rememberImage keeps the downloaded image and what was asked of it for /retry
+func rememberImage(message TelegramMessage, imageURL string, totalOnly bool) {
+	recentImagesMu.Lock()
+	defer recentImagesMu.Unlock()
+
+	now := time.Now()
+	for chatID, image := range recentImages {
+		if now.After(image.expires) {
+			delete(recentImages, chatID)
+		}
+	}
+
+	recentImages[message.Chat.ID] = recentImage{
+		message:   message,
+		imageURL:  imageURL,
+		totalOnly: totalOnly,
+		expires:   now.Add(retryCacheTTL),
+	}
+}
+
+// lastImage returns the chat's last image if it hasn't expired
+func lastImage(chatID int64) (recentImage, bool) {
+	recentImagesMu.Lock()
+	defer recentImagesMu.Unlock()
+
+	image, ok := recentImages[chatID]
+	if !ok || time.Now().After(image.expires) {
+		return recentImage{}, false
+	}
+	return image, true
+}
+
+// Handle /retry
+func handleRetryCommand(ctx context.Context, message TelegramMessage, args string) {
+	lang := languageFrom(ctx)
+	image, ok := lastImage(message.Chat.ID)
+	if !ok {
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("retry.nothing", lang, int(retryCacheTTL.Minutes())))
+		return
+	}
+
+	// Safe mode may have been turned on since the image was sent
+	settings := getChatSettings(ctx, message.Chat.ID)
+	if settings.safeModeEnabled() {
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("ocr_disabled", lang))
+		return
+	}
+
+	logger := loggerFrom(ctx).With("chat_id", message.Chat.ID, "message_id", image.message.MessageID, "model", retryModel)
+	ctx = withLogger(ctx, logger)
+	logger.Info("Retrying extraction")
+
+	sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("retry.retrying", lang, escapeMarkdown(retryModel)))
+	// No file hash, so the retry isn't answered from the cache
+	extractAndReply(ctx, image.message, image.imageURL, "", image.totalOnly, settings, retryModel)
+}
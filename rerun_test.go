@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRetryReplacesStoredInvoice(t *testing.T) {
+	const chatID = 9990
+	var mu sync.Mutex
+	var exported []byte
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/sendDocument") {
+			telegram.ServeHTTP(w, r)
+			return
+		}
+		file, _, err := r.FormFile("document")
+		if err != nil {
+			t.Errorf("reading the exported document: %v", err)
+			return
+		}
+		mu.Lock()
+		exported, _ = io.ReadAll(file)
+		mu.Unlock()
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}), openAIReply(http.StatusOK, `{"vendor":"Retry Garage","invoice_number":"RT-1","currency":"USD","total":"40.00"}`))
+
+	processTestUpdate(photoUpdate(chatID))
+	ctx := withLanguage(context.Background(), "en")
+	for i := 0; i < 2; i++ {
+		handleCommand(ctx, textMessage(chatID, "/retry"))
+	}
+	handleCommand(ctx, textMessage(chatID, "/export"))
+
+	mu.Lock()
+	defer mu.Unlock()
+	rows, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(exported), "\uFEFF"))).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing the export: %v", err)
+	}
+	// The header plus one invoice, however often it was re-run
+	if len(rows) != 2 {
+		t.Errorf("export has %d rows, want 2: %q", len(rows), rows)
+	}
+}
+
+func TestZIPEntriesFromOneMessageAreKeptApart(t *testing.T) {
+	const chatID = 9991
+	first := saveInvoice(chatID, 1, &Invoice{Vendor: "Archive Garage", InvoiceNumber: "A-1"}, "hash-a")
+	second := saveInvoice(chatID, 1, &Invoice{Vendor: "Archive Garage", InvoiceNumber: "A-2"}, "hash-b")
+	if first == second {
+		t.Fatal("two files from one message were stored as one invoice")
+	}
+	if again := saveInvoice(chatID, 1, &Invoice{Vendor: "Archive Garage", InvoiceNumber: "A-2"}, "hash-b"); again != second {
+		t.Errorf("re-saving a file stored invoice %d, want it to replace %d", again, second)
+	}
+	if n := len(chatInvoices(chatID)); n != 2 {
+		t.Errorf("%d invoices stored, want 2", n)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry settings for OpenAI calls (OPENAI_MAX_RETRIES, OPENAI_RETRY_BASE_DELAY_MS)
+var (
+	openAIMaxRetries     = 3
+	openAIRetryBaseDelay = 500 * time.Millisecond
+)
+
+// Longest we'll honor a Retry-After header for
+const maxRetryAfter = 60 * time.Second
+
+func isRetryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// doOpenAIRequest performs an OpenAI API call, retrying network errors and
+// 429/5xx responses with exponential backoff and jitter. newRequest is called
+// for every attempt so the request body can be re-sent. Each attempt is
+// authorized with the next key from openAIKeys; a key that is rejected or out
+// of quota is put on cooldown and the request moves straight to another key.
+// Every attempt waits for a MAX_CONCURRENT_OPENAI slot, which is held until
+// the response body is closed but not during the backoff between attempts.
+func doOpenAIRequest(ctx context.Context, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		keyIndex, key := openAIKeys.pick()
+		req.Header.Set("Authorization", "Bearer "+key)
+
+		release, err := acquireOpenAISlot(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		start := time.Now()
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			release()
+			observeOpenAIRequest(start, "error")
+		} else {
+			resp.Body = &slotBody{ReadCloser: resp.Body, release: release}
+			observeOpenAIRequest(start, strconv.Itoa(resp.StatusCode))
+		}
+
+		if err == nil && isKeyError(resp) {
+			openAIKeys.markUnhealthy(keyIndex)
+			loggerFrom(ctx).Warn("OpenAI API key rejected or out of quota, cooling it down", "key_index", keyIndex, "status", resp.StatusCode, "cooldown_seconds", int(openAIKeyCooldown.Seconds()))
+			if openAIKeys.healthyCount() > 0 {
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				attempt--
+				continue
+			}
+		}
+		if err == nil && !isRetryableStatus(resp.StatusCode) {
+			return resp, nil
+		}
+
+		// Out of retries: hand back whatever we got
+		if attempt >= openAIMaxRetries {
+			return resp, err
+		}
+
+		delay := retryDelay(attempt, resp)
+		if err != nil {
+			loggerFrom(ctx).Warn("OpenAI request failed, retrying", "attempt", attempt+1, "max_attempts", openAIMaxRetries+1, "delay_ms", delay.Milliseconds(), "error", err)
+		} else {
+			loggerFrom(ctx).Warn("OpenAI returned retryable status, retrying", "status", resp.StatusCode, "attempt", attempt+1, "max_attempts", openAIMaxRetries+1, "delay_ms", delay.Milliseconds())
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext waits for d, or returns ctx's error if it's cancelled first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryDelay prefers the server's Retry-After and otherwise backs off exponentially with jitter
+func retryDelay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if retryAfter := parseRetryAfter(resp.Header.Get("Retry-After")); retryAfter > 0 {
+			return min(retryAfter, maxRetryAfter)
+		}
+	}
+
+	delay := openAIRetryBaseDelay << attempt
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+// parseRetryAfter handles both the delay-seconds and HTTP-date forms
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// telegramSender serializes outbound Telegram sends and paces them to stay
+// under the flood limits (about 1 message/sec per chat and 30/sec overall).
+type telegramSender struct {
+	mu             sync.Mutex
+	chatInterval   time.Duration
+	globalInterval time.Duration
+	nextGlobal     time.Time
+	nextChat       map[int64]time.Time
+}
+
+var sender = newTelegramSender(time.Second, time.Second/30)
+
+// A send that keeps getting 429s is retried this many times, as long as
+// Telegram asks us to wait no longer than telegramMaxRetryAfter
+const (
+	telegramMaxRetries    = 3
+	telegramMaxRetryAfter = time.Minute
+)
+
+func newTelegramSender(chatInterval, globalInterval time.Duration) *telegramSender {
+	return &telegramSender{
+		chatInterval:   chatInterval,
+		globalInterval: globalInterval,
+		nextChat:       make(map[int64]time.Time),
+	}
+}
+
+// reserve books the next free send slot for the chat and returns when it starts
+func (s *telegramSender) reserve(chatID int64) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	slot := now
+	if s.nextGlobal.After(slot) {
+		slot = s.nextGlobal
+	}
+	if next := s.nextChat[chatID]; next.After(slot) {
+		slot = next
+	}
+
+	// Forget chats that have been idle long enough
+	for id, next := range s.nextChat {
+		if next.Before(now) {
+			delete(s.nextChat, id)
+		}
+	}
+
+	s.nextGlobal = slot.Add(s.globalInterval)
+	s.nextChat[chatID] = slot.Add(s.chatInterval)
+	return slot
+}
+
+// delay holds back further sends to the chat, e.g. after Telegram asks us to retry later
+func (s *telegramSender) delay(chatID int64, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if s.nextChat[chatID].Before(until) {
+		s.nextChat[chatID] = until
+	}
+}
+
+// send waits for the chat's next slot and performs the request. A 429 response
+// pushes back later sends to the chat by the retry_after Telegram asked for,
+// and the request is sent again once that has passed. do must build a fresh
+// request on every call. Waiting stops early when ctx is cancelled.
+func (s *telegramSender) send(ctx context.Context, chatID int64, do func() (*http.Response, error)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := sleepContext(ctx, time.Until(s.reserve(chatID))); err != nil {
+			return nil, err
+		}
+
+		resp, err := do()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return resp, nil
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		var errorResponse TelegramErrorResponse
+		if err := json.Unmarshal(body, &errorResponse); err != nil || errorResponse.Parameters.RetryAfter <= 0 {
+			return resp, nil
+		}
+		retryAfter := time.Duration(errorResponse.Parameters.RetryAfter) * time.Second
+		slog.Warn("Telegram rate limit hit", "chat_id", chatID, "retry_after_seconds", errorResponse.Parameters.RetryAfter, "attempt", attempt+1)
+		s.delay(chatID, retryAfter)
+
+		// Give up rather than hold a worker for minutes
+		if attempt >= telegramMaxRetries || retryAfter > telegramMaxRetryAfter {
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sendTimes records when each send was performed
+type sendTimes struct {
+	mu    sync.Mutex
+	times []time.Time
+}
+
+// do is a send that records its time and succeeds
+func (s *sendTimes) do() (*http.Response, error) {
+	s.mu.Lock()
+	s.times = append(s.times, time.Now())
+	s.mu.Unlock()
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`{"ok":true}`))}, nil
+}
+
+// offsets returns how long after start each send happened, in the order they happened
+func (s *sendTimes) offsets(start time.Time) []time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	times := append([]time.Time(nil), s.times...)
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	var offsets []time.Duration
+	for _, sent := range times {
+		offsets = append(offsets, sent.Sub(start))
+	}
+	return offsets
+}
+
+// burst sends one message to each chat at the same moment and waits for all of them
+func burst(t *testing.T, s *telegramSender, recorder *sendTimes, chatIDs []int64) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for _, chatID := range chatIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.send(context.Background(), chatID, recorder.do); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Allowance for timer jitter when checking send times. Sends may run late on a
+// busy machine, so the tests only check that none ran early.
+const pacingSlack = 5 * time.Millisecond
+
+// checkPacing fails if the nth send happened before n intervals had passed
+func checkPacing(t *testing.T, offsets []time.Duration, interval time.Duration) {
+	t.Helper()
+	for i, offset := range offsets {
+		if want := time.Duration(i) * interval; offset < want-pacingSlack {
+			t.Errorf("send %d after %v, want at least %v", i+1, offset, want)
+		}
+	}
+}
+
+func TestSenderPacesBurstToOneChat(t *testing.T) {
+	recorder := &sendTimes{}
+	start := time.Now()
+	burst(t, newTelegramSender(50*time.Millisecond, 5*time.Millisecond), recorder, []int64{1, 1, 1, 1, 1})
+
+	offsets := recorder.offsets(start)
+	if len(offsets) != 5 {
+		t.Fatalf("got %d sends, want 5", len(offsets))
+	}
+	checkPacing(t, offsets, 50*time.Millisecond)
+}
+
+func TestSenderPacesBurstAcrossChats(t *testing.T) {
+	recorder := &sendTimes{}
+	start := time.Now()
+	burst(t, newTelegramSender(time.Second, 20*time.Millisecond), recorder, []int64{1, 2, 3, 4, 5})
+
+	// Different chats only wait for the global interval, not each other's per-chat one
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("burst to 5 chats took %v, per-chat pacing shouldn't apply across chats", elapsed)
+	}
+	checkPacing(t, recorder.offsets(start), 20*time.Millisecond)
+}
+
+func TestSenderRetriesAfterRateLimit(t *testing.T) {
+	s := newTelegramSender(0, 0)
+	recorder := &sendTimes{}
+	calls := 0
+	resp, err := s.send(context.Background(), 1, func() (*http.Response, error) {
+		if calls++; calls == 1 {
+			recorder.do()
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Body:       io.NopCloser(strings.NewReader(`{"ok":false,"error_code":429,"parameters":{"retry_after":1}}`)),
+			}, nil
+		}
+		return recorder.do()
+	})
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("send after a 429: %v, %v", resp, err)
+	}
+
+	offsets := recorder.offsets(recorder.times[0])
+	if len(offsets) != 2 {
+		t.Fatalf("got %d attempts, want 2", len(offsets))
+	}
+	if offsets[1] < time.Second-pacingSlack {
+		t.Errorf("retried after %v, want at least the 1s retry_after", offsets[1])
+	}
+	// Only the rate-limited chat was held back
+	if wait := time.Until(s.reserve(2)); wait > pacingSlack {
+		t.Errorf("next send to another chat in %v, want no wait", wait)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ChatSettings holds per-chat overrides of the global configuration
+type ChatSettings struct {
+	SafeMode      *bool  `json:"safe_mode,omitempty"` // nil follows SAFE_MODE
+	Language      string `json:"language"`            // ISO 639-1 code, empty means auto-detect
+	ReplyLanguage string `json:"reply_language"`      // messageCatalogs code, empty means the user's app language
+	Format        string `json:"format,omitempty"`    // invoiceFormatFixed, or empty for the default reply
+}
+
+// Settings live in the state store so they survive restarts; the mutex only
+// keeps updates from this instance from overwriting each other
+var chatSettingsMu sync.Mutex
+
+func chatSettingsKey(chatID int64) string {
+	return fmt.Sprintf("settings:%d", chatID)
+}
+
+// safeModeEnabled reports whether images from the chat must not be sent to
+// OpenAI: the chat's /safemode choice if it made one, otherwise SAFE_MODE
+func (s ChatSettings) safeModeEnabled() bool {
+	if s.SafeMode != nil {
+		return *s.SafeMode
+	}
+	return safeMode
+}
+
+// getChatSettings returns the settings for a chat. Chats that haven't changed
+// anything get the zero value, which follows the global configuration.
+func getChatSettings(ctx context.Context, chatID int64) ChatSettings {
+	var settings ChatSettings
+
+	value, ok, err := stateStore.Get(ctx, chatSettingsKey(chatID))
+	if err != nil {
+		loggerFrom(ctx).Error("Error loading chat settings", "chat_id", chatID, "error", err)
+		return settings
+	}
+	if !ok {
+		return settings
+	}
+
+	if err := json.Unmarshal([]byte(value), &settings); err != nil {
+		loggerFrom(ctx).Error("Error decoding chat settings", "chat_id", chatID, "error", err)
+		return ChatSettings{}
+	}
+	return settings
+}
+
+// updateChatSettings applies fn to the chat's settings and stores the result
+func updateChatSettings(ctx context.Context, chatID int64, fn func(*ChatSettings)) error {
+	chatSettingsMu.Lock()
+	defer chatSettingsMu.Unlock()
+
+	settings := getChatSettings(ctx, chatID)
+	fn(&settings)
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat settings: %v", err)
+	}
+	return stateStore.Set(ctx, chatSettingsKey(chatID), string(data), 0)
+}
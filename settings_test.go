@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestChatSafeModeFollowsGlobalUntilSet(t *testing.T) {
+	defer restore(&safeMode, false)()
+	const chatID = 9980
+
+	// A chat that changed another setting still follows SAFE_MODE
+	if err := updateChatSettings(context.Background(), chatID, func(s *ChatSettings) { s.Language = "ko" }); err != nil {
+		t.Fatal(err)
+	}
+	if getChatSettings(context.Background(), chatID).safeModeEnabled() {
+		t.Error("safe mode on while SAFE_MODE is off")
+	}
+	safeMode = true
+	if !getChatSettings(context.Background(), chatID).safeModeEnabled() {
+		t.Error("safe mode still off after SAFE_MODE was turned on")
+	}
+
+	// An explicit choice outlasts later changes to SAFE_MODE
+	off := false
+	if err := updateChatSettings(context.Background(), chatID, func(s *ChatSettings) { s.SafeMode = &off }); err != nil {
+		t.Fatal(err)
+	}
+	if getChatSettings(context.Background(), chatID).safeModeEnabled() {
+		t.Error("/safemode off didn't override SAFE_MODE")
+	}
+}
+
+func TestIsChatAdminInPrivateChats(t *testing.T) {
+	defer restore(&adminUserIDs, map[int64]bool{1: true})()
+	defer restore(&safeMode, false)()
+
+	tests := []struct {
+		name     string
+		safeMode bool
+		userID   int64
+		want     bool
+	}{
+		{"user, safe mode off", false, 2, true},
+		{"user, safe mode on", true, 2, false},
+		{"ADMIN_USER_IDS, safe mode on", true, 1, true},
+	}
+	for _, tt := range tests {
+		safeMode = tt.safeMode
+		isAdmin, err := isChatAdmin(context.Background(), tt.userID, tt.userID, "private")
+		if err != nil || isAdmin != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, isAdmin, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Telegram rejects messages longer than this (measured in UTF-16 code units)
+const telegramMaxMessageLength = 4096
+
+// splitMessage breaks text into chunks Telegram will accept, preferring to cut
+// at newlines, then spaces, so words and single-line formatting stay intact.
+// Code blocks cut in the middle are closed and reopened in the next chunk.
+func splitMessage(text string, limit int) []string {
+	const fence = "```"
+
+	var chunks []string
+	for utf16Len(text) > limit {
+		// Leave room for closing a code block
+		cut := splitPoint(text, limit-len(fence)-1)
+		chunk, rest := text[:cut], text[cut:]
+
+		if strings.Count(chunk, fence)%2 == 1 {
+			chunk += "\n" + fence
+			rest = fence + "\n" + rest
+		}
+
+		chunks = append(chunks, strings.TrimRight(chunk, "\n"))
+		text = strings.TrimLeft(rest, "\n")
+	}
+
+	if text != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// splitPoint returns the byte offset to cut text at so the first part fits in limit
+func splitPoint(text string, limit int) int {
+	// Longest prefix that fits, cut on a rune boundary
+	end, length := 0, 0
+	for i, r := range text {
+		size := 1
+		if r >= 0x10000 {
+			size = 2
+		}
+		if length+size > limit {
+			break
+		}
+		length += size
+		end = i + utf8.RuneLen(r)
+	}
+
+	// Prefer a newline, then a space, as long as it doesn't leave a tiny chunk
+	if i := strings.LastIndex(text[:end], "\n"); i > end/2 {
+		return i + 1
+	}
+	if i := strings.LastIndex(text[:end], " "); i > end/2 {
+		return i + 1
+	}
+	return end
+}
+
+// utf16Len counts UTF-16 code units, which is how Telegram measures message length
+func utf16Len(text string) int {
+	length := 0
+	for _, r := range text {
+		if r >= 0x10000 {
+			length += 2
+		} else {
+			length++
+		}
+	}
+	return length
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSplitMessageLongReply(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"ascii", "Brake pads, front axle - qty 2 - 45.00 USD"},
+		{"korean", "브레이크 패드 전륜 - 수량 2 - 45,000원"},
+		{"emoji", "🚗 Oil change with filter 🛢️ - 89.99 EUR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []string
+			for i := 0; utf16Len(strings.Join(lines, "\n")) < 10000; i++ {
+				lines = append(lines, fmt.Sprintf("%03d %s", i, tt.line))
+			}
+			text := strings.Join(lines, "\n")
+
+			chunks := splitMessage(text, telegramMaxMessageLength)
+			if len(chunks) < 3 {
+				t.Fatalf("got %d chunks for %d UTF-16 units", len(chunks), utf16Len(text))
+			}
+			for i, chunk := range chunks {
+				if n := utf16Len(chunk); n > telegramMaxMessageLength {
+					t.Errorf("chunk %d is %d UTF-16 units, over the limit", i, n)
+				}
+				// Every chunk is made of whole lines
+				first, last := strings.SplitN(chunk, " ", 2)[0], chunk[strings.LastIndex(chunk, "\n")+1:]
+				if len(first) != 3 || !strings.HasSuffix(last, tt.line) {
+					t.Errorf("chunk %d doesn't start and end on a line boundary: %q ... %q", i, first, last)
+				}
+			}
+			if joined := strings.Join(chunks, "\n"); joined != text {
+				t.Error("joining the chunks doesn't give back the original text")
+			}
+		})
+	}
+}
+
+func TestSplitMessageShortReply(t *testing.T) {
+	chunks := splitMessage("Total: 12.50 USD", telegramMaxMessageLength)
+	if len(chunks) != 1 || chunks[0] != "Total: 12.50 USD" {
+		t.Errorf("got %q", chunks)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StateStore holds state that should survive restarts and be shared between
+// instances (STATE_BACKEND, REDIS_URL). A zero TTL means the key never expires.
+//
+// Updates are handled by several workers at once, so every implementation must
+// be safe for concurrent use. State that only makes sense inside one process
+// (timers, downloaded images) stays in a map guarded by a mutex declared next
+// to it instead; nothing touches a shared map without holding its lock.
+type StateStore interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Incr atomically increments a counter and returns the new value. The TTL
+	// is applied when the counter is created.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+}
+
+var stateStore StateStore = newMemoryStateStore()
+
+// newStateStore returns the store for a STATE_BACKEND value
+func newStateStore(backend, redisURL string) (StateStore, error) {
+	switch backend {
+	case "", "memory":
+		return newMemoryStateStore(), nil
+	case "redis":
+		if redisURL == "" {
+			return nil, fmt.Errorf("REDIS_URL is required when STATE_BACKEND is redis")
+		}
+		return newRedisStateStore(redisURL)
+	default:
+		return nil, fmt.Errorf("unknown state backend %q, expected memory or redis", backend)
+	}
+}
+
+// How often in-memory stores drop their expired entries. Expired entries are
+// already ignored on read; sweeping at most this often keeps writes from each
+// scanning the whole map.
+var expirySweepInterval = time.Minute
+
+// sweepSchedule tells an in-memory store when its next sweep is due. The
+// store's own lock guards it.
+type sweepSchedule struct {
+	last time.Time
+}
+
+// due reports whether expirySweepInterval has passed since the last sweep,
+// and if so counts this as the new one
+func (s *sweepSchedule) due(now time.Time) bool {
+	if now.Sub(s.last) < expirySweepInterval {
+		return false
+	}
+	s.last = now
+	return true
+}
+
+// memoryStateStore keeps state in process memory; it's lost on restart
+type memoryStateStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryStateEntry
+	sweep   sweepSchedule
+}
+
+type memoryStateEntry struct {
+	value   string
+	expires time.Time // zero means no expiry
+}
+
+func (e memoryStateEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && now.After(e.expires)
+}
+
+func newMemoryStateStore() *memoryStateStore {
+	return &memoryStateStore{entries: make(map[string]memoryStateEntry)}
+}
+
+func (s *memoryStateStore) Get(ctx context.Context, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return "", false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (s *memoryStateStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set(key, value, ttl)
+	return nil
+}
+
+func (s *memoryStateStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *memoryStateStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		s.set(key, "1", ttl)
+		return 1, nil
+	}
+
+	n, err := strconv.ParseInt(entry.value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("value of %s is not a counter", key)
+	}
+	entry.value = strconv.FormatInt(n+1, 10)
+	s.entries[key] = entry
+	return n + 1, nil
+}
+
+// set stores a value, dropping expired entries when a sweep is due. The caller holds s.mu.
+func (s *memoryStateStore) set(key, value string, ttl time.Duration) {
+	now := time.Now()
+	if s.sweep.due(now) {
+		for k, entry := range s.entries {
+			if entry.expired(now) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	entry := memoryStateEntry{value: value}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	s.entries[key] = entry
+}
+
+// redisStateStore keeps state in Redis so it's shared by all instances
+type redisStateStore struct {
+	client *redis.Client
+}
+
+// Keys are prefixed so the bot can share a Redis database with other apps
+const redisKeyPrefix = "invoicebot:"
+
+func newRedisStateStore(url string) (*redisStateStore, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+
+	client := redis.NewClient(options)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+
+	return &redisStateStore{client: client}, nil
+}
+
+func (s *redisStateStore) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := s.client.Get(ctx, redisKeyPrefix+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("redis GET %s: %v", key, err)
+	}
+	return value, true, nil
+}
+
+func (s *redisStateStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := s.client.Set(ctx, redisKeyPrefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("redis SET %s: %v", key, err)
+	}
+	return nil
+}
+
+func (s *redisStateStore) Delete(ctx context.Context, key string) error {
+	if err := s.client.Del(ctx, redisKeyPrefix+key).Err(); err != nil {
+		return fmt.Errorf("redis DEL %s: %v", key, err)
+	}
+	return nil
+}
+
+// Incr creates the counter with its TTL (SET NX EX) and increments it in one
+// MULTI/EXEC transaction, so a crash between the two can't leave a counter
+// that never expires
+func (s *redisStateStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var incr *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetNX(ctx, redisKeyPrefix+key, 0, ttl)
+		incr = pipe.Incr(ctx, redisKeyPrefix+key)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("redis INCR %s: %v", key, err)
+	}
+	return incr.Val(), nil
+}
+
+// storeExtractionCache is an ExtractionCache backed by the state store
+type storeExtractionCache struct {
+	store StateStore
+}
+
+func (c storeExtractionCache) Get(ctx context.Context, key string) (string, bool) {
+	value, ok, err := c.store.Get(ctx, "extraction:"+key)
+	if err != nil {
+		loggerFrom(ctx).Warn("Error reading extraction cache", "error", err)
+		return "", false
+	}
+	return value, ok
+}
+
+func (c storeExtractionCache) Set(ctx context.Context, key, value string, ttl time.Duration) {
+	if err := c.store.Set(ctx, "extraction:"+key, value, ttl); err != nil {
+		loggerFrom(ctx).Warn("Error writing extraction cache", "error", err)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Goroutines and iterations per goroutine for the concurrency tests; run them
+// with go test -race to catch state shared without a lock
+const (
+	hammerWorkers    = 16
+	hammerIterations = 100
+)
+
+// hammer runs fn from hammerWorkers goroutines at once, hammerIterations times each
+func hammer(fn func(worker, i int)) {
+	var wg sync.WaitGroup
+	for w := 0; w < hammerWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < hammerIterations; i++ {
+				fn(w, i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConcurrentChatSettings(t *testing.T) {
+	const chatID = 9600
+	languages := []string{"en", "ko", "ja"}
+	initial := getChatSettings(context.Background(), chatID).safeModeEnabled()
+
+	hammer(func(w, i int) {
+		err := updateChatSettings(context.Background(), chatID, func(s *ChatSettings) {
+			s.Language = languages[(w+i)%len(languages)]
+			enabled := !s.safeModeEnabled()
+			s.SafeMode = &enabled
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		getChatSettings(context.Background(), chatID)
+	})
+
+	// An even number of toggles leaves safe mode where it started unless one was lost
+	if got := getChatSettings(context.Background(), chatID).safeModeEnabled(); got != initial {
+		t.Errorf("safe mode %v after %d toggles, want %v", got, hammerWorkers*hammerIterations, initial)
+	}
+}
+
+func TestConcurrentUsageStats(t *testing.T) {
+	const chatID = 9601
+
+	hammer(func(w, i int) {
+		recordChatUsage(chatID, Usage{TotalTokens: 10})
+		usageTotals(chatID)
+		usageTotals(0)
+	})
+
+	today, _, _ := usageTotals(chatID)
+	if want := hammerWorkers * hammerIterations; today.extractions != want || today.tokens != want*10 {
+		t.Errorf("recorded %d extractions and %d tokens, want %d and %d", today.extractions, today.tokens, want, want*10)
+	}
+}
+
+func TestConcurrentInvoiceStore(t *testing.T) {
+	const chatID = 9602
+
+	hammer(func(w, i int) {
+		invoice := &Invoice{Vendor: "Hammer Garage", InvoiceNumber: fmt.Sprintf("H-%d", i), Total: decimal("10.00")}
+		id := saveInvoice(chatID, int64(w*hammerIterations+i), invoice, "")
+		findDuplicateInvoice(chatID, -1, invoice, "")
+		updateStoredInvoice(id, func(s *StoredInvoice) { s.Verified = true })
+		getStoredInvoice(id)
+		chatInvoices(chatID)
+	})
+}
+
+func TestConcurrentRecentImages(t *testing.T) {
+	hammer(func(w, i int) {
+		var message TelegramMessage
+		message.Chat.ID = int64(9700 + w%4)
+		message.MessageID = int64(i)
+		rememberImage(message, "data:image/png;base64,", i%2 == 0)
+		lastImage(message.Chat.ID)
+	})
+}
+
+func TestConcurrentUpdateDedup(t *testing.T) {
+	defer restore(&seenUpdates, updateDeduper(newUpdateSet()))()
+
+	// Every worker delivers the same updates; each must be let through exactly once
+	var firstDeliveries atomic.Int32
+	hammer(func(w, i int) {
+		if !seenUpdates.markSeen(context.Background(), int64(i)) {
+			firstDeliveries.Add(1)
+		}
+	})
+
+	if n := firstDeliveries.Load(); n != hammerIterations {
+		t.Errorf("%d updates processed, want %d", n, hammerIterations)
+	}
+}
+
+func TestConcurrentExtractionCache(t *testing.T) {
+	defer restore(&extractionCache, ExtractionCache(newMemoryCache()))()
+	defer restore(&extractionCacheTTL, time.Minute)()
+
+	var calls atomic.Int32
+	hammer(func(w, i int) {
+		fileHash := fmt.Sprintf("hash-%d", i%10)
+		result, _, err := cachedExtraction(context.Background(), fileHash, "text", "gpt-4o", "prompt", func() (string, Usage, error) {
+			calls.Add(1)
+			return "text of " + fileHash, Usage{}, nil
+		})
+		if err != nil || result != "text of "+fileHash {
+			t.Errorf("got %q, %v", result, err)
+		}
+	})
+
+	if n := calls.Load(); n < 10 {
+		t.Errorf("extracted %d times, want at least once per file", n)
+	}
+}
+
+func TestConcurrentPendingTotalFixes(t *testing.T) {
+	const chatID = 9603
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, nil)
+
+	id := saveInvoice(chatID, 1, &Invoice{Vendor: "Fix Garage", Currency: "USD", Total: decimal("10.00")}, "")
+	hammer(func(w, i int) {
+		ctx := withLanguage(context.Background(), "en")
+		userID := int64(w)
+		if i%2 == 0 {
+			handleCallbackQuery(ctx, &TelegramCallbackQuery{
+				ID:      "q",
+				From:    TelegramUser{ID: userID},
+				Message: &TelegramMessage{MessageID: 1, Chat: TelegramChat{ID: chatID}},
+				Data:    fmt.Sprintf("%s:%d", callbackFixTotal, id),
+			})
+			return
+		}
+		var message TelegramMessage
+		message.Chat.ID = chatID
+		message.From.ID = userID
+		message.Text = fmt.Sprintf("%d.00", i)
+		if !handleTotalCorrection(ctx, message) {
+			t.Errorf("worker %d: correction after pressing Fix total wasn't applied", w)
+		}
+	})
+}
+
+func TestConcurrentSenderSchedule(t *testing.T) {
+	s := newTelegramSender(time.Millisecond, time.Microsecond)
+	hammer(func(w, i int) {
+		s.reserve(int64(w % 4))
+		if i%10 == 0 {
+			s.delay(int64(w%4), time.Millisecond)
+		}
+	})
+}
+
+func TestMemoryStoresSweepExpiredEntriesPeriodically(t *testing.T) {
+	defer restore(&expirySweepInterval, time.Hour)()
+	store := newMemoryStateStore()
+	cache := newMemoryCache()
+
+	store.Set(context.Background(), "old", "1", time.Millisecond)
+	cache.Set(context.Background(), "old", "1", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// Within the interval a write leaves the expired entry for the next sweep
+	store.Set(context.Background(), "new", "1", time.Hour)
+	cache.Set(context.Background(), "new", "1", time.Hour)
+	if _, ok, _ := store.Get(context.Background(), "old"); ok {
+		t.Error("expired state entry returned")
+	}
+	if _, ok := cache.Get(context.Background(), "old"); ok {
+		t.Error("expired cache entry returned")
+	}
+	if len(store.entries) != 2 || len(cache.entries) != 2 {
+		t.Fatalf("swept before the interval: %d state and %d cache entries", len(store.entries), len(cache.entries))
+	}
+
+	expirySweepInterval = 0
+	store.Set(context.Background(), "newer", "1", time.Hour)
+	cache.Set(context.Background(), "newer", "1", time.Hour)
+	if _, ok := store.entries["old"]; ok {
+		t.Error("expired state entry kept after a sweep")
+	}
+	if _, ok := cache.entries["old"]; ok {
+		t.Error("expired cache entry kept after a sweep")
+	}
+}
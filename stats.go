@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Estimated price per 1,000 tokens, used for the cost shown by /stats (TOKEN_PRICE_PER_1K)
+var tokenPricePer1K = 0.005
+
+// Users who can see stats for all chats with /stats all (ADMIN_USER_IDS)
+var adminUserIDs map[int64]bool
+
+// How many days of per-chat usage are kept; enough for "this month" and a bit more
+const statsRetentionDays = 62
+
+// dayUsage is one chat's usage on one day
+type dayUsage struct {
+	extractions int
+	tokens      int
+}
+
+var (
+	chatUsageMu sync.Mutex
+	chatUsage   = make(map[int64]map[string]*dayUsage) // chat ID -> "2006-01-02" -> usage
+)
+
+// recordChatUsage counts a successful extraction and the tokens it used
+func recordChatUsage(chatID int64, usage Usage) {
+	addChatUsage(chatID, 1, usage.TotalTokens)
+}
+
+// recordChatTokens counts tokens spent on something other than an extraction,
+// like classifying an image, without adding to the extraction count
+func recordChatTokens(chatID int64, usage Usage) {
+	addChatUsage(chatID, 0, usage.TotalTokens)
+}
+
+func addChatUsage(chatID int64, extractions, tokens int) {
+	chatUsageMu.Lock()
+	defer chatUsageMu.Unlock()
+
+	now := time.Now()
+	days, ok := chatUsage[chatID]
+	if !ok {
+		days = make(map[string]*dayUsage)
+		chatUsage[chatID] = days
+	}
+
+	cutoff := now.AddDate(0, 0, -statsRetentionDays).Format("2006-01-02")
+	for day := range days {
+		if day < cutoff {
+			delete(days, day)
+		}
+	}
+
+	today := now.Format("2006-01-02")
+	if days[today] == nil {
+		days[today] = &dayUsage{}
+	}
+	days[today].extractions += extractions
+	days[today].tokens += tokens
+}
+
+// usageTotals sums usage for today and this month, for one chat or all chats (chatID 0)
+func usageTotals(chatID int64) (today, month dayUsage, chats int) {
+	chatUsageMu.Lock()
+	defer chatUsageMu.Unlock()
+
+	now := time.Now()
+	todayKey := now.Format("2006-01-02")
+	monthPrefix := now.Format("2006-01-")
+
+	for id, days := range chatUsage {
+		if chatID != 0 && id != chatID {
+			continue
+		}
+		active := false
+		for day, usage := range days {
+			if !strings.HasPrefix(day, monthPrefix) {
+				continue
+			}
+			active = true
+			month.extractions += usage.extractions
+			month.tokens += usage.tokens
+			if day == todayKey {
+				today.extractions += usage.extractions
+				today.tokens += usage.tokens
+			}
+		}
+		if active {
+			chats++
+		}
+	}
+	return today, month, chats
+}
+
+// Handle /stats [all]
+func handleStatsCommand(ctx context.Context, message TelegramMessage, args string) {
+	chatID := message.Chat.ID
+	lang := languageFrom(ctx)
+	title := t("stats.chat_title", lang)
+
+	if strings.EqualFold(args, "all") {
+		if !adminUserIDs[message.From.ID] {
+			sendTelegramMessage(ctx, chatID, message.MessageID, t("stats.admins_only", lang))
+			return
+		}
+		chatID = 0
+		title = t("stats.all_title", lang)
+	}
+
+	today, month, chats := usageTotals(chatID)
+
+	var b strings.Builder
+	b.WriteString(title)
+	b.WriteString("\n\n" + t("stats.today", lang, today.extractions, today.tokens, estimatedCost(today.tokens)))
+	b.WriteString("\n" + t("stats.month", lang, month.extractions, month.tokens, estimatedCost(month.tokens)))
+	if chatID == 0 {
+		b.WriteString("\n" + t("stats.active_chats", lang, chats))
+	}
+	b.WriteString("\n\n" + t("stats.note", lang))
+
+	loggerFrom(ctx).Info("Sent usage stats", "all_chats", chatID == 0)
+	sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, b.String())
+}
+
+// estimatedCost converts tokens to an approximate price using tokenPricePer1K
+func estimatedCost(tokens int) float64 {
+	return float64(tokens) / 1000 * tokenPricePer1K
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How many extracted invoices are kept in memory; the oldest are dropped first
+const maxStoredInvoices = 1000
+
+// StoredInvoice is an extracted invoice along with where it came from
+type StoredInvoice struct {
+	ID        int64
+	ChatID    int64
+	MessageID int64 // message the invoice was extracted from
+	Invoice   Invoice
+	FileHash  string // SHA-256 of the downloaded file, for duplicate detection
+	Verified  bool
+	CreatedAt time.Time
+}
+
+var (
+	invoiceStoreMu sync.Mutex
+	storedInvoices       = make(map[int64]*StoredInvoice)
+	nextInvoiceID  int64 = 1
+)
+
+// saveInvoice stores a copy of an extracted invoice and returns its ID.
+// Re-running a message (/retry, Re-scan) replaces the invoice already stored
+// for it, keeping its ID. Files from one message, like the entries of a ZIP,
+// are told apart by fileHash; a re-run without a hash matches any of them.
+func saveInvoice(chatID, messageID int64, invoice *Invoice, fileHash string) int64 {
+	invoiceStoreMu.Lock()
+	defer invoiceStoreMu.Unlock()
+
+	if stored := findMessageInvoice(chatID, messageID, fileHash); stored != nil {
+		stored.Invoice = *invoice
+		stored.Verified = false
+		if fileHash != "" {
+			stored.FileHash = fileHash
+		}
+		return stored.ID
+	}
+
+	id := nextInvoiceID
+	nextInvoiceID++
+	storedInvoices[id] = &StoredInvoice{
+		ID:        id,
+		ChatID:    chatID,
+		MessageID: messageID,
+		Invoice:   *invoice,
+		FileHash:  fileHash,
+		CreatedAt: time.Now(),
+	}
+
+	// IDs are sequential, so the oldest entry has the lowest ID
+	if len(storedInvoices) > maxStoredInvoices {
+		oldest := id
+		for storedID := range storedInvoices {
+			oldest = min(oldest, storedID)
+		}
+		delete(storedInvoices, oldest)
+	}
+
+	return id
+}
+
+// findMessageInvoice returns the earliest invoice stored for the message and
+// file. The caller must hold invoiceStoreMu.
+func findMessageInvoice(chatID, messageID int64, fileHash string) *StoredInvoice {
+	var match *StoredInvoice
+	for _, stored := range storedInvoices {
+		if stored.ChatID != chatID || stored.MessageID != messageID {
+			continue
+		}
+		if fileHash != "" && stored.FileHash != fileHash {
+			continue
+		}
+		if match == nil || stored.ID < match.ID {
+			match = stored
+		}
+	}
+	return match
+}
+
+// findDuplicateInvoice returns the earliest stored invoice in the chat that the
+// given one duplicates. Invoices from the same message (re-runs) are skipped.
+func findDuplicateInvoice(chatID, messageID int64, invoice *Invoice, fileHash string) (StoredInvoice, bool) {
+	invoiceStoreMu.Lock()
+	defer invoiceStoreMu.Unlock()
+
+	var match *StoredInvoice
+	for _, stored := range storedInvoices {
+		if stored.ChatID != chatID || stored.MessageID == messageID {
+			continue
+		}
+		if isDuplicateOf(invoice, fileHash, stored) && (match == nil || stored.ID < match.ID) {
+			match = stored
+		}
+	}
+	if match == nil {
+		return StoredInvoice{}, false
+	}
+	return *match, true
+}
+
+// getStoredInvoice returns a copy of a stored invoice
+func getStoredInvoice(id int64) (StoredInvoice, bool) {
+	invoiceStoreMu.Lock()
+	defer invoiceStoreMu.Unlock()
+
+	stored, ok := storedInvoices[id]
+	if !ok {
+		return StoredInvoice{}, false
+	}
+	return *stored, true
+}
+
+// updateStoredInvoice applies fn to a stored invoice and returns the result.
+// Returns false if the invoice isn't stored (anymore).
+func updateStoredInvoice(id int64, fn func(*StoredInvoice)) (StoredInvoice, bool) {
+	invoiceStoreMu.Lock()
+	defer invoiceStoreMu.Unlock()
+
+	stored, ok := storedInvoices[id]
+	if !ok {
+		return StoredInvoice{}, false
+	}
+	fn(stored)
+	return *stored, true
+}
+
+// chatInvoices returns copies of a chat's stored invoices, oldest first
+func chatInvoices(chatID int64) []StoredInvoice {
+	invoiceStoreMu.Lock()
+	defer invoiceStoreMu.Unlock()
+
+	var invoices []StoredInvoice
+	for _, stored := range storedInvoices {
+		if stored.ChatID == chatID {
+			invoices = append(invoices, *stored)
+		}
+	}
+	sort.Slice(invoices, func(i, j int) bool { return invoices[i].ID < invoices[j].ID })
+	return invoices
+}
+
+// normalizeVendor is the key vendors are grouped by, so "ACME  Motors" and
+// "acme motors" count as one vendor
+func normalizeVendor(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// vendorInvoices returns copies of a chat's invoices from the vendor, oldest first
+func vendorInvoices(chatID int64, vendor string) []StoredInvoice {
+	key := normalizeVendor(vendor)
+	var invoices []StoredInvoice
+	for _, stored := range chatInvoices(chatID) {
+		if normalizeVendor(stored.Invoice.Vendor) == key {
+			invoices = append(invoices, stored)
+		}
+	}
+	return invoices
+}
+
+// VendorSummary is one vendor's invoices in a chat
+type VendorSummary struct {
+	Name       string // as written on the vendor's latest invoice
+	Invoices   int
+	Currencies []string            // in the order first seen
+	Totals     map[string]*Decimal // sum of the invoice totals per currency
+}
+
+// chatVendors groups a chat's invoices by normalized vendor name, most
+// invoices first. Invoices without a vendor aren't counted.
+func chatVendors(chatID int64) []VendorSummary {
+	var vendors []*VendorSummary
+	byKey := make(map[string]*VendorSummary)
+	for _, stored := range chatInvoices(chatID) {
+		invoice := stored.Invoice
+		key := normalizeVendor(invoice.Vendor)
+		if key == "" {
+			continue
+		}
+		vendor, ok := byKey[key]
+		if !ok {
+			vendor = &VendorSummary{Totals: make(map[string]*Decimal)}
+			byKey[key] = vendor
+			vendors = append(vendors, vendor)
+		}
+		vendor.Name = strings.Join(strings.Fields(invoice.Vendor), " ")
+		vendor.Invoices++
+		if invoice.Total != nil {
+			sum, ok := vendor.Totals[invoice.Currency]
+			if !ok {
+				sum = &Decimal{}
+				vendor.Totals[invoice.Currency] = sum
+				vendor.Currencies = append(vendor.Currencies, invoice.Currency)
+			}
+			sum.Add(&sum.Rat, &invoice.Total.Rat)
+		}
+	}
+
+	summaries := make([]VendorSummary, len(vendors))
+	for i, vendor := range vendors {
+		summaries[i] = *vendor
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].Invoices != summaries[j].Invoices {
+			return summaries[i].Invoices > summaries[j].Invoices
+		}
+		return normalizeVendor(summaries[i].Name) < normalizeVendor(summaries[j].Name)
+	})
+	return summaries
+}
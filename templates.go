@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"text/template"
+)
+
+// Operator-defined reply layouts (IMAGE_REPLY_TEMPLATE, INVOICE_REPLY_TEMPLATE).
+// They produce Telegram Markdown and get text that's already escaped. Unset
+// templates keep the built-in replies.
+var (
+	imageReplyTemplate   *template.Template
+	invoiceReplyTemplate *template.Template
+)
+
+// imageReplyData is what IMAGE_REPLY_TEMPLATE can use
+type imageReplyData struct {
+	Text     string // the extracted text
+	FileName string // the uploaded file name, only set for /test-image
+}
+
+// invoiceReplyData is what INVOICE_REPLY_TEMPLATE can use. Amounts are
+// formatted strings and empty when missing; Details is the built-in reply.
+type invoiceReplyData struct {
+	Vendor        string
+	InvoiceNumber string
+	Date          string
+	Currency      string
+	Subtotal      string
+	Tax           string
+	Total         string
+	LineItems     []lineItemReplyData
+	VINs          []vinReplyData
+	OtherText     string
+	Details       string
+}
+
+type vinReplyData struct {
+	VIN   string
+	Valid bool // the ISO 3779 check digit matches
+}
+
+type lineItemReplyData struct {
+	Description string
+	Quantity    string
+	UnitPrice   string
+	Amount      string
+}
+
+// parseReplyTemplate parses a reply template. "\n" in the value stands for a
+// line break, since env vars are awkward to write over several lines. The
+// template is tried on empty data so a misspelled field fails at startup.
+func parseReplyTemplate(name, value string, sample any) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(strings.ReplaceAll(value, `\n`, "\n"))
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// formatImageReply renders extracted text for Telegram. A blank result or the
+// model saying it found no text gets a plain notice instead of an empty header.
+func formatImageReply(text, fileName, lang string) string {
+	if isNoTextAnswer(text) {
+		return t("image.no_text", lang)
+	}
+
+	escaped := escapeMarkdown(text)
+	if imageReplyTemplate != nil {
+		if reply, err := renderReplyTemplate(imageReplyTemplate, imageReplyData{Text: escaped, FileName: escapeMarkdown(fileName)}); err == nil {
+			return reply
+		}
+	}
+
+	var suffix string
+	if fileName != "" {
+		suffix = fmt.Sprintf(" (%s)", escapeMarkdown(fileName))
+	}
+	return t("image.reply", lang, suffix, escaped)
+}
+
+// formatInvoice renders the invoice fields as a Telegram message in the chat's
+// /format layout, noting line items that don't add up
+func formatInvoice(inv *Invoice, settings ChatSettings, lang string) string {
+	if settings.Format == invoiceFormatFixed {
+		return fixedWidthInvoice(inv, lang) + lineItemsWarning(inv, lang)
+	}
+	return invoiceReply(inv, lang) + lineItemsWarning(inv, lang)
+}
+
+// invoiceReply renders the invoice with INVOICE_REPLY_TEMPLATE, or the built-in reply
+func invoiceReply(inv *Invoice, lang string) string {
+	details := defaultInvoiceReply(inv, lang)
+	if invoiceReplyTemplate == nil || inv.isEmpty() {
+		return details
+	}
+
+	data := invoiceReplyData{
+		Vendor:        escapeMarkdown(inv.Vendor),
+		InvoiceNumber: escapeMarkdown(inv.InvoiceNumber),
+		Currency:      escapeMarkdown(inv.Currency),
+		Subtotal:      decimalString(inv.Subtotal),
+		Tax:           decimalString(inv.Tax),
+		Total:         decimalString(inv.Total),
+		OtherText:     escapeMarkdown(strings.TrimSpace(inv.OtherText)),
+		Details:       details,
+	}
+	if !inv.Date.IsZero() {
+		data.Date = inv.Date.Format("2006-01-02")
+	}
+	for _, vin := range inv.VINs {
+		if vin = normalizeVIN(vin); vin != "" {
+			data.VINs = append(data.VINs, vinReplyData{VIN: escapeMarkdown(vin), Valid: validateVIN(vin)})
+		}
+	}
+	for _, item := range inv.LineItems {
+		data.LineItems = append(data.LineItems, lineItemReplyData{
+			Description: escapeMarkdown(item.Description),
+			Quantity:    decimalString(item.Quantity),
+			UnitPrice:   decimalString(item.UnitPrice),
+			Amount:      decimalString(item.Amount),
+		})
+	}
+
+	if reply, err := renderReplyTemplate(invoiceReplyTemplate, data); err == nil {
+		return reply
+	}
+	return details
+}
+
+// renderReplyTemplate runs a reply template, logging failures so the caller can use its default
+func renderReplyTemplate(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		slog.Error("Reply template failed, using the default reply", "template", tmpl.Name(), "error", err)
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func decimalString(d *Decimal) string {
+	if d == nil {
+		return ""
+	}
+	return d.String()
+}
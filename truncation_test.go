@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// truncatingOpenAI answers chat completions with finishReasons in turn and
+// records the max_tokens of each request
+type truncatingOpenAI struct {
+	finishReasons []string
+
+	mu        sync.Mutex
+	maxTokens []int
+}
+
+func (f *truncatingOpenAI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request OpenAIRequest
+	json.NewDecoder(r.Body).Decode(&request)
+
+	f.mu.Lock()
+	f.maxTokens = append(f.maxTokens, request.MaxTokens)
+	call := len(f.maxTokens)
+	f.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"choices": []map[string]any{{
+			"message":       map[string]string{"role": "assistant", "content": "part " + strings.Repeat("x", call)},
+			"finish_reason": f.finishReasons[min(call, len(f.finishReasons))-1],
+		}},
+		"usage": map[string]int{"prompt_tokens": 100, "completion_tokens": 50, "total_tokens": 150},
+	})
+}
+
+func (f *truncatingOpenAI) requests() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]int(nil), f.maxTokens...)
+}
+
+func textRequest() OpenAIRequest {
+	return OpenAIRequest{
+		Model:     "gpt-4o",
+		MaxTokens: 1000,
+		Messages:  []Message{{Role: "user", Content: []Content{{Type: "text", Text: "read this"}}}},
+	}
+}
+
+func TestTruncatedResponseIsRetriedWithMoreTokens(t *testing.T) {
+	openAI := &truncatingOpenAI{finishReasons: []string{"length", "stop"}}
+	useFakeAPIs(t, http.NotFoundHandler(), openAI)
+
+	content, usage, err := callOpenAI(context.Background(), textRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content != "part xx" {
+		t.Errorf("got %q, want the retry's content", content)
+	}
+	if usage.TotalTokens != 300 {
+		t.Errorf("usage %d tokens, want both calls counted", usage.TotalTokens)
+	}
+	if got := openAI.requests(); len(got) != 2 || got[0] != 1000 || got[1] != 2000 {
+		t.Errorf("max_tokens per call %v, want [1000 2000]", got)
+	}
+}
+
+func TestTruncatedResponseGivesUpAfterRetry(t *testing.T) {
+	openAI := &truncatingOpenAI{finishReasons: []string{"length"}}
+	useFakeAPIs(t, http.NotFoundHandler(), openAI)
+
+	content, usage, err := callOpenAI(context.Background(), textRequest())
+	if !errors.Is(err, errResponseTruncated) {
+		t.Fatalf("got %v, want errResponseTruncated", err)
+	}
+	if content != "part xx" || usage.TotalTokens != 300 {
+		t.Errorf("got %q with %d tokens, want the partial retry content and both calls' usage", content, usage.TotalTokens)
+	}
+	if got := openAI.requests(); len(got) != 2 {
+		t.Errorf("%d calls, want one retry", len(got))
+	}
+
+	// Plain text keeps the partial result with a note in the user's language
+	for lang, messages := range map[string]map[string]string{"en": englishMessages, "ko": koreanMessages} {
+		noted, _, err := withTruncationNote(content, usage, err, lang)
+		if want := content + "\n\n" + messages["truncated"]; err != nil || noted != want {
+			t.Errorf("withTruncationNote in %s: %q, %v, want %q", lang, noted, err, want)
+		}
+	}
+}
+
+func TestTruncatedInvoiceAsksForParts(t *testing.T) {
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, &truncatingOpenAI{finishReasons: []string{"length"}})
+
+	processTestUpdate(photoUpdate(9500))
+
+	want := englishText("image.too_long")
+	if sent := telegram.sent(); len(sent) != 1 || !strings.HasPrefix(sent[0], want) {
+		t.Errorf("sent %q, want a reply starting with %q", sent, want)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Links pasted as text, e.g. "https://example.com/invoice.jpg"
+var pastedURLPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// Ranges that aren't covered by the net.IP helpers but still aren't public
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// Most redirects followed for a pasted link
+const maxURLRedirects = 5
+
+var (
+	errBlockedAddress = errors.New("address is not publicly routable")
+	errURLUnreachable = errors.New("link could not be fetched")
+	errURLTooLarge    = errors.New("linked file is too large")
+	errURLNotImage    = errors.New("link is not an image")
+	errURLIsPDF       = errors.New("link is a PDF")
+)
+
+// Client for user-supplied links. It has no proxy, so every connection goes
+// through the dialer's address check, including ones made after a redirect.
+var urlClient = newURLClient(httpTimeout)
+
+// newURLClient returns a client that refuses to connect to private, loopback
+// and link-local addresses, so pasted links can't reach internal services
+func newURLClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout: 10 * time.Second,
+				// Runs after DNS resolution, so a public name resolving to a private address is caught too
+				Control: func(network, address string, _ syscall.RawConn) error {
+					addrPort, err := netip.ParseAddrPort(address)
+					if err != nil {
+						return fmt.Errorf("%w: %s", errBlockedAddress, address)
+					}
+					if !isPublicAddress(addrPort.Addr()) {
+						return fmt.Errorf("%w: %s", errBlockedAddress, addrPort.Addr())
+					}
+					return nil
+				},
+			}).DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConns:          10,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxURLRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxURLRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// isPublicAddress reports whether an IP is safe to fetch a user's link from
+func isPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// pastedURL returns the first http(s) link in a text message, or "" if there is none
+func pastedURL(text string) string {
+	link := pastedURLPattern.FindString(text)
+	// Punctuation after a link in a sentence isn't part of it
+	return strings.TrimRight(link, ".,;:!?)]}'")
+}
+
+// processURL downloads an invoice image from a link pasted as text and runs it
+// through the same extraction as photos. The rest of the text acts as a caption.
+func processURL(ctx context.Context, message TelegramMessage, link string) {
+	logger := loggerFrom(ctx).With("url", link)
+	ctx = withLogger(ctx, logger)
+	lang := languageFrom(ctx)
+	logger.Info("Processing pasted link")
+
+	// Safe mode forbids sending images to OpenAI
+	settings := getChatSettings(ctx, message.Chat.ID)
+	if settings.safeModeEnabled() {
+		logger.Info("Safe mode enabled, skipping image OCR")
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("ocr_disabled", lang))
+		return
+	}
+
+	message.Caption = removeBotMention(strings.TrimSpace(strings.Replace(message.Text, link, "", 1)))
+	if caption, noCache := stripNoCacheOption(message.Caption); noCache {
+		message.Caption = caption
+		ctx = withoutCachedResults(ctx)
+	}
+	totalOnly := isTotalRequest(message.Caption)
+
+	start := time.Now()
+	content, mimeType, err := downloadURL(ctx, link)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, urlErrorMessage(err, lang), fmt.Errorf("downloading %s: %v", link, err))
+		return
+	}
+	fileHash := contentHash(content)
+
+	content, err = imageForExtraction(content, mimeType)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, t("url.unreadable_image", lang), fmt.Errorf("converting %s from link: %v", mimeType, err))
+		return
+	}
+
+	logger.Info("Link downloaded", "mime_type", mimeType, "duration_ms", time.Since(start).Milliseconds(), "file_hash", fileHash)
+	rememberImage(message, imageDataURL(content), totalOnly)
+	extractAndReply(ctx, message, prepareForExtraction(ctx, content), fileHash, totalOnly, settings, openAIModel)
+}
+
+// downloadURL fetches an image from a user's link, refusing non-public
+// addresses, anything over maxFileSizeBytes and anything that isn't an image
+// we can read. It returns the content and its image type.
+func downloadURL(ctx context.Context, link string) ([]byte, string, error) {
+	parsed, err := url.Parse(link)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, "", fmt.Errorf("%w: invalid link", errURLUnreachable)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", parsed.String(), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := urlClient.Do(req)
+	if err != nil {
+		if errors.Is(err, errBlockedAddress) {
+			return nil, "", err
+		}
+		return nil, "", fmt.Errorf("%w: %v", errURLUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, "", fmt.Errorf("%w: status %d", errURLUnreachable, resp.StatusCode)
+	}
+	if resp.ContentLength > maxFileSizeBytes {
+		return nil, "", fmt.Errorf("%w: %d bytes", errURLTooLarge, resp.ContentLength)
+	}
+
+	// The reported length can be missing or wrong, so cap what we read as well
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSizeBytes+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: failed to read body: %v", errURLUnreachable, err)
+	}
+	if int64(len(content)) > maxFileSizeBytes {
+		return nil, "", fmt.Errorf("%w: over %d bytes", errURLTooLarge, maxFileSizeBytes)
+	}
+
+	// Trust the header or the file name when they name an image we read,
+	// otherwise go by the content itself
+	headerType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	mimeType := documentMimeType(headerType, resp.Request.URL.Path)
+	if _, ok := documentImageDecoders[mimeType]; !ok {
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(content))
+	}
+	if _, ok := documentImageDecoders[mimeType]; ok {
+		return content, mimeType, nil
+	}
+	if mimeType == "application/pdf" || headerType == "application/pdf" {
+		return nil, "", errURLIsPDF
+	}
+	return nil, "", fmt.Errorf("%w: %s", errURLNotImage, headerType)
+}
+
+// urlErrorMessage picks the reply for a pasted link we couldn't use
+func urlErrorMessage(err error, lang string) string {
+	switch {
+	case errors.Is(err, errBlockedAddress):
+		return t("url.blocked", lang)
+	case errors.Is(err, errURLTooLarge):
+		return t("url.too_large", lang, float64(maxFileSizeBytes)/(1024*1024))
+	case errors.Is(err, errURLIsPDF):
+		return t("url.pdf", lang)
+	case errors.Is(err, errURLNotImage):
+		return t("url.not_image", lang)
+	}
+	return t("url.unreachable", lang)
+}
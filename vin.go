@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ISO 3779 transliteration of VIN characters to numbers. I, O and Q are never
+// used in a VIN because they're easily confused with 1 and 0.
+var vinValues = map[rune]int{
+	'0': 0, '1': 1, '2': 2, '3': 3, '4': 4, '5': 5, '6': 6, '7': 7, '8': 8, '9': 9,
+	'A': 1, 'B': 2, 'C': 3, 'D': 4, 'E': 5, 'F': 6, 'G': 7, 'H': 8,
+	'J': 1, 'K': 2, 'L': 3, 'M': 4, 'N': 5, 'P': 7, 'R': 9,
+	'S': 2, 'T': 3, 'U': 4, 'V': 5, 'W': 6, 'X': 7, 'Y': 8, 'Z': 9,
+}
+
+// Weight of each VIN position; position 9 holds the check digit itself
+var vinWeights = [17]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+// normalizeVIN upper-cases a VIN and drops the spaces and dashes sometimes printed inside it
+func normalizeVIN(vin string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(vin)))
+}
+
+// validateVIN reports whether vin is a 17-character VIN whose check digit
+// (position 9) matches the other characters. A misread character almost always
+// breaks the check. The check digit is only mandatory for North American
+// vehicles, so some valid European VINs fail it too.
+func validateVIN(vin string) bool {
+	vin = normalizeVIN(vin)
+	if len(vin) != 17 {
+		return false
+	}
+
+	sum := 0
+	for i, r := range vin {
+		value, ok := vinValues[r]
+		if !ok {
+			return false
+		}
+		sum += value * vinWeights[i]
+	}
+
+	check := byte('0' + sum%11)
+	if sum%11 == 10 {
+		check = 'X'
+	}
+	return vin[8] == check
+}
+
+// formatVINs lists the extracted VINs, marking each as valid or likely misread
+func formatVINs(vins []string, lang string) string {
+	var b strings.Builder
+	for _, vin := range vins {
+		vin = normalizeVIN(vin)
+		if vin == "" {
+			continue
+		}
+		status := t("vin.invalid", lang)
+		if validateVIN(vin) {
+			status = t("vin.valid", lang)
+		}
+		fmt.Fprintf(&b, "\n**%s:** %s %s", t("invoice.vin", lang), escapeMarkdown(vin), status)
+	}
+	return b.String()
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestValidateVIN(t *testing.T) {
+	tests := []struct {
+		name string
+		vin  string
+		want bool
+	}{
+		{"check digit X", "1M8GDM9AXKP042788", true},
+		{"check digit 3", "1HGCM82633A004352", true},
+		{"all ones", "11111111111111111", true},
+		{"lower case with spaces and dashes", " 1hgcm8-2633a 004352 ", true},
+		{"misread character", "1HGCM82633A004353", false},
+		{"wrong check digit", "1M8GDM9A1KP042788", false},
+		{"letter O for zero", "1HGCM82633AO04352", false},
+		{"letter I for one", "IHGCM82633A004352", false},
+		{"letter Q", "1HGCM82633AQ04352", false},
+		{"too short", "1HGCM82633A00435", false},
+		{"too long", "1HGCM82633A0043521", false},
+		{"punctuation", "1HGCM82633A00435*", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateVIN(tt.vin); got != tt.want {
+				t.Errorf("validateVIN(%q) = %v, want %v", tt.vin, got, tt.want)
+			}
+		})
+	}
+}
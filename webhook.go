@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// TelegramWebhookInfo is the subset of getWebhookInfo the bot logs and reports
+type TelegramWebhookInfo struct {
+	URL                string `json:"url"`
+	PendingUpdateCount int    `json:"pending_update_count"`
+	LastErrorDate      int64  `json:"last_error_date"`
+	LastErrorMessage   string `json:"last_error_message"`
+}
+
+type TelegramWebhookInfoResponse struct {
+	OK          bool                `json:"ok"`
+	Description string              `json:"description"`
+	Result      TelegramWebhookInfo `json:"result"`
+}
+
+// registerWebhook points Telegram at cfg.WebhookURL, passing the secret so incoming
+// requests can be verified by requireWebhookSecret
+func registerWebhook(ctx context.Context, cfg *Config) error {
+	payload := map[string]interface{}{
+		"url": cfg.WebhookURL,
+	}
+	if cfg.WebhookSecret != "" {
+		payload["secret_token"] = cfg.WebhookSecret
+	}
+	return callTelegramMethod(ctx, "setWebhook", payload)
+}
+
+// getWebhookInfo returns the webhook Telegram currently has for the bot
+func getWebhookInfo(ctx context.Context) (*TelegramWebhookInfo, error) {
+	url := fmt.Sprintf("%s/bot%s/getWebhookInfo", telegramAPIBase, telegramBotToken)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook info: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	var infoResponse TelegramWebhookInfoResponse
+	if err := json.Unmarshal(body, &infoResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook info response: %v", err)
+	}
+
+	if !infoResponse.OK {
+		return nil, fmt.Errorf("telegram API error: %s", infoResponse.Description)
+	}
+
+	return &infoResponse.Result, nil
+}
+
+// logWebhookInfo logs what getWebhookInfo reports, including the last delivery error
+func logWebhookInfo(ctx context.Context) {
+	info, err := getWebhookInfo(ctx)
+	if err != nil {
+		slog.Warn("Failed to get webhook info", "error", err)
+		return
+	}
+
+	attrs := []any{"url", info.URL, "pending_updates", info.PendingUpdateCount}
+	if info.LastErrorMessage != "" {
+		attrs = append(attrs,
+			"last_error", info.LastErrorMessage,
+			"last_error_at", time.Unix(info.LastErrorDate, 0).UTC().Format(time.RFC3339))
+	}
+	if info.URL == "" {
+		slog.Warn("No webhook is set; Telegram won't deliver updates until WEBHOOK_URL is set or setWebhook is called", attrs...)
+		return
+	}
+	slog.Info("Current webhook", attrs...)
+}
+
+// Handle /setwebhook: bot admins can re-register WEBHOOK_URL, e.g. after
+// Telegram dropped it or another deployment replaced it
+func handleSetWebhookCommand(ctx context.Context, message TelegramMessage, args string) {
+	chatID := message.Chat.ID
+	lang := languageFrom(ctx)
+	cfg := configFrom(ctx)
+
+	if !adminUserIDs[message.From.ID] {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("webhook.admins_only", lang))
+		return
+	}
+	if cfg.BotMode == "polling" {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("webhook.polling", lang))
+		return
+	}
+	if cfg.WebhookURL == "" {
+		sendTelegramMessage(ctx, chatID, message.MessageID, t("webhook.not_configured", lang))
+		return
+	}
+
+	if err := registerWebhook(ctx, cfg); err != nil {
+		replyError(ctx, chatID, message.MessageID, t("webhook.failed", lang), err)
+		return
+	}
+	loggerFrom(ctx).Info("Webhook registered by admin", "url", cfg.WebhookURL)
+
+	reply := t("webhook.set", lang, escapeMarkdown(cfg.WebhookURL))
+	if info, err := getWebhookInfo(ctx); err != nil {
+		loggerFrom(ctx).Warn("Failed to get webhook info", "error", err)
+	} else {
+		reply += "\n" + t("webhook.pending", lang, info.PendingUpdateCount)
+		if info.LastErrorMessage != "" {
+			reply += "\n" + t("webhook.last_error", lang, escapeMarkdown(info.LastErrorMessage))
+		}
+	}
+	sendTelegramMessage(ctx, chatID, message.MessageID, reply)
+}
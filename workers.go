@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Background processing of webhook and polling updates (WORKER_COUNT, WORKER_QUEUE_SIZE).
+// WORKER_COUNT caps how many updates are processed at once.
+var (
+	workerCount     = 4
+	workerQueueSize = 100
+
+	updateQueue chan TelegramUpdate
+	workersWG   sync.WaitGroup
+
+	// The queue is never closed, since the webhook and polling may still be
+	// sending when shutdown starts. Instead stopWorkers sets workersStopping
+	// under the write lock, so once it holds the lock nothing else is queued,
+	// and closes workersStop to tell the workers to drain the queue and exit.
+	workersMu       sync.RWMutex
+	workersStopping bool
+	workersStop     chan struct{}
+)
+
+// Deadline for handling one update or album, every download, OpenAI call and
+// reply included (UPDATE_TIMEOUT_SECONDS); 0 means no deadline
+var updateTimeout = 5 * time.Minute
+
+// At most this many overload replies are sent at once, so a burst can't pile up goroutines
+var overloadNotices = make(chan struct{}, 10)
+
+// startWorkers starts workerCount goroutines processing queued updates under cfg
+func startWorkers(cfg *Config) {
+	updateQueue = make(chan TelegramUpdate, workerQueueSize)
+	workersStop = make(chan struct{})
+	workersStopping = false
+	for i := 0; i < workerCount; i++ {
+		workersWG.Add(1)
+		go func() {
+			defer workersWG.Done()
+			for {
+				select {
+				case update := <-updateQueue:
+					runQueuedUpdate(cfg, update)
+				case <-workersStop:
+					// Nothing can be queued any more, so finish what's left and exit
+					for {
+						select {
+						case update := <-updateQueue:
+							runQueuedUpdate(cfg, update)
+						default:
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+}
+
+func runQueuedUpdate(cfg *Config, update TelegramUpdate) {
+	workersBusy.Inc()
+	defer workersBusy.Dec()
+	processUpdateSafely(cfg, update)
+}
+
+// enqueueUpdate hands an update to the workers. Returns false if the queue is
+// full or the workers are shutting down.
+func enqueueUpdate(update TelegramUpdate) bool {
+	workersMu.RLock()
+	defer workersMu.RUnlock()
+	if workersStopping {
+		return false
+	}
+
+	select {
+	case updateQueue <- update:
+		return true
+	default:
+		return false
+	}
+}
+
+// submitUpdate queues an update, or drops it when the queue is full and tells
+// the user to try again, rather than accepting work that would wait too long
+func submitUpdate(update TelegramUpdate) {
+	if enqueueUpdate(update) {
+		return
+	}
+
+	updatesRejected.Inc()
+	slog.Warn("Update queue full or shutting down, dropping update", "update_id", update.UpdateID, "queue_size", workerQueueSize)
+
+	select {
+	case overloadNotices <- struct{}{}:
+		go func() {
+			defer func() { <-overloadNotices }()
+			notifyOverloaded(context.Background(), update)
+		}()
+	default:
+	}
+}
+
+// notifyOverloaded answers a dropped update that asked the bot for work: a
+// button press, or a photo or file the bot would have read
+func notifyOverloaded(ctx context.Context, update TelegramUpdate) {
+	if query := update.CallbackQuery; query != nil {
+		answerCallbackQuery(ctx, query.ID, t("overloaded", userLanguage(query.From.LanguageCode)))
+		return
+	}
+
+	message := update.Message
+	if len(message.Photo) == 0 && message.Document == nil && pastedURL(message.Text) == "" {
+		return
+	}
+	if !isAuthorized(message.Chat.ID, message.From.ID) || !isAddressedToBot(message) {
+		return
+	}
+	sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("overloaded", userLanguage(message.From.LanguageCode)))
+}
+
+// stopWorkers stops queueing updates and waits, up to ctx's deadline, for the
+// queued ones and any buffered albums to finish. Updates submitted after it's
+// called are dropped like those that find the queue full.
+func stopWorkers(ctx context.Context) error {
+	workersMu.Lock()
+	if !workersStopping {
+		workersStopping = true
+		close(workersStop)
+	}
+	workersMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		workersWG.Wait()
+		// Queued updates may have buffered album photos; don't wait out their window
+		flushMediaGroups()
+		mediaGroupsWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newUpdateContext returns the context an update or album is processed under,
+// cancelled once updateTimeout has passed
+func newUpdateContext(correlationID string) (context.Context, context.CancelFunc) {
+	ctx := withCorrelationID(context.Background(), correlationID)
+	if updateTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, updateTimeout)
+}
+
+// processUpdateSafely keeps one bad update from taking down a worker or the
+// polling loop, and lets the user know something went wrong
+func processUpdateSafely(cfg *Config, update TelegramUpdate) {
+	ctx, cancel := newUpdateContext(newCorrelationID())
+	defer cancel()
+	ctx = withConfig(ctx, cfg)
+	ctx = withLogger(ctx, loggerFrom(ctx).With("update_id", update.UpdateID))
+
+	defer func() {
+		if r := recover(); r != nil {
+			raw, _ := json.Marshal(update)
+			loggerFrom(ctx).Error("Panic while processing update", "panic", fmt.Sprint(r), "update", string(raw), "stack", string(debug.Stack()))
+			if chatID := update.Message.Chat.ID; chatID != 0 {
+				// Not the chat's /language setting: reading it may be what panicked
+				ctx = withLanguage(ctx, userLanguage(update.Message.From.LanguageCode))
+				sendTelegramMessage(context.WithoutCancel(ctx), chatID, update.Message.MessageID, withReference(ctx, t("unexpected_error", languageFrom(ctx))))
+			}
+		}
+	}()
+
+	processUpdate(ctx, update)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		loggerFrom(ctx).Warn("Update deadline exceeded", "timeout_seconds", updateTimeout.Seconds())
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useTestWorkers starts a small worker pool running under cfg and puts the
+// previous pool's state back afterwards
+func useTestWorkers(t *testing.T, cfg *Config) {
+	t.Helper()
+	saved := []func(){
+		restore(&updateQueue, updateQueue),
+		restore(&workersStop, workersStop),
+		restore(&workersStopping, workersStopping),
+		restore(&workerCount, 2),
+		restore(&workerQueueSize, 10),
+	}
+	t.Cleanup(func() {
+		for _, undo := range saved {
+			undo()
+		}
+	})
+	startWorkers(cfg)
+}
+
+func TestStopWorkersWhileSubmitting(t *testing.T) {
+	captureLogs(t)
+	useFakeAPIs(t, &fakeTelegram{}, http.NotFoundHandler())
+	useTestWorkers(t, &Config{})
+
+	// Producers keep submitting through and after shutdown, as a slow webhook
+	// handler or the polling loop can after the shutdown timeout
+	var stop atomic.Bool
+	var producers sync.WaitGroup
+	for p := 0; p < 8; p++ {
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			for i := int64(0); !stop.Load(); i++ {
+				submitUpdate(TelegramUpdate{UpdateID: i})
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := stopWorkers(ctx); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	stop.Store(true)
+	producers.Wait()
+
+	if enqueueUpdate(TelegramUpdate{UpdateID: 1}) {
+		t.Error("update queued after shutdown")
+	}
+	if n := len(updateQueue); n != 0 {
+		t.Errorf("%d updates left in the queue", n)
+	}
+}
+
+func TestStopWorkersFlushesAlbums(t *testing.T) {
+	telegram := &fakeTelegram{}
+	useFakeAPIs(t, telegram, openAIReply(http.StatusOK, `{"vendor":"Shutdown Garage","currency":"USD","total":"5.00"}`))
+	useTestWorkers(t, &Config{})
+
+	update := photoUpdate(9950)
+	update.Message.MediaGroupID = "album"
+	submitUpdate(update)
+
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := stopWorkers(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed >= mediaGroupWindow {
+		t.Errorf("shutdown took %v, waiting out the album window", elapsed)
+	}
+	if sent := telegram.sent(); len(sent) != 1 {
+		t.Errorf("sent %q, want the album's reply before shutdown returns", sent)
+	}
+}
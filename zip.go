@@ -0,0 +1,298 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Accountants send ZIP archives of many invoices. Each image or Word/Excel
+// file inside is extracted, and the results come back in one combined reply.
+var zipMimeTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-zip-compressed": true,
+}
+
+const (
+	// Files read from one archive; the rest are listed as skipped
+	maxZipEntries = 20
+
+	// Total uncompressed bytes read from one archive, so a small zip can't
+	// expand without limit. The sizes in the zip headers aren't trusted.
+	maxZipUncompressedBytes = 100 * 1024 * 1024
+)
+
+var (
+	errZipTooLarge = errors.New("archive unpacks to more than the size limit")
+	errZipInvalid  = errors.New("archive could not be opened")
+)
+
+// zipEntry is a file read from an archive
+type zipEntry struct {
+	name string
+	data []byte
+}
+
+// isZipArchive reports whether a document is a ZIP archive
+func isZipArchive(mimeType, fileName string) bool {
+	return zipMimeTypes[strings.ToLower(mimeType)] || strings.EqualFold(filepath.Ext(fileName), ".zip")
+}
+
+// unzipEntries reads the files of an archive in memory, in name order. Folders
+// and macOS metadata are left out; files past maxZipEntries are counted in more,
+// and files that can't be read (e.g. encrypted ones) are returned in unreadable.
+func unzipEntries(content []byte) (entries []zipEntry, unreadable []string, more int, err error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: %v", errZipInvalid, err)
+	}
+
+	var files []*zip.File
+	for _, file := range archive.File {
+		base := path.Base(file.Name)
+		if file.FileInfo().IsDir() || strings.HasPrefix(file.Name, "__MACOSX/") || strings.HasPrefix(base, ".") {
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	if len(files) > maxZipEntries {
+		more = len(files) - maxZipEntries
+		files = files[:maxZipEntries]
+	}
+
+	remaining := int64(maxZipUncompressedBytes)
+	for _, file := range files {
+		data, err := readZipEntry(file, remaining)
+		if errors.Is(err, errZipTooLarge) {
+			return nil, nil, 0, err
+		}
+		if err != nil {
+			unreadable = append(unreadable, file.Name)
+			continue
+		}
+		remaining -= int64(len(data))
+		entries = append(entries, zipEntry{name: file.Name, data: data})
+	}
+	return entries, unreadable, more, nil
+}
+
+// readZipEntry reads one file, failing with errZipTooLarge past limit bytes
+func readZipEntry(file *zip.File, limit int64) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errZipTooLarge
+	}
+	return data, nil
+}
+
+// processZipArchive extracts every invoice in a ZIP document and replies once with all of them
+func processZipArchive(ctx context.Context, message TelegramMessage) {
+	document := message.Document
+	logger := loggerFrom(ctx).With("file_id", document.FileID)
+	ctx = withLogger(ctx, logger)
+	lang := languageFrom(ctx)
+
+	// Safe mode forbids sending the archive's images and documents to OpenAI
+	settings := getChatSettings(ctx, message.Chat.ID)
+	if settings.safeModeEnabled() {
+		logger.Info("Safe mode enabled, skipping archive extraction")
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("document.extraction_off", lang))
+		return
+	}
+
+	if reply := telegramFileSizeError(int64(document.FileSize), lang); reply != "" {
+		logger.Warn("Rejected archive: file too large", "file_size", document.FileSize, "max_file_size", maxFileSizeBytes)
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, reply)
+		return
+	}
+
+	content, err := downloadTelegramFile(ctx, document.FileID)
+	if err != nil {
+		replyError(ctx, message.Chat.ID, message.MessageID, downloadErrorMessage(err, t("download.file_failed", lang), lang), fmt.Errorf("downloading archive %s: %v", document.FileID, err))
+		return
+	}
+
+	entries, unreadable, more, err := unzipEntries(content)
+	switch {
+	case errors.Is(err, errZipTooLarge):
+		logger.Warn("Rejected archive: unpacks too large", "max_bytes", maxZipUncompressedBytes)
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("zip.too_large", lang, maxZipUncompressedBytes/(1024*1024)))
+		return
+	case err != nil:
+		replyError(ctx, message.Chat.ID, message.MessageID, t("zip.invalid", lang), fmt.Errorf("opening archive: %v", err))
+		return
+	}
+	logger.Info("Archive unpacked", "files", len(entries), "unreadable", len(unreadable), "over_limit", more)
+
+	start := time.Now()
+	prompt := withUserNote(buildPrompt(invoiceExtractionPrompt, settings), message.Caption)
+	totals := make(map[string]*Decimal)
+	var currencies []string
+	var skipped []string
+	var results strings.Builder
+	var totalUsage Usage
+	listed := 0
+
+	for _, entry := range entries {
+		name := escapeMarkdown(path.Base(entry.name))
+		entryCtx := withLogger(ctx, logger.With("zip_entry", entry.name))
+
+		invoice, usage, reply := extractZipEntry(entryCtx, entry, prompt, lang)
+		totalUsage.add(usage)
+		if reply == "" && invoice == nil {
+			skipped = append(skipped, t("zip.skip_unsupported", lang, name))
+			continue
+		}
+
+		listed++
+		results.WriteString("\n\n" + t("zip.file", lang, listed, name) + "\n")
+		if invoice == nil {
+			results.WriteString(reply)
+			continue
+		}
+
+		recordChatUsage(message.Chat.ID, usage)
+		saveInvoice(message.Chat.ID, message.MessageID, invoice, contentHash(entry.data))
+		results.WriteString(formatInvoice(invoice, settings, lang))
+		if invoice.Total != nil {
+			if totals[invoice.Currency] == nil {
+				totals[invoice.Currency] = &Decimal{}
+				currencies = append(currencies, invoice.Currency)
+			}
+			sum := totals[invoice.Currency]
+			sum.Add(&sum.Rat, &invoice.Total.Rat)
+		}
+	}
+	for _, name := range unreadable {
+		skipped = append(skipped, t("zip.skip_unreadable", lang, escapeMarkdown(path.Base(name))))
+	}
+	if more > 0 {
+		skipped = append(skipped, t("zip.skip_limit", lang, more, maxZipEntries))
+	}
+
+	if listed == 0 {
+		logger.Info("Archive has no readable files")
+		sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, t("zip.empty", lang)+skippedList(skipped, lang))
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(t("zip.title", lang, listed))
+	b.WriteString(results.String())
+	if len(currencies) > 0 {
+		var amounts []string
+		for _, currency := range currencies {
+			amounts = append(amounts, formatMoney(totals[currency], currency))
+		}
+		b.WriteString("\n\n" + t("zip.totals", lang, strings.Join(amounts, ", ")))
+	}
+	b.WriteString(skippedList(skipped, lang))
+	b.WriteString(usageFooter(totalUsage, lang))
+
+	logger.Info("Archive extracted", "files", listed, "skipped", len(skipped), "duration_ms", time.Since(start).Milliseconds())
+	if err := sendTelegramMessage(ctx, message.Chat.ID, message.MessageID, b.String()); err != nil {
+		logger.Error("Error sending archive result", "error", err)
+	}
+}
+
+// extractZipEntry runs one file from an archive through the image or document
+// extraction. It returns the invoice, or a reply explaining why there's none;
+// both are empty when the file type isn't supported at all.
+func extractZipEntry(ctx context.Context, entry zipEntry, prompt, lang string) (*Invoice, Usage, string) {
+	logger := loggerFrom(ctx)
+	fileHash := contentHash(entry.data)
+
+	if officeType := officeDocumentType("", entry.name); officeType != "" {
+		text, err := officeDocumentTypes[officeType](entry.data)
+		if errors.Is(err, errNoDocumentText) {
+			return nil, Usage{}, t("document.no_text", lang)
+		}
+		if err != nil {
+			logger.Error("Error reading archived document", "error", err)
+			return nil, Usage{}, t("document.unreadable", lang)
+		}
+		if len(text) > maxOfficeTextLength {
+			text = strings.ToValidUTF8(text[:maxOfficeTextLength], "")
+		}
+		if dryRun {
+			return nil, Usage{}, t("dry_run.document", lang, len(text))
+		}
+
+		invoice, usage, err := cachedInvoice(ctx, fileHash, prompt, openAIModel, func() (*Invoice, Usage, error) {
+			return extractInvoiceFieldsFromText(ctx, text, prompt, openAIModel)
+		})
+		recordExtraction("invoice", err)
+		if err != nil {
+			logger.Error("Error extracting invoice fields from archived document", "error", err)
+			return nil, usage, openAIErrorMessage(err, t("album.extract_failed", lang), lang)
+		}
+		return invoice, usage, ""
+	}
+
+	if strings.EqualFold(path.Ext(entry.name), ".pdf") {
+		return nil, Usage{}, t("zip.pdf", lang)
+	}
+
+	mimeType := documentMimeType("", entry.name)
+	if _, supported := documentImageDecoders[mimeType]; !supported {
+		return nil, Usage{}, ""
+	}
+
+	content, err := imageForExtraction(entry.data, mimeType)
+	if err != nil {
+		logger.Error("Error converting archived image", "mime_type", mimeType, "error", err)
+		return nil, Usage{}, t("document.unreadable_image", lang)
+	}
+	imageURL := prepareForExtraction(ctx, content)
+
+	if dryRun {
+		return nil, Usage{}, dryRunReply(imageURL, "invoice", openAIModel, lang)
+	}
+
+	if moderationEnabled {
+		flagged, categories, err := moderateImage(ctx, imageURL)
+		if err != nil {
+			logger.Error("Error running moderation check on archived image", "error", err)
+			return nil, Usage{}, openAIErrorMessage(err, t("album.process_failed", lang), lang)
+		}
+		if flagged {
+			logger.Warn("Archived image flagged by moderation", "categories", categories)
+			return nil, Usage{}, moderationRefusal(lang)
+		}
+	}
+
+	invoice, usage, err := cachedInvoiceExtraction(ctx, fileHash, imageURL, prompt, openAIModel)
+	recordExtraction("invoice", err)
+	if err != nil {
+		logger.Error("Error extracting invoice fields from archived image", "error", err)
+		return nil, usage, openAIErrorMessage(err, t("album.extract_failed", lang), lang)
+	}
+	return invoice, usage, ""
+}
+
+// skippedList lists the archive files that weren't extracted, or "" if none were skipped
+func skippedList(skipped []string, lang string) string {
+	if len(skipped) == 0 {
+		return ""
+	}
+	return "\n\n" + t("zip.skipped", lang) + "\n• " + strings.Join(skipped, "\n• ")
+}